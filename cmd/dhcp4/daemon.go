@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/Kseleven/agile-dhcp/dhcp4"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const defaultPidFile = "/run/dhcp_client4.pid"
+
+// daemonConfig is the configuration file of daemon mode.
+type daemonConfig struct {
+	PidFile    string            `json:"pidfile"`
+	Interfaces []interfaceConfig `json:"interfaces"`
+}
+
+type interfaceConfig struct {
+	Name                 string `json:"name"`
+	Mac                  string `json:"mac"` //hardware address of the interface by default
+	Server               string `json:"server"`
+	Relay                string `json:"relay"`
+	HostName             string `json:"hostname"`
+	FQDN                 string `json:"fqdn"`
+	FQDNFlags            string `json:"fqdnFlags"`
+	Profile              string `json:"profile"`
+	ParameterRequestList string `json:"prl"`
+	NoV6Only             bool   `json:"noV6Only"`
+	ARP                  bool   `json:"arp"`
+	Apply                bool   `json:"apply"`
+	DryRun               bool   `json:"dryRun"`
+	Hook                 string `json:"hook"`
+}
+
+type session struct {
+	config interfaceConfig
+	conn   *dhcp4.Conn
+	done   chan bool
+}
+
+type daemon struct {
+	configFile string
+	pidFile    string
+	sessions   map[string]*session
+}
+
+// runDaemon keeps leases on the configured interfaces until SIGTERM:
+// SIGHUP reloads the configuration file, SIGUSR1 renews all leases
+// and SIGTERM releases them and exits.
+func runDaemon() {
+	config, err := loadDaemonConfig(configFile)
+	if err != nil {
+		fmt.Printf("load config failed:%s\n", err.Error())
+		os.Exit(1)
+	}
+
+	d := &daemon{configFile: configFile, pidFile: pidFile, sessions: make(map[string]*session)}
+	if config.PidFile != "" {
+		d.pidFile = config.PidFile
+	}
+	if err := os.WriteFile(d.pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		fmt.Printf("write pidfile failed:%s\n", err.Error())
+		os.Exit(1)
+	}
+	defer os.Remove(d.pidFile)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, daemonSignals...)
+	d.update(config)
+
+	for sig := range signals {
+		switch sig {
+		case reloadSignal:
+			d.reload()
+		case renewSignal:
+			for _, s := range d.sessions {
+				s.conn.Renewal()
+			}
+		default:
+			fmt.Printf("%s received, releasing leases\n", sig)
+			d.stopAll(true)
+			return
+		}
+	}
+}
+
+// loadDaemonConfig reads the configuration file, or builds the configuration from the flags without one.
+func loadDaemonConfig(path string) (*daemonConfig, error) {
+	if path == "" {
+		if ifname == "" {
+			return nil, fmt.Errorf("daemon mode requires interfaces(-i) or a config file(-config)")
+		}
+		config := &daemonConfig{}
+		for _, name := range strings.Split(ifname, ",") {
+			config.Interfaces = append(config.Interfaces, interfaceConfig{
+				Name:                 strings.TrimSpace(name),
+				Mac:                  macFlag(),
+				Server:               serverHost,
+				Relay:                relay,
+				HostName:             hostName,
+				FQDN:                 fqdn,
+				FQDNFlags:            fqdnFlags,
+				Profile:              profile,
+				ParameterRequestList: prl,
+				NoV6Only:             noV6Only,
+				ARP:                  arpCheck,
+				Apply:                apply,
+				DryRun:               dryRun,
+				Hook:                 hookScript,
+			})
+		}
+		return config, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &daemonConfig{}
+	if err := json.Unmarshal(b, config); err != nil {
+		return nil, fmt.Errorf("parse %s failed:%s", path, err.Error())
+	}
+
+	names := make(map[string]bool)
+	for i, ifc := range config.Interfaces {
+		if ifc.Name == "" {
+			return nil, fmt.Errorf("%s: interfaces[%d] has no name", path, i)
+		}
+		if names[ifc.Name] {
+			return nil, fmt.Errorf("%s: interface %s is configured twice", path, ifc.Name)
+		}
+		names[ifc.Name] = true
+	}
+	return config, nil
+}
+
+// macFlag returns -m if it was given, empty to use the hardware address of each interface.
+func macFlag() string {
+	var set bool
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "m" {
+			set = true
+		}
+	})
+	if set {
+		return mac
+	}
+	return ""
+}
+
+func (d *daemon) reload() {
+	config, err := loadDaemonConfig(d.configFile)
+	if err != nil {
+		fmt.Printf("reload config failed:%s\n", err.Error())
+		return
+	}
+	fmt.Println("config reloaded")
+	d.update(config)
+}
+
+// update starts sessions for new interfaces, releases the leases of removed interfaces
+// and restarts sessions whose configuration changed, verifying their lease with INIT-REBOOT.
+func (d *daemon) update(config *daemonConfig) {
+	configured := make(map[string]interfaceConfig)
+	for _, ifc := range config.Interfaces {
+		configured[ifc.Name] = ifc
+	}
+
+	for name, s := range d.sessions {
+		ifc, ok := configured[name]
+		if ok && ifc == s.config {
+			continue
+		}
+		s.conn.Stop(!ok)
+		<-s.done
+		delete(d.sessions, name)
+		if ok {
+			lease := s.conn.Lease
+			s.conn.SetLease(nil)
+			d.start(ifc, lease)
+		}
+	}
+	for name, ifc := range configured {
+		if _, ok := d.sessions[name]; !ok {
+			d.start(ifc, nil)
+		}
+	}
+}
+
+func (d *daemon) start(ifc interfaceConfig, lease *dhcp4.Lease) {
+	c, err := newDaemonConn(ifc)
+	if err != nil {
+		fmt.Printf("start %s failed:%s\n", ifc.Name, err.Error())
+		return
+	}
+
+	c.SetLease(lease)
+	s := &session{config: ifc, conn: c, done: make(chan bool)}
+	d.sessions[ifc.Name] = s
+	go func() {
+		defer close(s.done)
+		if err := c.Run(); err != nil {
+			fmt.Printf("%s stopped:%s\n", ifc.Name, err.Error())
+		}
+		c.Close()
+	}()
+}
+
+func (d *daemon) stopAll(release bool) {
+	var wg sync.WaitGroup
+	for _, s := range d.sessions {
+		wg.Add(1)
+		go func(s *session) {
+			defer wg.Done()
+			s.conn.Stop(release)
+			<-s.done
+		}(s)
+	}
+	wg.Wait()
+}
+
+func newDaemonConn(ifc interfaceConfig) (*dhcp4.Conn, error) {
+	ifi, err := net.InterfaceByName(ifc.Name)
+	if err != nil {
+		return nil, err
+	}
+	hw := ifc.Mac
+	if hw == "" {
+		if len(ifi.HardwareAddr) == 0 {
+			return nil, fmt.Errorf("interface %s has no hardware address, set the mac", ifc.Name)
+		}
+		hw = ifi.HardwareAddr.String()
+	}
+	server := ifc.Server
+	if server == "" {
+		server = "255.255.255.255"
+	}
+
+	c, err := dhcp4.NewDHCPClient(server, ifc.Relay, ifc.HostName, hw)
+	if err != nil {
+		return nil, err
+	}
+	if err := setupDaemonConn(c, ifi, ifc); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func setupDaemonConn(c *dhcp4.Conn, ifi *net.Interface, ifc interfaceConfig) error {
+	var err error
+	if err = c.SetInterface(ifc.Name); err != nil {
+		return err
+	}
+	if ifc.Profile != "" {
+		if c.Profile, err = dhcp4.LookupProfile(ifc.Profile); err != nil {
+			return err
+		}
+	}
+	if ifc.ParameterRequestList != "" {
+		if c.ParameterRequestList, err = dhcp4.ParseParameterRequestList(ifc.ParameterRequestList); err != nil {
+			return err
+		}
+	}
+	if ifc.FQDN != "" {
+		flags, err := dhcp4.ParseFQDNFlags(ifc.FQDNFlags)
+		if err != nil {
+			return err
+		}
+		if err := c.SetFQDN(ifc.FQDN, flags); err != nil {
+			return err
+		}
+	}
+	c.DisableIPv6OnlyPreferred = ifc.NoV6Only
+	c.ConflictDetection = ifc.ARP
+	c.LinkWatch = true
+	if ifc.Apply {
+		c.Configurator = dhcp4.NewConfigurator(ifi, ifc.DryRun)
+	}
+	if ifc.Hook != "" {
+		c.Hooks = append(c.Hooks, dhcp4.NewScriptHook(ifc.Hook))
+	}
+	return nil
+}
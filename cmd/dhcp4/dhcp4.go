@@ -1,18 +1,47 @@
 package main
 
 import (
+	"bytes"
+	"encoding/hex"
 	"flag"
+	"fmt"
 	"github.com/Kseleven/agile-dhcp/dhcp4"
+	"github.com/Kseleven/agile-dhcp/metrics"
+	"github.com/Kseleven/agile-dhcp/tftp"
+	"io"
+	"net"
+	"os"
+	"strings"
 )
 
 var (
-	serverHost string
-	hostName   string
-	relay      string
-	mac        string
-	count      int
-	decline    string
-	release    string
+	serverHost  string
+	hostName    string
+	relay       string
+	mac         string
+	count       int
+	decline     string
+	release     string
+	fqdn        string
+	fqdnFlags   string
+	profile     string
+	prl         string
+	noV6Only    bool
+	pxeArch     string
+	pxeUUID     string
+	tftpFetch   bool
+	tftpOut     string
+	tftpBlock   int
+	tftpSHA256  string
+	ifname      string
+	arpCheck    bool
+	apply       bool
+	dryRun      bool
+	hookScript  string
+	daemonMode  bool
+	configFile  string
+	pidFile     string
+	metricsAddr string
 )
 
 func main() {
@@ -23,7 +52,43 @@ func main() {
 	flag.StringVar(&release, "r", "", "release address")
 	flag.StringVar(&mac, "m", "00:00:00:00:00:00", "client mac address(option 12)")
 	flag.IntVar(&count, "c", 1, "numbers client")
+	flag.StringVar(&fqdn, "f", "", "client FQDN(option 81)")
+	flag.StringVar(&fqdnFlags, "F", "S", "client FQDN flags(option 81), S: server updates A RR, N: no DNS updates")
+	flag.StringVar(&profile, "p", "", "device profile filling in option 60/55/57, \"list\" to show the profiles")
+	flag.StringVar(&prl, "l", "", "parameter request list(option 55) sent in the given order, e.g. 1,3,6,15")
+	flag.BoolVar(&noV6Only, "no-v6only", false, "do not request IPv6-Only Preferred(option 108)")
+	flag.StringVar(&pxeArch, "pxe", "", "act as a PXE client of architecture "+strings.Join(dhcp4.PXEArchitectureNames(), ", "))
+	flag.StringVar(&pxeUUID, "uuid", "", "PXE client machine UUID(option 97), derived from the mac address by default")
+	flag.BoolVar(&tftpFetch, "tftp", false, "download the offered boot file over TFTP")
+	flag.StringVar(&tftpOut, "tftp-out", "", "file to save the boot file to, discarded by default")
+	flag.IntVar(&tftpBlock, "tftp-blksize", tftp.DefaultOptions.BlockSize, "TFTP block size(blksize option)")
+	flag.StringVar(&tftpSHA256, "tftp-sha256", "", "expected SHA-256 checksum of the boot file")
+	flag.StringVar(&ifname, "i", "", "network interface")
+	flag.BoolVar(&arpCheck, "arp", false, "ARP-probe the acknowledged address on the interface and decline it if in use")
+	flag.BoolVar(&apply, "apply", false, "configure the acknowledged lease on the interface, remove it on release")
+	flag.BoolVar(&dryRun, "dry-run", false, "print the interface configuration changes of -apply without making them")
+	flag.StringVar(&hookScript, "hook", "", "script run on lease events with the lease in environment variables, like dhclient-script")
+	flag.BoolVar(&daemonMode, "daemon", false, "keep leases on the interfaces(-i eth0,eth1 or -config) until SIGTERM, SIGHUP reloads the config, SIGUSR1 renews")
+	flag.StringVar(&configFile, "config", "", "daemon config file(json)")
+	flag.StringVar(&pidFile, "pidfile", defaultPidFile, "daemon pidfile")
+	flag.StringVar(&metricsAddr, "metrics", "", "serve Prometheus metrics on http://addr/metrics, e.g. :9167")
 	flag.Parse()
+	if metricsAddr != "" {
+		metrics.Default.ListenAndServe(metricsAddr)
+	}
+
+	if profile == "list" {
+		for _, name := range dhcp4.ProfileNames() {
+			p, _ := dhcp4.LookupProfile(name)
+			fmt.Println(p.String())
+		}
+		return
+	}
+
+	if daemonMode {
+		runDaemon()
+		return
+	}
 
 	if decline != "" {
 		c, err := dhcp4.NewDHCPRequest(serverHost, relay, hostName, mac)
@@ -42,6 +107,14 @@ func main() {
 		if err != nil {
 			panic(err)
 		}
+		if apply {
+			if err := setConfigurator(c); err != nil {
+				panic(err)
+			}
+		}
+		if hookScript != "" {
+			c.Hooks = append(c.Hooks, dhcp4.NewScriptHook(hookScript))
+		}
 		if err := c.Release(release); err != nil {
 			panic(err)
 		}
@@ -54,9 +127,103 @@ func main() {
 		if err != nil {
 			panic(err)
 		}
+		if profile != "" {
+			if c.Profile, err = dhcp4.LookupProfile(profile); err != nil {
+				panic(err)
+			}
+		}
+		if prl != "" {
+			if c.ParameterRequestList, err = dhcp4.ParseParameterRequestList(prl); err != nil {
+				panic(err)
+			}
+		}
+		c.DisableIPv6OnlyPreferred = noV6Only
+		c.ConflictDetection = arpCheck
+		if ifname != "" {
+			if err := c.SetInterface(ifname); err != nil {
+				panic(err)
+			}
+		}
+		if apply {
+			if err := setConfigurator(c); err != nil {
+				panic(err)
+			}
+		}
+		if hookScript != "" {
+			c.Hooks = append(c.Hooks, dhcp4.NewScriptHook(hookScript))
+		}
+		if pxeArch != "" {
+			if c.PXE, err = dhcp4.NewPXE(pxeArch, pxeUUID, c.MacByte); err != nil {
+				panic(err)
+			}
+		}
+		if fqdn != "" {
+			flags, err := dhcp4.ParseFQDNFlags(fqdnFlags)
+			if err != nil {
+				panic(err)
+			}
+			if err := c.SetFQDN(fqdn, flags); err != nil {
+				panic(err)
+			}
+		}
 		if err := c.Discovery(); err != nil {
 			panic(err)
 		}
 		c.WaitDone()
+		if c.V6OnlyWait > 0 {
+			fmt.Printf("IPv6-only network, DHCPv4 disabled for %s\n", c.V6OnlyWait)
+		}
+		if c.Lease != nil {
+			fmt.Printf("lease:\n%s\n", c.Lease.String())
+			if tftpFetch {
+				fetchBootFile(c.Lease)
+			}
+		}
+	}
+}
+
+func setConfigurator(c *dhcp4.Conn) error {
+	if ifname == "" {
+		return fmt.Errorf("-apply requires an interface(-i)")
+	}
+	ifi, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return err
+	}
+
+	c.Configurator = dhcp4.NewConfigurator(ifi, dryRun)
+	return nil
+}
+
+func fetchBootFile(lease *dhcp4.Lease) {
+	var w io.Writer = io.Discard
+	if tftpOut != "" {
+		f, err := os.Create(tftpOut)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	server, file := lease.BootSource()
+	fmt.Printf("tftp get %s from %s\n", file, server)
+	opts := tftp.DefaultOptions
+	opts.BlockSize = tftpBlock
+	result, err := lease.FetchBootFile(w, opts)
+	if err != nil {
+		fmt.Printf("tftp failed:%s\n", err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("tftp received %d bytes(tsize %d, blksize %d) in %s, sha256 %s\n",
+		result.Size, result.TransferSize, result.BlockSize, result.Duration, hex.EncodeToString(result.SHA256))
+
+	if tftpSHA256 != "" {
+		expected, err := hex.DecodeString(tftpSHA256)
+		if err != nil || !bytes.Equal(expected, result.SHA256) {
+			fmt.Printf("tftp checksum mismatch, expected %s\n", tftpSHA256)
+			os.Exit(1)
+		}
+		fmt.Println("tftp checksum ok")
 	}
 }
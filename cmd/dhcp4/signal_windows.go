@@ -0,0 +1,15 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// reload and renewal signals do not exist on windows
+var (
+	reloadSignal  os.Signal
+	renewSignal   os.Signal
+	daemonSignals = []os.Signal{syscall.SIGTERM, os.Interrupt}
+)
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/Kseleven/agile-dhcp/metrics"
+	"github.com/Kseleven/agile-dhcp/server4"
+	"os"
+	"os/signal"
+	"strconv"
+)
+
+const defaultPidFile = "/run/dhcp_server4.pid"
+
+var (
+	configFile  string
+	pidFile     string
+	checkOnly   bool
+	metricsAddr string
+)
+
+// dhcp_server4 serves the subnets of its configuration file until SIGTERM, SIGHUP reloads the file
+// and SIGUSR1 tells the server its unreachable failover partner is down.
+func main() {
+	flag.StringVar(&configFile, "config", "", "server config file(json)")
+	flag.StringVar(&pidFile, "pidfile", defaultPidFile, "pidfile, empty to write none")
+	flag.BoolVar(&checkOnly, "check", false, "validate the config file and exit")
+	flag.StringVar(&metricsAddr, "metrics", "", "serve Prometheus metrics on http://addr/metrics, e.g. :9167")
+	flag.Parse()
+
+	if configFile == "" {
+		fmt.Println("config file(-config) is required")
+		os.Exit(2)
+	}
+	config, err := server4.LoadConfig(configFile)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	if checkOnly {
+		fmt.Printf("%s is valid\n", configFile)
+		return
+	}
+
+	store, err := server4.OpenStore(config.LeaseStore.Type, config.LeaseStore.Path)
+	if err != nil {
+		fmt.Printf("open lease store failed:%s\n", err.Error())
+		os.Exit(1)
+	}
+	defer store.Close()
+
+	s, err := server4.NewServer(config, store)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	if metricsAddr != "" {
+		s.RegisterMetrics(metrics.Default)
+		metrics.Default.ListenAndServe(metricsAddr)
+	}
+
+	if pidFile != "" {
+		if err := os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+			fmt.Printf("write pidfile failed:%s\n", err.Error())
+			os.Exit(1)
+		}
+		defer os.Remove(pidFile)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, serverSignals...)
+	go func() {
+		for sig := range signals {
+			if sig == reloadSignal {
+				reload(s)
+				continue
+			}
+			if sig == partnerDownSignal {
+				if err := s.PartnerDown(); err != nil {
+					fmt.Printf("partner down failed:%s\n", err.Error())
+				}
+				continue
+			}
+			fmt.Printf("%s received, stopping\n", sig)
+			s.Close()
+			return
+		}
+	}()
+
+	if err := s.Serve(); err != nil {
+		fmt.Printf("serve failed:%s\n", err.Error())
+		store.Close()
+		if pidFile != "" {
+			os.Remove(pidFile)
+		}
+		os.Exit(1)
+	}
+}
+
+// reload applies the config file again, an invalid file leaves the running configuration as it is.
+func reload(s *server4.Server) {
+	config, err := server4.LoadConfig(configFile)
+	if err != nil {
+		fmt.Printf("reload config failed:\n%s\n", err.Error())
+		return
+	}
+	if err := s.Reload(config); err != nil {
+		fmt.Printf("reload config failed:%s\n", err.Error())
+		return
+	}
+	fmt.Println("config reloaded")
+}
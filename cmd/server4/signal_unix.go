@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+var (
+	reloadSignal      os.Signal = syscall.SIGHUP
+	partnerDownSignal os.Signal = syscall.SIGUSR1
+	serverSignals               = []os.Signal{syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGTERM, os.Interrupt}
+)
@@ -0,0 +1,15 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// the reload and partner-down signals do not exist on windows
+var (
+	reloadSignal      os.Signal
+	partnerDownSignal os.Signal
+	serverSignals     = []os.Signal{syscall.SIGTERM, os.Interrupt}
+)
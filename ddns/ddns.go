@@ -0,0 +1,653 @@
+// Package ddns sends dynamic DNS updates (RFC 2136) signed with TSIG (RFC 8945), with the conflict
+// resolution of DHCP servers updating the names of their clients: the DHCID record (RFC 4701)
+// marks the client that owns a name, a name owned by another client is left alone (RFC 4703).
+package ddns
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPort  = 53
+	DefaultFudge = 300 //seconds of clock skew allowed by TSIG
+)
+
+// RR types and classes.
+const (
+	TypeA     uint16 = 1
+	TypeSOA   uint16 = 6
+	TypePTR   uint16 = 12
+	TypeAAAA  uint16 = 28
+	TypeDHCID uint16 = 49
+	TypeTSIG  uint16 = 250
+	TypeANY   uint16 = 255
+
+	ClassIN   uint16 = 1
+	ClassNONE uint16 = 254
+	ClassANY  uint16 = 255
+)
+
+// Response codes of an update (RFC 2136 section 2.2) and of TSIG (RFC 8945 section 5.3).
+const (
+	RcodeNoError  = 0
+	RcodeFormErr  = 1
+	RcodeServFail = 2
+	RcodeNXDomain = 3
+	RcodeNotImp   = 4
+	RcodeRefused  = 5
+	RcodeYXDomain = 6
+	RcodeYXRRSet  = 7
+	RcodeNXRRSet  = 8
+	RcodeNotAuth  = 9
+	RcodeNotZone  = 10
+	RcodeBadSig   = 16
+	RcodeBadKey   = 17
+	RcodeBadTime  = 18
+)
+
+const opcodeUpdate = 5
+
+var rcodeNames = map[int]string{
+	RcodeNoError: "NOERROR", RcodeFormErr: "FORMERR", RcodeServFail: "SERVFAIL", RcodeNXDomain: "NXDOMAIN",
+	RcodeNotImp: "NOTIMP", RcodeRefused: "REFUSED", RcodeYXDomain: "YXDOMAIN", RcodeYXRRSet: "YXRRSET",
+	RcodeNXRRSet: "NXRRSET", RcodeNotAuth: "NOTAUTH", RcodeNotZone: "NOTZONE",
+	RcodeBadSig: "BADSIG", RcodeBadKey: "BADKEY", RcodeBadTime: "BADTIME",
+}
+
+// RcodeError is an update refused by the server.
+type RcodeError struct {
+	Rcode int
+}
+
+func (e *RcodeError) Error() string {
+	if name, ok := rcodeNames[e.Rcode]; ok {
+		return "ddns: " + name
+	}
+	return "ddns: rcode " + strconv.Itoa(e.Rcode)
+}
+
+// IsRcode reports whether err is the response code rcode.
+func IsRcode(err error, rcode int) bool {
+	var re *RcodeError
+	return errors.As(err, &re) && re.Rcode == rcode
+}
+
+// ErrConflict is returned when a name is registered by another client.
+var ErrConflict = errors.New("ddns: name belongs to another client")
+
+// RR is a resource record of an update message, Data is the RDATA in wire format.
+type RR struct {
+	Name  string
+	Type  uint16
+	Class uint16
+	TTL   uint32
+	Data  []byte
+}
+
+// Message is an update of Zone: the update applies only if all the prerequisites hold.
+type Message struct {
+	ID            uint16
+	Zone          string
+	Prerequisites []RR
+	Updates       []RR
+}
+
+// Key is a TSIG key, Algorithm is hmac-md5, hmac-sha1, hmac-sha256 or hmac-sha512.
+type Key struct {
+	Name      string
+	Algorithm string
+	Secret    []byte
+}
+
+var algorithms = map[string]struct {
+	name string //in the TSIG record
+	hash func() hash.Hash
+}{
+	"hmac-md5":    {"hmac-md5.sig-alg.reg.int.", md5.New},
+	"hmac-sha1":   {"hmac-sha1.", sha1.New},
+	"hmac-sha256": {"hmac-sha256.", sha256.New},
+	"hmac-sha512": {"hmac-sha512.", sha512.New},
+}
+
+// ValidAlgorithm reports whether the TSIG algorithm name is supported.
+func ValidAlgorithm(name string) bool {
+	_, ok := algorithms[strings.ToLower(strings.TrimSuffix(name, "."))]
+	return ok
+}
+
+// Client sends updates to a DNS server over UDP, over TCP when the response is truncated.
+type Client struct {
+	Server  string        //host or host:port
+	Key     *Key          //signs the updates, nil to send them unsigned
+	Timeout time.Duration //per attempt
+	Retries int           //attempts after the first over UDP
+}
+
+// Exchange sends m and returns an error unless the server applied it.
+func (c *Client) Exchange(m *Message) error {
+	if m.ID == 0 {
+		var id [2]byte
+		rand.Read(id[:])
+		m.ID = binary.BigEndian.Uint16(id[:])
+	}
+	b, err := m.encode()
+	if err != nil {
+		return err
+	}
+	var requestMAC []byte
+	if c.Key != nil {
+		if b, requestMAC, err = c.Key.sign(b, nil, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	server := c.Server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, strconv.Itoa(DefaultPort))
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 3 * time.Second
+	}
+
+	var response []byte
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		if response, err = exchangeUDP(server, b, m.ID, timeout); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("ddns: update of %s failed:%s", m.Zone, err.Error())
+	}
+	if response[2]&0x02 != 0 {
+		if response, err = exchangeTCP(server, b, m.ID, timeout); err != nil {
+			return fmt.Errorf("ddns: update of %s over tcp failed:%s", m.Zone, err.Error())
+		}
+	}
+	return c.check(response, requestMAC)
+}
+
+func exchangeUDP(server string, b []byte, id uint16, timeout time.Duration) ([]byte, error) {
+	conn, err := net.DialTimeout("udp", server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write(b); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		if n >= 12 && binary.BigEndian.Uint16(buf) == id && buf[2]&0x80 != 0 {
+			return buf[:n], nil
+		}
+	}
+}
+
+func exchangeTCP(server string, b []byte, id uint16, timeout time.Duration) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write(append([]byte{byte(len(b) >> 8), byte(len(b))}, b...)); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := readFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	response := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := readFull(conn, response); err != nil {
+		return nil, err
+	}
+	if len(response) < 12 || binary.BigEndian.Uint16(response) != id {
+		return nil, errors.New("invalid response")
+	}
+	return response, nil
+}
+
+func readFull(conn net.Conn, b []byte) (int, error) {
+	n := 0
+	for n < len(b) {
+		m, err := conn.Read(b[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// check verifies the signature of response and returns its response code as an error.
+func (c *Client) check(response, requestMAC []byte) error {
+	rcode := int(response[3] & 0x0f)
+	tsig, err := findTSIG(response)
+	if err != nil {
+		return fmt.Errorf("ddns: invalid response:%s", err.Error())
+	}
+	if tsig != nil && tsig.err != 0 {
+		rcode = int(tsig.err)
+	}
+	//errors may be unsigned, as when the server does not know the key
+	if c.Key != nil && tsig == nil && rcode == RcodeNoError {
+		return errors.New("ddns: response is not signed")
+	}
+	if c.Key != nil && tsig != nil && tsig.err == 0 {
+		if err := c.Key.verify(response, tsig, requestMAC); err != nil {
+			return err
+		}
+	}
+	if rcode != RcodeNoError {
+		return &RcodeError{Rcode: rcode}
+	}
+	return nil
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint48(b []byte, v uint64) []byte {
+	return append(b, byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// encodeName encodes name in uncompressed wire format, lowercase when canonical.
+func encodeName(b []byte, name string, canonical bool) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if canonical {
+		name = strings.ToLower(name)
+	}
+	start := len(b)
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if len(label) == 0 || len(label) > 63 {
+				return nil, fmt.Errorf("ddns: invalid domain name %q", name)
+			}
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+	}
+	if len(b)-start+1 > 255 {
+		//255 octets at most with the root label
+		return nil, fmt.Errorf("ddns: domain name %q too long", name)
+	}
+	return append(b, 0), nil
+}
+
+// decodeName decodes the name at offset of message, following compression pointers,
+// and returns it with the offset after it.
+func decodeName(message []byte, offset int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if offset >= len(message) {
+			return "", 0, errors.New("truncated name")
+		}
+		l := int(message[offset])
+		switch {
+		case l == 0:
+			if end < 0 {
+				end = offset + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case l&0xc0 == 0xc0:
+			if offset+1 >= len(message) || jumps > 64 {
+				return "", 0, errors.New("invalid compression pointer")
+			}
+			if end < 0 {
+				end = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(message[offset:]) & 0x3fff)
+			jumps++
+		case l > 63 || offset+1+l > len(message):
+			return "", 0, errors.New("invalid label")
+		default:
+			labels = append(labels, string(message[offset+1:offset+1+l]))
+			offset += 1 + l
+		}
+	}
+}
+
+func (m *Message) encode() ([]byte, error) {
+	b := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(b[0:], m.ID)
+	b[2] = opcodeUpdate << 3
+	binary.BigEndian.PutUint16(b[4:], 1)
+	binary.BigEndian.PutUint16(b[6:], uint16(len(m.Prerequisites)))
+	binary.BigEndian.PutUint16(b[8:], uint16(len(m.Updates)))
+
+	var err error
+	if b, err = encodeName(b, m.Zone, false); err != nil {
+		return nil, err
+	}
+	b = append(b, byte(TypeSOA>>8), byte(TypeSOA), byte(ClassIN>>8), byte(ClassIN))
+	for _, rrs := range [][]RR{m.Prerequisites, m.Updates} {
+		for _, rr := range rrs {
+			if b, err = rr.encode(b); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b, nil
+}
+
+func (rr RR) encode(b []byte) ([]byte, error) {
+	b, err := encodeName(b, rr.Name, false)
+	if err != nil {
+		return nil, err
+	}
+	b = appendUint16(b, rr.Type)
+	b = appendUint16(b, rr.Class)
+	b = appendUint32(b, rr.TTL)
+	b = appendUint16(b, uint16(len(rr.Data)))
+	return append(b, rr.Data...), nil
+}
+
+// tsigRecord is the TSIG record of a message.
+type tsigRecord struct {
+	offset     int //of the record in the message
+	name       string
+	algorithm  string
+	timeSigned uint64
+	fudge      uint16
+	mac        []byte
+	originalID uint16
+	err        uint16
+	other      []byte
+}
+
+// findTSIG returns the TSIG record ending the additional section of message, nil if there is none.
+func findTSIG(message []byte) (*tsigRecord, error) {
+	if len(message) < 12 {
+		return nil, errors.New("short message")
+	}
+	counts := [4]int{}
+	for i := range counts {
+		counts[i] = int(binary.BigEndian.Uint16(message[4+2*i:]))
+	}
+	if counts[3] == 0 {
+		return nil, nil
+	}
+
+	offset := 12
+	var err error
+	for i := 0; i < counts[0]; i++ {
+		if _, offset, err = decodeName(message, offset); err != nil {
+			return nil, err
+		}
+		offset += 4
+	}
+	total := counts[1] + counts[2] + counts[3]
+	for i := 0; i < total; i++ {
+		start := offset
+		var name string
+		if name, offset, err = decodeName(message, offset); err != nil {
+			return nil, err
+		}
+		if offset+10 > len(message) {
+			return nil, errors.New("truncated record")
+		}
+		rrType := binary.BigEndian.Uint16(message[offset:])
+		length := int(binary.BigEndian.Uint16(message[offset+8:]))
+		offset += 10
+		if offset+length > len(message) {
+			return nil, errors.New("truncated record")
+		}
+		if i == total-1 && rrType == TypeTSIG {
+			return decodeTSIG(message, start, name, message[offset:offset+length])
+		}
+		offset += length
+	}
+	return nil, nil
+}
+
+func decodeTSIG(message []byte, offset int, name string, data []byte) (*tsigRecord, error) {
+	t := &tsigRecord{offset: offset, name: name}
+	algorithm, i, err := decodeName(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	t.algorithm = algorithm
+	if i+10 > len(data) {
+		return nil, errors.New("truncated TSIG")
+	}
+	t.timeSigned = uint64(binary.BigEndian.Uint16(data[i:]))<<32 | uint64(binary.BigEndian.Uint32(data[i+2:]))
+	t.fudge = binary.BigEndian.Uint16(data[i+6:])
+	macSize := int(binary.BigEndian.Uint16(data[i+8:]))
+	i += 10
+	if i+macSize+6 > len(data) {
+		return nil, errors.New("truncated TSIG")
+	}
+	t.mac = data[i : i+macSize]
+	i += macSize
+	t.originalID = binary.BigEndian.Uint16(data[i:])
+	t.err = binary.BigEndian.Uint16(data[i+2:])
+	otherLen := int(binary.BigEndian.Uint16(data[i+4:]))
+	if i+6+otherLen > len(data) {
+		return nil, errors.New("truncated TSIG")
+	}
+	t.other = data[i+6 : i+6+otherLen]
+	return t, nil
+}
+
+// variables returns the TSIG variables covered by the MAC (RFC 8945 section 4.3.3).
+func (k *Key) variables(algorithm string, timeSigned uint64, fudge, tsigErr uint16, other []byte) ([]byte, error) {
+	b, err := encodeName(nil, k.Name, true)
+	if err != nil {
+		return nil, err
+	}
+	b = appendUint16(b, ClassANY)
+	b = appendUint32(b, 0)
+	if b, err = encodeName(b, algorithm, true); err != nil {
+		return nil, err
+	}
+	b = appendUint48(b, timeSigned)
+	b = appendUint16(b, fudge)
+	b = appendUint16(b, tsigErr)
+	b = appendUint16(b, uint16(len(other)))
+	return append(b, other...), nil
+}
+
+func (k *Key) mac(data ...[]byte) ([]byte, error) {
+	a, ok := algorithms[strings.ToLower(strings.TrimSuffix(k.Algorithm, "."))]
+	if !ok {
+		return nil, fmt.Errorf("ddns: unsupported TSIG algorithm %s", k.Algorithm)
+	}
+	h := hmac.New(a.hash, k.Secret)
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil), nil
+}
+
+// sign appends a TSIG record to message, a response covers the MAC of its request.
+// It returns the signed message and its MAC.
+func (k *Key) sign(message, requestMAC []byte, now time.Time) ([]byte, []byte, error) {
+	a, ok := algorithms[strings.ToLower(strings.TrimSuffix(k.Algorithm, "."))]
+	if !ok {
+		return nil, nil, fmt.Errorf("ddns: unsupported TSIG algorithm %s", k.Algorithm)
+	}
+	timeSigned := uint64(now.Unix())
+	variables, err := k.variables(a.name, timeSigned, DefaultFudge, 0, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	var prefix []byte
+	if requestMAC != nil {
+		prefix = appendUint16(nil, uint16(len(requestMAC)))
+		prefix = append(prefix, requestMAC...)
+	}
+	mac, err := k.mac(prefix, message, variables)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, _ := encodeName(nil, a.name, true)
+	data = appendUint48(data, timeSigned)
+	data = appendUint16(data, DefaultFudge)
+	data = appendUint16(data, uint16(len(mac)))
+	data = append(data, mac...)
+	data = append(data, message[0], message[1])
+	data = appendUint16(data, 0)
+	data = appendUint16(data, 0)
+	signed, err := RR{Name: k.Name, Type: TypeTSIG, Class: ClassANY, Data: data}.encode(append([]byte(nil), message...))
+	if err != nil {
+		return nil, nil, err
+	}
+	binary.BigEndian.PutUint16(signed[10:], binary.BigEndian.Uint16(signed[10:])+1)
+	return signed, mac, nil
+}
+
+// verify checks the TSIG record t of response to the request signed with requestMAC.
+func (k *Key) verify(response []byte, t *tsigRecord, requestMAC []byte) error {
+	if !strings.EqualFold(strings.TrimSuffix(t.name, "."), strings.TrimSuffix(k.Name, ".")) {
+		return fmt.Errorf("ddns: response signed with key %s", t.name)
+	}
+	if a := algorithms[strings.ToLower(strings.TrimSuffix(k.Algorithm, "."))]; !strings.EqualFold(t.algorithm, a.name) {
+		return fmt.Errorf("ddns: response signed with algorithm %s", t.algorithm)
+	}
+	unsigned := append([]byte(nil), response[:t.offset]...)
+	binary.BigEndian.PutUint16(unsigned[0:], t.originalID)
+	binary.BigEndian.PutUint16(unsigned[10:], binary.BigEndian.Uint16(unsigned[10:])-1)
+	variables, err := k.variables(t.algorithm, t.timeSigned, t.fudge, t.err, t.other)
+	if err != nil {
+		return err
+	}
+	prefix := appendUint16(nil, uint16(len(requestMAC)))
+	prefix = append(prefix, requestMAC...)
+	mac, err := k.mac(prefix, unsigned, variables)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(mac, t.mac) {
+		return errors.New("ddns: invalid response signature")
+	}
+	if skew := time.Since(time.Unix(int64(t.timeSigned), 0)); skew > time.Duration(t.fudge)*time.Second ||
+		-skew > time.Duration(t.fudge)*time.Second {
+		return errors.New("ddns: response signed outside the allowed time")
+	}
+	return nil
+}
+
+// DHCID identifier types (RFC 4701 section 3.3).
+const (
+	IdentifierHardware uint16 = 0x0000 //htype and chaddr of a DHCPv4 message
+	IdentifierClientID uint16 = 0x0001 //client identifier option of DHCPv4
+	IdentifierDUID     uint16 = 0x0002
+)
+
+// DHCID returns the RDATA of the DHCID record of the client with identifier on fqdn (RFC 4701 section 3.5):
+// the identifier type, digest type 1 and the SHA-256 digest of the identifier and the canonical name.
+func DHCID(identifierType uint16, identifier []byte, fqdn string) ([]byte, error) {
+	name, err := encodeName(nil, fqdn, true)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(append(append([]byte(nil), identifier...), name...))
+	return append([]byte{byte(identifierType >> 8), byte(identifierType), 1}, digest[:]...), nil
+}
+
+// ReverseName returns the in-addr.arpa name of ip.
+func ReverseName(ip net.IP) string {
+	ip = ip.To4()
+	return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", ip[3], ip[2], ip[1], ip[0])
+}
+
+// InZone reports whether name is zone or below it.
+func InZone(name, zone string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	zone = strings.ToLower(strings.TrimSuffix(zone, "."))
+	return zone == "" || name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+// AddForward adds the A record of fqdn for the client with dhcid (RFC 4703 section 5.3.1): the name
+// is added if it is not in use, or updated if the client owns it. ErrConflict is returned if another
+// client does.
+func (c *Client) AddForward(zone, fqdn string, ip net.IP, dhcid []byte, ttl uint32) error {
+	a := RR{Name: fqdn, Type: TypeA, Class: ClassIN, TTL: ttl, Data: ip.To4()}
+	err := c.Exchange(&Message{Zone: zone,
+		Prerequisites: []RR{{Name: fqdn, Type: TypeANY, Class: ClassNONE}},
+		Updates:       []RR{a, {Name: fqdn, Type: TypeDHCID, Class: ClassIN, TTL: ttl, Data: dhcid}}})
+	if !IsRcode(err, RcodeYXDomain) {
+		return err
+	}
+
+	err = c.Exchange(&Message{Zone: zone,
+		Prerequisites: []RR{{Name: fqdn, Type: TypeDHCID, Class: ClassIN, Data: dhcid}},
+		Updates:       []RR{{Name: fqdn, Type: TypeA, Class: ClassANY}, a}})
+	if IsRcode(err, RcodeNXRRSet) {
+		return ErrConflict
+	}
+	return err
+}
+
+// RemoveForward removes the A record of fqdn to ip the client with dhcid owns, and its DHCID record
+// when no address record is left (RFC 4703 section 5.5).
+func (c *Client) RemoveForward(zone, fqdn string, ip net.IP, dhcid []byte) error {
+	owner := RR{Name: fqdn, Type: TypeDHCID, Class: ClassIN, Data: dhcid}
+	err := c.Exchange(&Message{Zone: zone, Prerequisites: []RR{owner},
+		Updates: []RR{{Name: fqdn, Type: TypeA, Class: ClassNONE, Data: ip.To4()}}})
+	if IsRcode(err, RcodeNXRRSet) {
+		return ErrConflict
+	}
+	if err != nil {
+		return err
+	}
+
+	err = c.Exchange(&Message{Zone: zone,
+		Prerequisites: []RR{owner, {Name: fqdn, Type: TypeA, Class: ClassNONE}, {Name: fqdn, Type: TypeAAAA, Class: ClassNONE}},
+		Updates:       []RR{{Name: fqdn, Type: TypeDHCID, Class: ClassANY}}})
+	if IsRcode(err, RcodeNXRRSet) || IsRcode(err, RcodeYXRRSet) {
+		//the name has other addresses
+		return nil
+	}
+	return err
+}
+
+// AddReverse points the PTR record of ip to fqdn, replacing the records there (RFC 4703 section 5.4).
+func (c *Client) AddReverse(zone string, ip net.IP, fqdn string, dhcid []byte, ttl uint32) error {
+	name := ReverseName(ip)
+	target, err := encodeName(nil, fqdn, false)
+	if err != nil {
+		return err
+	}
+	return c.Exchange(&Message{Zone: zone, Updates: []RR{
+		{Name: name, Type: TypePTR, Class: ClassANY},
+		{Name: name, Type: TypeDHCID, Class: ClassANY},
+		{Name: name, Type: TypePTR, Class: ClassIN, TTL: ttl, Data: target},
+		{Name: name, Type: TypeDHCID, Class: ClassIN, TTL: ttl, Data: dhcid},
+	}})
+}
+
+// RemoveReverse removes the PTR and DHCID records of ip.
+func (c *Client) RemoveReverse(zone string, ip net.IP) error {
+	name := ReverseName(ip)
+	return c.Exchange(&Message{Zone: zone, Updates: []RR{
+		{Name: name, Type: TypePTR, Class: ClassANY},
+		{Name: name, Type: TypeDHCID, Class: ClassANY},
+	}})
+}
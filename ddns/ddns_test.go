@@ -0,0 +1,339 @@
+package ddns
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubServer is a DNS server of one zone applying the updates it receives (RFC 2136 section 3).
+type stubServer struct {
+	key  *Key //updates must be signed with it, nil for none
+	conn *net.UDPConn
+
+	mu          sync.Mutex
+	responseKey *Key            //signs the responses, key by default
+	records     map[string][]RR //by lowercase name
+	requests    []*Message
+}
+
+func newStubServer(t *testing.T, key *Key) *stubServer {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &stubServer{key: key, responseKey: key, records: make(map[string][]RR), conn: conn}
+	t.Cleanup(func() { conn.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *stubServer) client() *Client {
+	return &Client{Server: s.conn.LocalAddr().String(), Key: s.key, Timeout: time.Second}
+}
+
+func (s *stubServer) serve() {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if response := s.handle(append([]byte(nil), buf[:n]...)); response != nil {
+			s.conn.WriteToUDP(response, addr)
+		}
+	}
+}
+
+func (s *stubServer) handle(request []byte) []byte {
+	m, err := decodeUpdate(request)
+	if err != nil {
+		return nil
+	}
+	rcode := RcodeNoError
+	var requestMAC []byte
+	if s.key != nil {
+		if requestMAC, err = s.verifyRequest(request); err != nil {
+			rcode = RcodeNotAuth
+		}
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, m)
+	if rcode == RcodeNoError {
+		rcode = s.update(m)
+	}
+	responseKey := s.responseKey
+	s.mu.Unlock()
+
+	response := append([]byte(nil), request[:12]...)
+	response[2] = 0x80 | opcodeUpdate<<3
+	response[3] = byte(rcode)
+	binary.BigEndian.PutUint16(response[4:], 0)
+	binary.BigEndian.PutUint16(response[6:], 0)
+	binary.BigEndian.PutUint16(response[8:], 0)
+	binary.BigEndian.PutUint16(response[10:], 0)
+	if responseKey != nil && rcode != RcodeNotAuth {
+		if response, _, err = responseKey.sign(response, requestMAC, time.Now()); err != nil {
+			return nil
+		}
+	}
+	return response
+}
+
+// verifyRequest checks the TSIG record of request and returns its MAC.
+func (s *stubServer) verifyRequest(request []byte) ([]byte, error) {
+	t, err := findTSIG(request)
+	if err != nil || t == nil {
+		return nil, errors.New("unsigned")
+	}
+	unsigned := append([]byte(nil), request[:t.offset]...)
+	binary.BigEndian.PutUint16(unsigned[10:], binary.BigEndian.Uint16(unsigned[10:])-1)
+	variables, err := s.key.variables(t.algorithm, t.timeSigned, t.fudge, t.err, t.other)
+	if err != nil {
+		return nil, err
+	}
+	mac, err := s.key.mac(unsigned, variables)
+	if err != nil || !hmac.Equal(mac, t.mac) {
+		return nil, errors.New("bad signature")
+	}
+	return t.mac, nil
+}
+
+// update checks the prerequisites of m and applies its updates if they hold.
+func (s *stubServer) update(m *Message) int {
+	for _, rr := range m.Prerequisites {
+		rrs := s.records[key(rr.Name)]
+		switch {
+		case rr.Class == ClassANY && rr.Type == TypeANY && len(rrs) == 0:
+			return RcodeNXDomain
+		case rr.Class == ClassANY && rr.Type != TypeANY && !hasRR(rrs, rr.Type, nil):
+			return RcodeNXRRSet
+		case rr.Class == ClassNONE && rr.Type == TypeANY && len(rrs) > 0:
+			return RcodeYXDomain
+		case rr.Class == ClassNONE && rr.Type != TypeANY && hasRR(rrs, rr.Type, nil):
+			return RcodeYXRRSet
+		case rr.Class == ClassIN && !hasRR(rrs, rr.Type, rr.Data):
+			return RcodeNXRRSet
+		}
+	}
+	for _, rr := range m.Updates {
+		name := key(rr.Name)
+		var kept []RR
+		for _, old := range s.records[name] {
+			switch {
+			case rr.Class == ClassANY && (rr.Type == TypeANY || rr.Type == old.Type):
+			case (rr.Class == ClassNONE || rr.Class == ClassIN) && rr.Type == old.Type && bytes.Equal(rr.Data, old.Data):
+			default:
+				kept = append(kept, old)
+			}
+		}
+		if rr.Class == ClassIN {
+			kept = append(kept, rr)
+		}
+		s.records[name] = kept
+	}
+	return RcodeNoError
+}
+
+func (s *stubServer) lookup(name string, rrType uint16) [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var data [][]byte
+	for _, rr := range s.records[key(name)] {
+		if rr.Type == rrType {
+			data = append(data, rr.Data)
+		}
+	}
+	return data
+}
+
+func key(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+func hasRR(rrs []RR, rrType uint16, data []byte) bool {
+	for _, rr := range rrs {
+		if rr.Type == rrType && (data == nil || bytes.Equal(rr.Data, data)) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeUpdate decodes the zone, prerequisite and update sections of an update message.
+func decodeUpdate(b []byte) (*Message, error) {
+	if len(b) < 12 || b[2]>>3&0x0f != opcodeUpdate {
+		return nil, errors.New("not an update")
+	}
+	m := &Message{ID: binary.BigEndian.Uint16(b)}
+	zone, offset, err := decodeName(b, 12)
+	if err != nil {
+		return nil, err
+	}
+	m.Zone = zone
+	offset += 4
+	for i, count := range []*[]RR{&m.Prerequisites, &m.Updates} {
+		for n := int(binary.BigEndian.Uint16(b[6+2*i:])); n > 0; n-- {
+			var rr RR
+			if rr.Name, offset, err = decodeName(b, offset); err != nil {
+				return nil, err
+			}
+			if offset+10 > len(b) {
+				return nil, errors.New("truncated record")
+			}
+			rr.Type = binary.BigEndian.Uint16(b[offset:])
+			rr.Class = binary.BigEndian.Uint16(b[offset+2:])
+			rr.TTL = binary.BigEndian.Uint32(b[offset+4:])
+			length := int(binary.BigEndian.Uint16(b[offset+8:]))
+			offset += 10
+			if offset+length > len(b) {
+				return nil, errors.New("truncated record")
+			}
+			if length > 0 {
+				rr.Data = b[offset : offset+length]
+			}
+			offset += length
+			*count = append(*count, rr)
+		}
+	}
+	return m, nil
+}
+
+var testKey = &Key{Name: "dhcp-update-key.example.org", Algorithm: "hmac-sha256", Secret: []byte("0123456789abcdef")}
+
+func TestAddForward(t *testing.T) {
+	s := newStubServer(t, testKey)
+	c := s.client()
+	fqdn := "host.example.org"
+	owner, _ := DHCID(IdentifierHardware, []byte{1, 0, 0x11, 0x22, 0x33, 0x44, 0x55}, fqdn)
+	other, _ := DHCID(IdentifierHardware, []byte{1, 0, 0x11, 0x22, 0x33, 0x44, 0x66}, fqdn)
+
+	if err := c.AddForward("example.org", fqdn, net.IPv4(192, 168, 1, 10), owner, 300); err != nil {
+		t.Fatal(err)
+	}
+	if a := s.lookup(fqdn, TypeA); len(a) != 1 || !net.IP(a[0]).Equal(net.IPv4(192, 168, 1, 10)) {
+		t.Fatalf("A records %v", a)
+	}
+	if d := s.lookup(fqdn, TypeDHCID); len(d) != 1 || !bytes.Equal(d[0], owner) {
+		t.Fatalf("DHCID records %x", d)
+	}
+
+	//the name is in use: YXDOMAIN, then updated with the DHCID of the owner as prerequisite
+	if err := c.AddForward("example.org", fqdn, net.IPv4(192, 168, 1, 11), owner, 300); err != nil {
+		t.Fatal(err)
+	}
+	if a := s.lookup(fqdn, TypeA); len(a) != 1 || !net.IP(a[0]).Equal(net.IPv4(192, 168, 1, 11)) {
+		t.Fatalf("A records after the update %v", a)
+	}
+	s.mu.Lock()
+	last := s.requests[len(s.requests)-1]
+	s.mu.Unlock()
+	if len(last.Prerequisites) != 1 || last.Prerequisites[0].Type != TypeDHCID || !bytes.Equal(last.Prerequisites[0].Data, owner) {
+		t.Errorf("update prerequisites %+v", last.Prerequisites)
+	}
+
+	if err := c.AddForward("example.org", fqdn, net.IPv4(192, 168, 1, 12), other, 300); err != ErrConflict {
+		t.Errorf("add of another client got %v", err)
+	}
+	if a := s.lookup(fqdn, TypeA); len(a) != 1 || !net.IP(a[0]).Equal(net.IPv4(192, 168, 1, 11)) {
+		t.Errorf("A records after the conflict %v", a)
+	}
+}
+
+func TestRemoveForward(t *testing.T) {
+	s := newStubServer(t, testKey)
+	c := s.client()
+	fqdn := "host.example.org"
+	ip := net.IPv4(192, 168, 1, 10)
+	owner, _ := DHCID(IdentifierClientID, []byte{1, 0, 0x11, 0x22, 0x33, 0x44, 0x55}, fqdn)
+	other, _ := DHCID(IdentifierClientID, []byte{1, 0, 0x11, 0x22, 0x33, 0x44, 0x66}, fqdn)
+	if err := c.AddForward("example.org", fqdn, ip, owner, 300); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.RemoveForward("example.org", fqdn, ip, other); err != ErrConflict {
+		t.Errorf("remove of another client got %v", err)
+	}
+	if a := s.lookup(fqdn, TypeA); len(a) != 1 {
+		t.Fatalf("A records after the conflict %v", a)
+	}
+	if err := c.RemoveForward("example.org", fqdn, ip, owner); err != nil {
+		t.Fatal(err)
+	}
+	if a, d := s.lookup(fqdn, TypeA), s.lookup(fqdn, TypeDHCID); len(a) != 0 || len(d) != 0 {
+		t.Errorf("records left %v %x", a, d)
+	}
+}
+
+func TestAddReverse(t *testing.T) {
+	s := newStubServer(t, testKey)
+	c := s.client()
+	ip := net.IPv4(192, 168, 1, 10)
+	fqdn := "workstation-01.office.example.org"
+	id, _ := DHCID(IdentifierHardware, []byte{1, 0, 0x11, 0x22, 0x33, 0x44, 0x55}, fqdn)
+	//the TSIG record of the update is encoded after byte 255 of the message
+	if err := c.AddReverse("1.168.192.in-addr.arpa", ip, fqdn, id, 300); err != nil {
+		t.Fatal(err)
+	}
+	ptr := s.lookup(ReverseName(ip), TypePTR)
+	if len(ptr) != 1 {
+		t.Fatalf("PTR records %v", ptr)
+	}
+	if name, _, err := decodeName(ptr[0], 0); err != nil || name != fqdn+"." {
+		t.Errorf("PTR record %q %v", name, err)
+	}
+
+	if err := c.RemoveReverse("1.168.192.in-addr.arpa", ip); err != nil {
+		t.Fatal(err)
+	}
+	if ptr, d := s.lookup(ReverseName(ip), TypePTR), s.lookup(ReverseName(ip), TypeDHCID); len(ptr) != 0 || len(d) != 0 {
+		t.Errorf("records left %v %x", ptr, d)
+	}
+}
+
+func TestTSIG(t *testing.T) {
+	s := newStubServer(t, testKey)
+	id, _ := DHCID(IdentifierHardware, []byte{1, 0, 0x11, 0x22, 0x33, 0x44, 0x55}, "host.example.org")
+
+	//a response to a signed request is verified with the MAC of the request
+	if err := s.client().AddForward("example.org", "host.example.org", net.IPv4(192, 168, 1, 10), id, 300); err != nil {
+		t.Fatal(err)
+	}
+
+	wrong := *testKey
+	wrong.Secret = []byte("fedcba9876543210")
+	c := s.client()
+	c.Key = &wrong
+	if err := c.AddForward("example.org", "other.example.org", net.IPv4(192, 168, 1, 11), id, 300); !IsRcode(err, RcodeNotAuth) {
+		t.Errorf("update signed with another secret got %v", err)
+	}
+	if a := s.lookup("other.example.org", TypeA); len(a) != 0 {
+		t.Errorf("unauthenticated update applied %v", a)
+	}
+
+	s.mu.Lock()
+	s.responseKey = &wrong
+	s.mu.Unlock()
+	if err := s.client().RemoveReverse("1.168.192.in-addr.arpa", net.IPv4(192, 168, 1, 10)); err == nil ||
+		!strings.Contains(err.Error(), "invalid response signature") {
+		t.Errorf("response signed with another secret got %v", err)
+	}
+}
+
+func TestEncodeName(t *testing.T) {
+	prefix := make([]byte, 300)
+	long := strings.Repeat(strings.Repeat("a", 63)+".", 3) + strings.Repeat("a", 61) //253 characters, 255 octets
+	if _, err := encodeName(prefix, long, false); err != nil {
+		t.Errorf("name of 255 octets after 300 bytes:%s", err.Error())
+	}
+	if _, err := encodeName(nil, long+"a", false); err == nil {
+		t.Error("name of 256 octets encoded")
+	}
+}
@@ -0,0 +1,145 @@
+package dhcp4
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// Address conflict detection constants of RFC 5227 section 1.1.
+const (
+	ProbeWait    = time.Second
+	ProbeNum     = 3
+	ProbeMin     = time.Second
+	ProbeMax     = 2 * time.Second
+	AnnounceWait = 2 * time.Second
+)
+
+// DeclineBackoff is the time a client waits before restarting configuration after a DHCPDECLINE (RFC 2131 section 3.1).
+const DeclineBackoff = 10 * time.Second
+
+const (
+	etherTypeARP = 0x0806
+	arpRequest   = 1
+	arpReply     = 2
+)
+
+var errARPTimeout = errors.New("arp receive timeout")
+
+// arpTransport sends and receives raw ethernet frames carrying ARP.
+type arpTransport interface {
+	send(frame []byte) error
+	receive(deadline time.Time) ([]byte, error)
+	close() error
+}
+
+type arpPacket struct {
+	Operation          uint16
+	SenderHardwareAddr net.HardwareAddr
+	SenderIP           net.IP
+	TargetHardwareAddr net.HardwareAddr
+	TargetIP           net.IP
+}
+
+// encodeARPFrame builds a broadcast ethernet frame carrying an ARP packet for IPv4 over ethernet.
+func encodeARPFrame(p arpPacket) []byte {
+	var buf bytes.Buffer
+	buf.Write(net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	buf.Write(p.SenderHardwareAddr)
+	buf.Write(Uint16ToBytes(etherTypeARP))
+	buf.Write(Uint16ToBytes(1))      //hardware type ethernet
+	buf.Write(Uint16ToBytes(0x0800)) //protocol type IPv4
+	buf.WriteByte(6)
+	buf.WriteByte(4)
+	buf.Write(Uint16ToBytes(p.Operation))
+	buf.Write(p.SenderHardwareAddr)
+	buf.Write(p.SenderIP.To4())
+	buf.Write(p.TargetHardwareAddr)
+	buf.Write(p.TargetIP.To4())
+	return buf.Bytes()
+}
+
+func decodeARPFrame(frame []byte) (arpPacket, bool) {
+	if len(frame) < 42 || BytesToUint16(frame[12:14]) != etherTypeARP ||
+		BytesToUint16(frame[14:16]) != 1 || BytesToUint16(frame[16:18]) != 0x0800 || frame[18] != 6 || frame[19] != 4 {
+		return arpPacket{}, false
+	}
+
+	return arpPacket{
+		Operation:          BytesToUint16(frame[20:22]),
+		SenderHardwareAddr: net.HardwareAddr(frame[22:28]),
+		SenderIP:           net.IP(frame[28:32]),
+		TargetHardwareAddr: net.HardwareAddr(frame[32:38]),
+		TargetIP:           net.IP(frame[38:42]),
+	}, true
+}
+
+// conflicts reports whether p shows another host using or probing for ip (RFC 5227 section 2.1.1).
+func (p arpPacket) conflicts(ip net.IP, mac net.HardwareAddr) bool {
+	if bytes.Equal(p.SenderHardwareAddr, mac) {
+		return false
+	}
+	if p.SenderIP.Equal(ip) {
+		return true
+	}
+	return p.Operation == arpRequest && p.SenderIP.Equal(net.IPv4zero) && p.TargetIP.Equal(ip)
+}
+
+// ARPProbe probes ip on ifi as described in RFC 5227 section 2.1, using mac as sender hardware address.
+// It returns the hardware address of a host using ip, nil if the address is free.
+func ARPProbe(ifi *net.Interface, mac net.HardwareAddr, ip net.IP) (net.HardwareAddr, error) {
+	t, err := openARPTransport(ifi)
+	if err != nil {
+		return nil, err
+	}
+	defer t.close()
+
+	probe := encodeARPFrame(arpPacket{
+		Operation:          arpRequest,
+		SenderHardwareAddr: mac,
+		SenderIP:           net.IPv4zero,
+		TargetHardwareAddr: make(net.HardwareAddr, 6),
+		TargetIP:           ip,
+	})
+
+	wait := time.Duration(rand.Int63n(int64(ProbeWait)))
+	for i := 0; i <= ProbeNum; i++ {
+		deadline := time.Now().Add(wait)
+		if conflict, err := waitARPConflict(t, deadline, ip, mac); err != nil || conflict != nil {
+			return conflict, err
+		}
+		if i == ProbeNum {
+			break
+		}
+
+		if err := t.send(probe); err != nil {
+			return nil, err
+		}
+		if i == ProbeNum-1 {
+			wait = AnnounceWait
+		} else {
+			wait = ProbeMin + time.Duration(rand.Int63n(int64(ProbeMax-ProbeMin)))
+		}
+	}
+
+	return nil, nil
+}
+
+func waitARPConflict(t arpTransport, deadline time.Time, ip net.IP, mac net.HardwareAddr) (net.HardwareAddr, error) {
+	for time.Now().Before(deadline) {
+		frame, err := t.receive(deadline)
+		if err == errARPTimeout {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if p, ok := decodeARPFrame(frame); ok && p.conflicts(ip, mac) {
+			return append(net.HardwareAddr(nil), p.SenderHardwareAddr...), nil
+		}
+	}
+	return nil, nil
+}
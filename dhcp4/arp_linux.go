@@ -0,0 +1,73 @@
+//go:build linux
+
+package dhcp4
+
+import (
+	"net"
+	"syscall"
+	"time"
+)
+
+// packetConn is an AF_PACKET socket bound to one interface and the ARP ethertype.
+type packetConn struct {
+	fd      int
+	ifindex int
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+func openARPTransport(ifi *net.Interface) (arpTransport, error) {
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(etherTypeARP)))
+	if err != nil {
+		return nil, err
+	}
+
+	addr := &syscall.SockaddrLinklayer{Protocol: htons(etherTypeARP), Ifindex: ifi.Index}
+	if err := syscall.Bind(fd, addr); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return &packetConn{fd: fd, ifindex: ifi.Index}, nil
+}
+
+func (c *packetConn) send(frame []byte) error {
+	addr := &syscall.SockaddrLinklayer{
+		Protocol: htons(etherTypeARP),
+		Ifindex:  c.ifindex,
+		Halen:    6,
+		Addr:     [8]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	}
+	return syscall.Sendto(c.fd, frame, 0, addr)
+}
+
+func (c *packetConn) receive(deadline time.Time) ([]byte, error) {
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		return nil, errARPTimeout
+	}
+	tv := syscall.NsecToTimeval(timeout.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(c.fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1514)
+	for {
+		n, _, err := syscall.Recvfrom(c.fd, buf, 0)
+		switch err {
+		case nil:
+			return buf[:n], nil
+		case syscall.EINTR:
+			continue
+		case syscall.EAGAIN:
+			return nil, errARPTimeout
+		default:
+			return nil, err
+		}
+	}
+}
+
+func (c *packetConn) close() error {
+	return syscall.Close(c.fd)
+}
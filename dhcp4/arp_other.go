@@ -0,0 +1,13 @@
+//go:build !linux
+
+package dhcp4
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+func openARPTransport(ifi *net.Interface) (arpTransport, error) {
+	return nil, fmt.Errorf("arp probing is not supported on %s", runtime.GOOS)
+}
@@ -1,13 +1,22 @@
 package dhcp4
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 )
 
 const MaxRetryNum = 1
 
+// MaxDeclineNum is the number of conflicting addresses the client declines before giving up.
+const MaxDeclineNum = 3
+
+// MaxMessageSize is the maximum DHCP message size the client accepts, sent in option 57.
+const MaxMessageSize = 1500
+
 type Conn struct {
 	*net.UDPConn
 	TransactionID      uint32
@@ -17,13 +26,55 @@ type Conn struct {
 	HostName           string
 	Mac                string
 	MacByte            []byte
-	doneChan           chan bool
-	retry              int
-	relay              []byte
-	ifnname            *net.Interface
+	Lease              *Lease
+	Profile            *Profile
+	//ParameterRequestList is sent in option 55 in the given order,
+	//it overrides the list of Profile and DefaultParameterRequestList
+	ParameterRequestList []byte
+	//DisableIPv6OnlyPreferred removes option 108 from the parameter request list
+	DisableIPv6OnlyPreferred bool
+	//V6OnlyWait is set when the server answered with option 108 (RFC 8925),
+	//the client stopped DHCPv4 configuration and should not retry before it elapses
+	V6OnlyWait time.Duration
+	//PXE makes the client act as a PXE boot ROM, see NewPXE
+	PXE *PXE
+	//ConflictDetection makes the client ARP-probe the acknowledged address on the interface
+	//set with SetInterface, declining it and restarting discovery if it is in use (RFC 5227)
+	ConflictDetection bool
+	//Configurator applies the acknowledged lease to its interface and removes it on release
+	Configurator *Configurator
+	//LinkWatch makes Run follow the carrier of the interface set with SetInterface,
+	//pausing while it is down and verifying the lease when it comes back
+	LinkWatch bool
+	//Hooks are notified of lease events, see ScriptHook
+	Hooks          []LeaseHook
+	expiry         *time.Timer
+	boundAt        time.Time
+	requestEvent   LeaseEvent //event of the DHCPREQUEST in flight, EventBound when answering an offer
+	persistent     bool       //created by NewDHCPClient, messages are sent on the listener bound to the interface
+	mu             sync.Mutex //guards Lease against the expiry timer
+	configMu       sync.Mutex //serializes the Configurator, taken before mu
+	renewChan      chan bool
+	stopChan       chan bool
+	closeChan      chan bool //closed by Close
+	closeOnce      sync.Once
+	carrierChan    chan bool
+	declines       int
+	fqdn           *Option81
+	proxyOffer     *Message
+	proxyServer    net.IP
+	proxyRequested bool
+	listener       *net.UDPConn
+	doneChan       chan bool
+	retry          int
+	relay          []byte
+	ifnname        *net.Interface
+	exchangeStart  time.Time //first message of the exchange in flight
+	counted        bool      //the lease is counted in the active leases metric
 }
 
 func (c *Conn) Close() {
+	c.closeOnce.Do(func() { close(c.closeChan) })
 	if c.UDPConn != nil {
 		c.UDPConn.Close()
 	}
@@ -34,11 +85,36 @@ func (c *Conn) isRelay() bool {
 }
 
 func NewDHCPRequest(serverIP string, relay string, hostName, mac string) (c *Conn, err error) {
+	if c, err = newConn(serverIP, relay, hostName, mac); err != nil {
+		return nil, err
+	}
+
+	go c.listenUDP()
+	return c, nil
+}
+
+// NewDHCPClient returns a Conn that keeps a lease on its interface with Run.
+func NewDHCPClient(serverIP string, relay string, hostName, mac string) (*Conn, error) {
+	c, err := newConn(serverIP, relay, hostName, mac)
+	if err != nil {
+		return nil, err
+	}
+
+	c.persistent = true
+	c.renewChan = make(chan bool, 1)
+	c.stopChan = make(chan bool, 1)
+	c.carrierChan = make(chan bool)
+	return c, nil
+}
+
+func newConn(serverIP string, relay string, hostName, mac string) (c *Conn, err error) {
 	c = &Conn{
 		DhcpServerHost: serverIP,
 		SecondsElapsed: 0,
 		TransactionID:  RandomTransactionID(),
 		doneChan:       make(chan bool),
+		closeChan:      make(chan bool),
+		requestEvent:   EventBound,
 		Mac:            mac,
 		HostName:       hostName,
 		relay:          make([]byte, 4, 4),
@@ -50,10 +126,6 @@ func NewDHCPRequest(serverIP string, relay string, hostName, mac string) (c *Con
 	}
 	c.MacByte = hw
 
-	if c.ifnname, err = net.InterfaceByName("en0"); err != nil {
-		return nil, err
-	}
-
 	if relay != "" {
 		if addr := net.ParseIP(relay); addr == nil || addr.To4() == nil {
 			return nil, fmt.Errorf("invalid relay ip")
@@ -80,34 +152,54 @@ func NewDHCPRequest(serverIP string, relay string, hostName, mac string) (c *Con
 	}
 
 	c.UDPConn = conn
-	go c.listenUDP()
 	return c, nil
 }
 
 func (c *Conn) listenUDP() {
-	laddr := &net.UDPAddr{
-		IP:   net.IPv4(0, 0, 0, 0),
-		Port: 68,
+	conn, err := c.listen()
+	if err != nil {
+		fmt.Printf("listen udp failed:%s\n", err.Error())
+		return
 	}
+	defer conn.Close()
+	c.listener = conn
+	c.serve(conn)
+}
+
+// listen opens the socket answers are received on, bound to the interface if one is set.
+func (c *Conn) listen() (*net.UDPConn, error) {
+	port := 68
 	if c.isRelay() {
-		laddr.Port = 67
+		port = 67
 	}
 
-	conn, err := net.ListenUDP("udp", laddr)
+	var ifname string
+	if c.ifnname != nil {
+		ifname = c.ifnname.Name
+	}
+	lc := net.ListenConfig{Control: socketControl(ifname)}
+	conn, err := lc.ListenPacket(context.Background(), "udp4", fmt.Sprintf("0.0.0.0:%d", port))
 	if err != nil {
-		fmt.Printf("listen udp failed:%s\n", err.Error())
-		return
+		return nil, err
 	}
-	defer conn.Close()
+	return conn.(*net.UDPConn), nil
+}
+
+// serve handles the answers of one exchange, it is done when handlerResponse completes
+// the exchange or no answer arrives for 3 seconds.
+func (c *Conn) serve(conn *net.UDPConn) {
 
 	now := time.Now()
 	conn.SetReadDeadline(now.Add(time.Second * 3))
 	for {
-		data := make([]byte, 576)
+		data := make([]byte, MaxMessageSize)
 		length, rAddr, err := conn.ReadFromUDP(data)
 		if err != nil {
 			fmt.Printf("read message failed:%s\n", err)
-			if op, ok := err.(*net.OpError); ok && (op.Timeout() || op.Temporary()) {
+			if op, ok := err.(*net.OpError); ok && op.Timeout() {
+				exchangeTimeouts.With(c.roleLabel(), c.exchangeLabel()).Inc()
+			}
+			if op, ok := err.(*net.OpError); ok && (op.Timeout() || op.Temporary() || errors.Is(err, net.ErrClosed)) {
 				c.done()
 				return
 			}
@@ -134,93 +226,283 @@ func (c *Conn) done() {
 	fmt.Println("done")
 }
 
-func (c *Conn) Discovery() error {
-	options := []OptionInter{
-		GenOption51(7776000),
-		GenOption57(1500),
-		GenOption61(c.MacByte),
+// SetFQDN makes the client send option 81 with name and flags in DISCOVER and REQUEST messages.
+func (c *Conn) SetFQDN(name string, flags uint8) error {
+	o, err := GenOption81(name, flags)
+	if err != nil {
+		return err
+	}
+
+	c.fqdn = &o
+	return nil
+}
+
+// vendorOptions returns the maximum message size and vendor class options,
+// filled in the way the device of the configured profile does.
+func (c *Conn) vendorOptions() []OptionInter {
+	if c.PXE != nil {
+		return append([]OptionInter{GenOption57(MaxMessageSize)}, c.PXE.Options()...)
+	}
+	if c.Profile == nil {
+		return []OptionInter{GenOption57(MaxMessageSize)}
+	}
+
+	var options []OptionInter
+	if c.Profile.MaxMessageSize != 0 {
+		options = append(options, GenOption57(c.Profile.MaxMessageSize))
+	}
+	if c.Profile.VendorClass != "" {
+		options = append(options, GenOption60(c.Profile.VendorClass))
+	}
+	return options
+}
+
+// parameterRequestList returns option 55 built from ParameterRequestList,
+// the list of the configured profile or DefaultParameterRequestList.
+// Option 108 is removed if DisableIPv6OnlyPreferred is set.
+func (c *Conn) parameterRequestList() Option55 {
+	parameters := DefaultParameterRequestList
+	if len(c.ParameterRequestList) > 0 {
+		parameters = c.ParameterRequestList
+	} else if c.PXE != nil {
+		parameters = PXEParameterRequestList
+	} else if c.Profile != nil && len(c.Profile.ParameterRequestList) > 0 {
+		parameters = c.Profile.ParameterRequestList
 	}
+
+	if c.DisableIPv6OnlyPreferred {
+		var filtered []byte
+		for _, parameter := range parameters {
+			if parameter != 108 {
+				filtered = append(filtered, parameter)
+			}
+		}
+		parameters = filtered
+	}
+	return GenOption55(parameters...)
+}
+
+// ipv6OnlyPreferred reports whether the client requested option 108 and m carries it,
+// in which case the client MUST stop DHCPv4 configuration for V6ONLY_WAIT seconds (RFC 8925 section 3.2).
+func (c *Conn) ipv6OnlyPreferred(m *Message) (time.Duration, bool) {
+	option108, ok := m.getOption(108).(Option108)
+	if !ok {
+		return 0, false
+	}
+	for _, parameter := range c.parameterRequestList().Parameters {
+		if parameter == 108 {
+			return time.Duration(option108.V6OnlyWait()) * time.Second, true
+		}
+	}
+	return 0, false
+}
+
+func (c *Conn) Discovery() error {
+	options := []OptionInter{c.parameterRequestList(), GenOption51(7776000)}
+	options = append(options, c.vendorOptions()...)
+	options = append(options, GenOption61(c.MacByte))
 	if c.HostName != "" {
 		options = append(options, GenOption12(c.HostName))
 	}
+	if c.fqdn != nil {
+		options = append(options, *c.fqdn)
+	}
+
+	if c.Profile != nil {
+		options = c.Profile.Options(MessageTypeDiscover, options)
+	}
 
 	m := GenDiscoverMessage(c.Mac, options...)
 	m.TransactionID = c.TransactionID
 	m.SecondsElapsed = c.SecondsElapsed
 	c.CurrentMessageType = m.MessageType
+	c.requestEvent = EventBound
+	c.exchangeStart = time.Now()
 	m.RelayAgentIP = c.relay
 
+	return c.send(m, nil)
+}
+
+// send writes m to the server at to, DhcpServerHost if to is nil. A Conn created by NewDHCPClient
+// sends on its listener bound to the interface, so that broadcasts leave that interface from the client port.
+func (c *Conn) send(m *Message, to net.IP) error {
 	fmt.Printf("send message---->:\n%s\n", m.String())
-	if _, err := c.Write(m.Encode()); err != nil {
+	var err error
+	if !c.persistent {
+		_, err = c.Write(m.Encode())
+	} else {
+		if to == nil {
+			to = c.RemoteAddr().(*net.UDPAddr).IP
+		}
+		_, err = c.listener.WriteToUDP(m.Encode(), &net.UDPAddr{IP: to, Port: 67})
+	}
+	if err == nil {
+		messagesSent.With(c.roleLabel(), m.MessageType.Label()).Inc()
+	}
+	return err
+}
+
+// SetInterface selects the network interface the client runs on.
+func (c *Conn) SetInterface(name string) error {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
 		return err
 	}
 
+	c.ifnname = ifi
 	return nil
 }
 
 func (c *Conn) Decline(declineIP string) error {
-	server := net.ParseIP(c.DhcpServerHost)
+	return c.decline(net.ParseIP(declineIP), net.ParseIP(c.DhcpServerHost))
+}
+
+func (c *Conn) decline(declineIp, server net.IP) error {
 	options := []OptionInter{
 		GenOption54(server.To4()),
-		GenOption57(1500),
+		GenOption57(MaxMessageSize),
 		GenOption61(c.MacByte),
 	}
 	if c.HostName != "" {
 		options = append(options, GenOption12(c.HostName))
 	}
 
-	declineIp := net.ParseIP(declineIP)
 	m := GenDeclineMessage(c.Mac, declineIp.To4(), options...)
 	m.TransactionID = c.TransactionID
 	m.SecondsElapsed = c.SecondsElapsed
 	c.CurrentMessageType = m.MessageType
 	m.RelayAgentIP = c.relay
 
-	fmt.Printf("send message---->:\n%s\n", m.String())
-	if _, err := c.Write(m.Encode()); err != nil {
-		return err
-	}
-
-	return nil
+	return c.send(m, nil)
 }
 
 func (c *Conn) Release(release string) error {
+	releaseIP := net.ParseIP(release)
 	server := net.ParseIP(c.DhcpServerHost)
+	if lease := c.currentLease(); lease != nil && lease.ClientIP.Equal(releaseIP) && lease.ServerIdentifier != nil {
+		server = lease.ServerIdentifier
+	}
 	options := []OptionInter{
 		GenOption54(server.To4()),
-		GenOption57(1500),
+		GenOption57(MaxMessageSize),
 		GenOption61(c.MacByte),
 	}
 	if c.HostName != "" {
 		options = append(options, GenOption12(c.HostName))
 	}
 
-	releaseIP := net.ParseIP(release)
 	m := GenReleaseMessage(c.Mac, releaseIP.To4(), options...)
 	m.TransactionID = c.TransactionID
 	m.SecondsElapsed = c.SecondsElapsed
 	c.CurrentMessageType = m.MessageType
 	m.RelayAgentIP = c.relay
 
-	fmt.Printf("send message---->:\n%s\n", m.String())
-	if _, err := c.Write(m.Encode()); err != nil {
+	if err := c.send(m, server); err != nil {
 		return err
 	}
 
-	return nil
+	c.configMu.Lock()
+	c.mu.Lock()
+	old := c.Lease
+	if old == nil || !old.ClientIP.Equal(releaseIP) {
+		old = &Lease{ClientIP: releaseIP.To4()}
+	}
+	c.stopExpiry()
+	c.setLease(nil)
+	c.mu.Unlock()
+
+	var err error
+	if c.Configurator != nil {
+		if old.LeaseTime != 0 {
+			err = c.Configurator.Remove()
+		} else {
+			err = c.Configurator.RemoveAddress(releaseIP)
+		}
+	}
+	c.configMu.Unlock()
+	c.runHooks(EventRelease, old, nil)
+	return err
+}
+
+// requestOptions returns the options of a DHCPREQUEST extending or verifying a lease.
+func (c *Conn) requestOptions() []OptionInter {
+	options := []OptionInter{c.parameterRequestList()}
+	options = append(options, c.vendorOptions()...)
+	options = append(options, GenOption61(c.MacByte))
+	if c.HostName != "" {
+		options = append(options, GenOption12(c.HostName))
+	}
+	if c.fqdn != nil {
+		options = append(options, *c.fqdn)
+	}
+	if c.Profile != nil {
+		options = c.Profile.Options(MessageTypeRequest, options)
+	}
+	return options
+}
+
+// Renew asks the server that granted the lease to extend it (RENEWING), the request is unicast.
+func (c *Conn) Renew() error {
+	lease := c.currentLease()
+	if lease == nil {
+		return fmt.Errorf("no lease to renew")
+	}
+
+	m := GenRenewMessage(c.Mac, lease.ClientIP.To4(), c.requestOptions()...)
+	return c.sendRequest(m, EventRenew, lease.ServerIdentifier)
+}
+
+// Rebind asks any server to extend the lease (REBINDING), the request is broadcast.
+func (c *Conn) Rebind() error {
+	lease := c.currentLease()
+	if lease == nil {
+		return fmt.Errorf("no lease to rebind")
+	}
+
+	m := GenRenewMessage(c.Mac, lease.ClientIP.To4(), c.requestOptions()...)
+	return c.sendRequest(m, EventRebind, nil)
+}
+
+// Reboot asks the servers to confirm the previously allocated address ip (INIT-REBOOT), the request is broadcast.
+func (c *Conn) Reboot(ip net.IP) error {
+	m := GenRebootMessage(c.Mac, ip.To4(), c.requestOptions()...)
+	return c.sendRequest(m, EventReboot, nil)
+}
+
+func (c *Conn) sendRequest(m *Message, event LeaseEvent, to net.IP) error {
+	m.TransactionID = c.TransactionID
+	m.SecondsElapsed = c.SecondsElapsed
+	m.RelayAgentIP = c.relay
+	c.CurrentMessageType = m.MessageType
+	c.requestEvent = event
+	c.exchangeStart = time.Now()
+	return c.send(m, to)
 }
 
 func (c *Conn) handlerResponse(addr *net.UDPAddr, b []byte) bool {
 	m := &Message{}
-	m.Decode(b)
+	if err := m.Decode(b); err != nil {
+		fmt.Printf("decode message from %s failed:%s\n", addr, err.Error())
+		decodeErrors.With(c.roleLabel()).Inc()
+		return false
+	}
 
 	if m.TransactionID != c.TransactionID {
 		return false
 	}
+	messagesReceived.With(c.roleLabel(), m.MessageType.Label()).Inc()
 	fmt.Println("receive DHCP Message<----:", addr, len(b))
 	fmt.Println(m.String())
 
 	if m.MessageType == MessageTypeNak {
+		naksReceived.With(c.roleLabel(), c.exchangeLabel()).Inc()
+		exchangeSeconds.With(c.roleLabel(), c.exchangeLabel()).ObserveSince(c.exchangeStart)
+		c.runHooks(EventNak, c.currentLease(), nil)
+		if c.requestEvent != EventBound {
+			//the lease being extended or verified is gone, the client restarts in INIT
+			c.unbind()
+			return true
+		}
 		c.retry++
 		if c.CurrentMessageType == MessageTypeDiscover && c.retry < MaxRetryNum {
 			if err := c.Discovery(); err != nil {
@@ -237,29 +519,253 @@ func (c *Conn) handlerResponse(addr *net.UDPAddr, b []byte) bool {
 	}
 
 	c.retry = 0
-	if c.CurrentMessageType == MessageTypeDiscover && m.MessageType == MessageTypeOffer {
-		options := []OptionInter{
-			GenOption57(1500),
-			GenOption51(7776000),
+	if m.MessageType == MessageTypeOffer || m.MessageType == MessageTypeAck {
+		if wait, ok := c.ipv6OnlyPreferred(m); ok {
+			c.V6OnlyWait = wait
+			return true
+		}
+	}
+
+	if c.PXE != nil && m.MessageType == MessageTypeOffer && isProxyOffer(m) {
+		//proxyDHCP offers carry boot information only, keep waiting for an address
+		if c.proxyOffer == nil {
+			c.proxyOffer = m
+			c.proxyServer = addr.IP
+			if option54, ok := m.getOption(54).(Option54); ok {
+				c.proxyServer = net.IP(option54.ServerIdentifier)
+			}
 		}
+		return false
+	}
+
+	if c.proxyRequested && m.MessageType == MessageTypeAck {
+		c.Lease.setBootServer(m, c.proxyServer)
+		return true
+	}
+
+	if c.CurrentMessageType == MessageTypeDiscover && m.MessageType == MessageTypeOffer {
+		options := []OptionInter{c.parameterRequestList()}
+		options = append(options, c.vendorOptions()...)
+		options = append(options, GenOption51(7776000))
 
 		if c.HostName != "" {
 			options = append(options, GenOption12(c.HostName))
 		}
-		requestMsg := GenRequestMessage(m, options...)
+		if c.fqdn != nil {
+			options = append(options, *c.fqdn)
+		}
+		if c.Profile != nil {
+			options = append(options, GenOption50(m.YourIP), GenOption61(c.MacByte))
+			if option54 := m.getOption(54); option54 != nil {
+				options = append(options, option54)
+			}
+			options = c.Profile.Options(MessageTypeRequest, options)
+		}
+		var requestMsg *Message
+		if c.Profile != nil && c.Profile.RequestOptions != nil {
+			//the profile lists every option of the request, nothing is added to it
+			requestMsg = genRequestMessage(m, options)
+		} else {
+			requestMsg = GenRequestMessage(m, options...)
+		}
 		c.SecondsElapsed = requestMsg.SecondsElapsed
 		c.CurrentMessageType = requestMsg.MessageType
 		requestMsg.RelayAgentIP = c.relay
 
-		fmt.Printf("send message---->:\n%s\n", m.String())
-		if _, err := c.Write(requestMsg.Encode()); err != nil {
+		if err := c.send(requestMsg, nil); err != nil {
 			fmt.Printf("write request message failed:%s\n", err.Error())
 			return false
 		}
 	}
 
 	if c.CurrentMessageType == MessageTypeRequest && m.MessageType == MessageTypeAck {
+		exchangeSeconds.With(c.roleLabel(), c.exchangeLabel()).ObserveSince(c.exchangeStart)
+		lease := NewLease(m)
+		if c.ConflictDetection && (c.requestEvent == EventBound || c.requestEvent == EventReboot) {
+			if conflict := c.checkConflict(lease); conflict {
+				return c.restartAfterDecline(lease)
+			}
+		}
+
+		c.bind(c.requestEvent, c.currentLease(), lease)
+		if c.proxyOffer != nil {
+			if err := c.requestBootServer(); err != nil {
+				fmt.Printf("write proxyDHCP request failed:%s\n", err.Error())
+				return true
+			}
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// requestBootServer asks the proxyDHCP server for boot information on port 4011
+// once the client has its address (PXE specification 2.1 section 2.2.1).
+func (c *Conn) requestBootServer() error {
+	options := []OptionInter{c.parameterRequestList(), GenOption50(c.Lease.ClientIP.To4())}
+	options = append(options, c.vendorOptions()...)
+	m := GenRequestMessage(c.proxyOffer, options...)
+	m.ClientIP = c.Lease.ClientIP.To4()
+	c.proxyRequested = true
+
+	fmt.Printf("send message---->:\n%s\n", m.String())
+	_, err := c.listener.WriteToUDP(m.Encode(), &net.UDPAddr{IP: c.proxyServer, Port: PXEBootServerPort})
+	if err == nil {
+		messagesSent.With(c.roleLabel(), m.MessageType.Label()).Inc()
+	}
+	return err
+}
+
+// checkConflict ARP-probes the leased address and reports whether another host uses it.
+func (c *Conn) checkConflict(lease *Lease) bool {
+	if c.ifnname == nil {
+		fmt.Println("arp probe skipped: no interface selected")
+		return false
+	}
+
+	fmt.Printf("arp probe %s on %s\n", lease.ClientIP, c.ifnname.Name)
+	hw, err := ARPProbe(c.ifnname, c.MacByte, lease.ClientIP)
+	if err != nil {
+		fmt.Printf("arp probe failed:%s\n", err.Error())
+		return false
+	}
+	if hw != nil {
+		fmt.Printf("address %s is already in use by %s\n", lease.ClientIP, hw)
 		return true
 	}
 	return false
 }
+
+// restartAfterDecline declines the conflicting lease and restarts discovery
+// after DeclineBackoff, giving up after MaxDeclineNum declines or when Close or Stop is called meanwhile.
+func (c *Conn) restartAfterDecline(lease *Lease) bool {
+	if err := c.decline(lease.ClientIP, lease.ServerIdentifier); err != nil {
+		fmt.Printf("write decline message failed:%s\n", err.Error())
+		return true
+	}
+
+	c.declines++
+	if c.declines >= MaxDeclineNum {
+		return true
+	}
+
+	timer := time.NewTimer(DeclineBackoff)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-c.closeChan:
+		return true
+	case release := <-c.stopChan:
+		//left for Run to see
+		c.stopChan <- release
+		return true
+	}
+	c.TransactionID = RandomTransactionID()
+	c.SecondsElapsed = 0
+	c.proxyOffer = nil
+	if err := c.Discovery(); err != nil {
+		fmt.Printf("write discover message failed:%s\n", err.Error())
+		return true
+	}
+	return false
+}
+
+// bind makes lease the current lease: it is applied to the interface, the hooks are run
+// with event and the lease is removed again if it expires. The hooks run without the locks
+// of c, they may call Renew, Rebind or Release.
+func (c *Conn) bind(event LeaseEvent, old, lease *Lease) {
+	c.configMu.Lock()
+	c.mu.Lock()
+	c.setLease(lease)
+	c.boundAt = time.Now()
+	c.stopExpiry()
+	if lease.LeaseTime != 0 && lease.LeaseTime != InfiniteLeaseTime {
+		c.expiry = time.AfterFunc(time.Duration(lease.LeaseTime)*time.Second, func() {
+			c.expire(lease)
+		})
+	}
+	c.mu.Unlock()
+
+	if c.Configurator != nil {
+		if err := c.Configurator.Apply(lease); err != nil {
+			fmt.Printf("apply lease failed:%s\n", err.Error())
+		}
+	}
+	c.configMu.Unlock()
+	c.runHooks(event, old, lease)
+}
+
+func (c *Conn) stopExpiry() {
+	if c.expiry != nil {
+		c.expiry.Stop()
+		c.expiry = nil
+	}
+}
+
+func (c *Conn) expire(lease *Lease) {
+	c.configMu.Lock()
+	c.mu.Lock()
+	if c.Lease != lease {
+		c.mu.Unlock()
+		c.configMu.Unlock()
+		return
+	}
+	c.setLease(nil)
+	c.mu.Unlock()
+
+	fmt.Printf("lease %s expired\n", lease.ClientIP)
+	if c.Configurator != nil {
+		if err := c.Configurator.Remove(); err != nil {
+			fmt.Printf("remove expired lease failed:%s\n", err.Error())
+		}
+	}
+	c.configMu.Unlock()
+	c.runHooks(EventExpire, lease, nil)
+}
+
+// unbind drops the current lease after a DHCPNAK, removing it from the interface.
+func (c *Conn) unbind() {
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+	c.mu.Lock()
+	c.stopExpiry()
+	c.setLease(nil)
+	c.mu.Unlock()
+
+	if c.Configurator != nil {
+		if err := c.Configurator.Remove(); err != nil {
+			fmt.Printf("remove lease failed:%s\n", err.Error())
+		}
+	}
+}
+
+// SetLease makes lease the lease of the client, Run verifies a lease set before it (INIT-REBOOT).
+// A lease handed over to another Conn is set to nil first so it is counted once.
+func (c *Conn) SetLease(lease *Lease) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setLease(lease)
+}
+
+func (c *Conn) currentLease() *Lease {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Lease
+}
+
+// runHooks notifies the hooks of event, errors are logged and do not stop the client.
+func (c *Conn) runHooks(event LeaseEvent, old, new *Lease) {
+	var ifname string
+	if c.ifnname != nil {
+		ifname = c.ifnname.Name
+	} else if c.Configurator != nil {
+		ifname = c.Configurator.Interface.Name
+	}
+
+	for _, hook := range c.Hooks {
+		if err := hook.LeaseEvent(event, ifname, old, new); err != nil {
+			fmt.Printf("%s\n", err.Error())
+		}
+	}
+}
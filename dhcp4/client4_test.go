@@ -0,0 +1,68 @@
+package dhcp4
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// TestHooksCallConn checks that hooks may call the methods of their Conn on bind and expiry.
+func TestHooksCallConn(t *testing.T) {
+	c, err := newConn("127.0.0.1", "", "", "00:00:00:00:00:01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	//the messages may not be sent, nothing listens on the server port
+	events := make(chan LeaseEvent, 4)
+	c.Hooks = []LeaseHook{LeaseHookFunc(func(event LeaseEvent, ifname string, old, new *Lease) error {
+		switch event {
+		case EventBound:
+			c.Renew()
+		case EventExpire:
+			c.Release(old.ClientIP.String())
+		}
+		events <- event
+		return nil
+	})}
+
+	lease := &Lease{ClientIP: net.IPv4(127, 0, 0, 100).To4(), ServerIdentifier: net.IPv4(127, 0, 0, 1).To4(), LeaseTime: 1}
+	go c.bind(EventBound, nil, lease)
+	for _, want := range []LeaseEvent{EventBound, EventExpire} {
+		select {
+		case event := <-events:
+			if event != want {
+				t.Fatalf("got event %s, want %s", event, want)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("no %s event, the hook is blocked", want)
+		}
+	}
+	if c.currentLease() != nil {
+		t.Error("lease kept after the expiry")
+	}
+}
+
+// TestDeclineBackoffClose checks that Close interrupts the wait after a DHCPDECLINE.
+func TestDeclineBackoffClose(t *testing.T) {
+	c, err := newConn("127.0.0.1", "", "", "00:00:00:00:00:01")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lease := &Lease{ClientIP: net.IPv4(127, 0, 0, 100).To4(), ServerIdentifier: net.IPv4(127, 0, 0, 1).To4()}
+	done := make(chan bool, 1)
+	go func() { done <- c.restartAfterDecline(lease) }()
+	time.Sleep(100 * time.Millisecond)
+	c.Close()
+	c.Close()
+	select {
+	case ok := <-done:
+		if !ok {
+			t.Error("exchange not ended by Close")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close did not interrupt the decline backoff")
+	}
+}
@@ -0,0 +1,259 @@
+package dhcp4
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+)
+
+const DefaultResolvConf = "/etc/resolv.conf"
+
+// Configurator applies leases to a network interface: address and prefix, default route,
+// classless static routes and resolv.conf. With DryRun the changes are only printed.
+type Configurator struct {
+	Interface  *net.Interface
+	DryRun     bool
+	ResolvConf string
+
+	mu           sync.Mutex
+	applied      *interfaceConfig
+	resolvBackup []byte
+	resolvSaved  bool
+}
+
+type route struct {
+	destination *net.IPNet
+	gateway     net.IP //nil or 0.0.0.0 for on-link destinations
+}
+
+func (r route) String() string {
+	if r.gateway == nil || r.gateway.Equal(net.IPv4zero) {
+		return r.destination.String()
+	}
+	return r.destination.String() + " via " + r.gateway.String()
+}
+
+type interfaceConfig struct {
+	address     *net.IPNet
+	broadcast   net.IP
+	routes      []route
+	nameservers []net.IP
+	search      []string
+}
+
+func NewConfigurator(ifi *net.Interface, dryRun bool) *Configurator {
+	return &Configurator{Interface: ifi, DryRun: dryRun, ResolvConf: DefaultResolvConf}
+}
+
+// newInterfaceConfig derives the interface configuration from l. The router option
+// is ignored if classless static routes are present (RFC 3442).
+func newInterfaceConfig(l *Lease) (*interfaceConfig, error) {
+	ip := l.ClientIP.To4()
+	if ip == nil {
+		return nil, fmt.Errorf("lease has no IPv4 address")
+	}
+	mask := l.SubnetMask
+	if mask == nil {
+		if ip[0] >= 224 {
+			return nil, fmt.Errorf("lease of %s has no subnet mask, class D and E addresses have no default", ip)
+		}
+		mask = ip.DefaultMask()
+	}
+	if len(mask) != net.IPv4len {
+		return nil, fmt.Errorf("lease of %s has no valid subnet mask", ip)
+	}
+	cfg := &interfaceConfig{
+		address:     &net.IPNet{IP: ip, Mask: mask},
+		broadcast:   make(net.IP, 4),
+		nameservers: l.DomainNameServer,
+		search:      l.DomainSearch,
+	}
+	for i := range cfg.broadcast {
+		cfg.broadcast[i] = ip[i] | ^mask[i]
+	}
+
+	if len(l.ClasslessRoutes) > 0 {
+		for _, r := range l.ClasslessRoutes {
+			cfg.routes = append(cfg.routes, route{destination: r.Destination, gateway: r.Router})
+		}
+	} else if len(l.Routers) > 0 {
+		cfg.routes = append(cfg.routes, route{
+			destination: &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)},
+			gateway:     l.Routers[0],
+		})
+	}
+	return cfg, nil
+}
+
+// staleRoutes returns the routes of old that are not in routes.
+func staleRoutes(old, routes []route) []route {
+	var stale []route
+	for _, r := range old {
+		found := false
+		for _, n := range routes {
+			if r.String() == n.String() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			stale = append(stale, r)
+		}
+	}
+	return stale
+}
+
+// Apply configures the interface with l, replacing a previously applied lease. When the address
+// is kept, as on a renewal, only the routes the lease no longer has are deleted, the others are
+// replaced in place.
+func (c *Configurator) Apply(l *Lease) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cfg, err := newInterfaceConfig(l)
+	if err != nil {
+		return err
+	}
+	if c.applied != nil {
+		if c.applied.address.String() == cfg.address.String() {
+			c.removeRoutes(staleRoutes(c.applied.routes, cfg.routes))
+		} else if err := c.remove(c.applied); err != nil {
+			return err
+		}
+	}
+
+	ones, _ := cfg.address.Mask.Size()
+	fmt.Printf("ip address replace %s/%d brd %s dev %s\n", cfg.address.IP, ones, cfg.broadcast, c.Interface.Name)
+	if !c.DryRun {
+		if err := replaceAddress(c.Interface, cfg.address, cfg.broadcast); err != nil {
+			return fmt.Errorf("configure address %s failed:%s", cfg.address, err.Error())
+		}
+	}
+	for _, r := range cfg.routes {
+		fmt.Printf("ip route replace %s dev %s proto dhcp\n", r, c.Interface.Name)
+		if !c.DryRun {
+			if err := replaceRoute(c.Interface, r); err != nil {
+				return fmt.Errorf("configure route %s failed:%s", r, err.Error())
+			}
+		}
+	}
+	if len(cfg.nameservers) > 0 {
+		if err := c.writeResolvConf(cfg); err != nil {
+			return err
+		}
+	}
+
+	c.applied = cfg
+	return nil
+}
+
+// Remove removes the applied lease from the interface and restores resolv.conf.
+func (c *Configurator) Remove() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.applied == nil {
+		return nil
+	}
+	if err := c.remove(c.applied); err != nil {
+		return err
+	}
+	c.applied = nil
+	return c.restoreResolvConf()
+}
+
+// RemoveAddress removes ip from the interface when no lease was applied by this Configurator,
+// as when releasing an address acquired by an earlier run.
+func (c *Configurator) RemoveAddress(ip net.IP) error {
+	addrs, err := c.Interface.Addrs()
+	if err != nil {
+		return err
+	}
+
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return c.remove(&interfaceConfig{address: &net.IPNet{IP: ip.To4(), Mask: ipNet.Mask}})
+		}
+	}
+	return fmt.Errorf("address %s is not configured on %s", ip, c.Interface.Name)
+}
+
+// remove deletes the routes and the address of cfg.
+func (c *Configurator) remove(cfg *interfaceConfig) error {
+	c.removeRoutes(cfg.routes)
+
+	ones, _ := cfg.address.Mask.Size()
+	fmt.Printf("ip address del %s/%d dev %s\n", cfg.address.IP, ones, c.Interface.Name)
+	if c.DryRun {
+		return nil
+	}
+	if err := deleteAddress(c.Interface, cfg.address); err != nil {
+		return fmt.Errorf("delete address %s failed:%s", cfg.address, err.Error())
+	}
+	return nil
+}
+
+func (c *Configurator) removeRoutes(routes []route) {
+	for _, r := range routes {
+		fmt.Printf("ip route del %s dev %s\n", r, c.Interface.Name)
+		if !c.DryRun {
+			if err := deleteRoute(c.Interface, r); err != nil {
+				fmt.Printf("delete route %s failed:%s\n", r, err.Error())
+			}
+		}
+	}
+}
+
+func (c *Configurator) writeResolvConf(cfg *interfaceConfig) error {
+	var buf bytes.Buffer
+	buf.WriteString("# generated by dhcp_client4 for ")
+	buf.WriteString(c.Interface.Name)
+	buf.WriteString("\n")
+	if len(cfg.search) > 0 {
+		buf.WriteString("search")
+		for _, domain := range cfg.search {
+			buf.WriteString(" ")
+			buf.WriteString(domain)
+		}
+		buf.WriteString("\n")
+	}
+	for _, server := range cfg.nameservers {
+		buf.WriteString("nameserver ")
+		buf.WriteString(server.String())
+		buf.WriteString("\n")
+	}
+
+	fmt.Printf("write %s:\n%s", c.ResolvConf, buf.String())
+	if c.DryRun {
+		return nil
+	}
+	if !c.resolvSaved {
+		backup, err := os.ReadFile(c.ResolvConf)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		c.resolvBackup = backup
+		c.resolvSaved = true
+	}
+	return os.WriteFile(c.ResolvConf, buf.Bytes(), 0644)
+}
+
+func (c *Configurator) restoreResolvConf() error {
+	if !c.resolvSaved {
+		return nil
+	}
+
+	fmt.Printf("restore %s\n", c.ResolvConf)
+	c.resolvSaved = false
+	if c.DryRun {
+		return nil
+	}
+	if c.resolvBackup == nil {
+		return os.Remove(c.ResolvConf)
+	}
+	return os.WriteFile(c.ResolvConf, c.resolvBackup, 0644)
+}
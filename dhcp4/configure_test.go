@@ -0,0 +1,47 @@
+package dhcp4
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestNewInterfaceConfig(t *testing.T) {
+	cfg, err := newInterfaceConfig(&Lease{ClientIP: net.IPv4(10, 1, 2, 3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.address.String() != "10.1.2.3/8" || !cfg.broadcast.Equal(net.IPv4(10, 255, 255, 255)) {
+		t.Errorf("got %s brd %s for the default mask", cfg.address, cfg.broadcast)
+	}
+
+	//class D and E addresses have no default mask
+	for _, ip := range []net.IP{net.IPv4(224, 0, 0, 5), net.IPv4(240, 0, 0, 1)} {
+		if _, err := newInterfaceConfig(&Lease{ClientIP: ip}); err == nil {
+			t.Errorf("lease of %s without subnet mask accepted", ip)
+		}
+	}
+	if _, err := newInterfaceConfig(&Lease{}); err == nil {
+		t.Error("lease without address accepted")
+	}
+}
+
+func TestStaleRoutes(t *testing.T) {
+	_, defaultRoute, _ := net.ParseCIDR("0.0.0.0/0")
+	_, network, _ := net.ParseCIDR("10.0.0.0/8")
+	old := []route{
+		{destination: defaultRoute, gateway: net.IPv4(192, 168, 1, 1)},
+		{destination: network, gateway: net.IPv4(192, 168, 1, 2)},
+	}
+	//a renewal keeping the default route and moving the other one
+	routes := []route{
+		{destination: defaultRoute, gateway: net.IPv4(192, 168, 1, 1)},
+		{destination: network, gateway: net.IPv4(192, 168, 1, 3)},
+	}
+	if got := fmt.Sprint(staleRoutes(old, routes)); got != "[10.0.0.0/8 via 192.168.1.2]" {
+		t.Errorf("stale routes %s", got)
+	}
+	if got := staleRoutes(old, old); len(got) != 0 {
+		t.Errorf("stale routes %s of the same lease", got)
+	}
+}
@@ -0,0 +1,180 @@
+package dhcp4
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	maxLabelLength      = 63
+	maxDomainNameLength = 255
+	maxCompressPointer  = 0x3fff
+)
+
+var errDomainNameTruncated = errors.New("domain name truncated")
+
+// splitDomainName splits a dotted domain name into its labels, the root name yields no labels.
+func splitDomainName(name string) ([]string, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return nil, nil
+	}
+
+	labels := strings.Split(name, ".")
+	length := 1
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > maxLabelLength {
+			return nil, fmt.Errorf("invalid label %q in domain name %q", label, name)
+		}
+		length += len(label) + 1
+	}
+	if length > maxDomainNameLength {
+		return nil, fmt.Errorf("domain name %q is longer than %d octets", name, maxDomainNameLength)
+	}
+
+	return labels, nil
+}
+
+// encodeDomainName encodes name in the uncompressed (canonical) wire format of RFC 1035 section 3.1.
+func encodeDomainName(name string) ([]byte, error) {
+	labels, err := splitDomainName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var b []byte
+	for _, label := range labels {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0), nil
+}
+
+// encodeDomainList encodes names one after another in RFC 1035 wire format,
+// replacing every suffix already written with a compression pointer (RFC 1035 section 4.1.4).
+// Pointer offsets are relative to the start of the list as required by RFC 3397.
+func encodeDomainList(names []string) ([]byte, error) {
+	var b []byte
+	offsets := make(map[string]int)
+	for _, name := range names {
+		labels, err := splitDomainName(name)
+		if err != nil {
+			return nil, err
+		}
+
+		compressed := false
+		for i, label := range labels {
+			suffix := strings.ToLower(strings.Join(labels[i:], "."))
+			if offset, ok := offsets[suffix]; ok {
+				b = append(b, 0xc0|byte(offset>>8), byte(offset))
+				compressed = true
+				break
+			}
+			if len(b) <= maxCompressPointer {
+				offsets[suffix] = len(b)
+			}
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+		if !compressed {
+			b = append(b, 0)
+		}
+	}
+
+	return b, nil
+}
+
+// decodeDomainName decodes the name starting at offset of b, following compression pointers.
+// It returns the name and the offset just past the name in b.
+func decodeDomainName(b []byte, offset int) (string, int, error) {
+	var labels []string
+	next := -1
+	length := 1
+	for jumps := 0; ; {
+		if offset >= len(b) {
+			return "", 0, errDomainNameTruncated
+		}
+
+		l := int(b[offset])
+		switch l & 0xc0 {
+		case 0x00:
+			if l == 0 {
+				if next < 0 {
+					next = offset + 1
+				}
+				return strings.Join(labels, "."), next, nil
+			}
+			if offset+1+l > len(b) {
+				return "", 0, errDomainNameTruncated
+			}
+			if length += l + 1; length > maxDomainNameLength {
+				return "", 0, fmt.Errorf("domain name is longer than %d octets", maxDomainNameLength)
+			}
+			labels = append(labels, string(b[offset+1:offset+1+l]))
+			offset += 1 + l
+		case 0xc0:
+			if offset+1 >= len(b) {
+				return "", 0, errDomainNameTruncated
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			if jumps++; jumps > len(b)/2 {
+				return "", 0, errors.New("domain name compression loop")
+			}
+			offset = (l&0x3f)<<8 | int(b[offset+1])
+		default:
+			return "", 0, fmt.Errorf("invalid domain name label type 0x%x", l&0xc0)
+		}
+	}
+}
+
+// decodeDomainList decodes a sequence of (possibly compressed) domain names filling all of b.
+// Names decoded before an error are still returned.
+func decodeDomainList(b []byte) ([]string, error) {
+	var names []string
+	for offset := 0; offset < len(b); {
+		name, next, err := decodeDomainName(b, offset)
+		if err != nil {
+			return names, err
+		}
+		names = append(names, name)
+		offset = next
+	}
+
+	return names, nil
+}
+
+// encodeFQDN encodes name for the Client FQDN option (RFC 4702 section 2.3.1).
+// A name without dots is a partial name and is sent without the terminating root label,
+// asking the server to complete it.
+func encodeFQDN(name string) ([]byte, error) {
+	b, err := encodeDomainName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.Contains(name, ".") && len(b) > 1 {
+		b = b[:len(b)-1]
+	}
+	return b, nil
+}
+
+// decodeFQDN decodes an uncompressed name that may be partial, i.e. lack the root label.
+func decodeFQDN(b []byte) (string, error) {
+	var labels []string
+	for i := 0; i < len(b); {
+		l := int(b[i])
+		if l == 0 {
+			return strings.Join(labels, ".") + ".", nil
+		}
+		if l > maxLabelLength || i+1+l > len(b) {
+			return "", errDomainNameTruncated
+		}
+		labels = append(labels, string(b[i+1:i+1+l]))
+		i += 1 + l
+	}
+
+	return strings.Join(labels, "."), nil
+}
@@ -0,0 +1,142 @@
+package dhcp4
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LeaseEvent is the reason a hook is run, named like the reasons of dhclient-script.
+type LeaseEvent string
+
+const (
+	EventBound   LeaseEvent = "BOUND"   //a lease was acquired from INIT
+	EventRenew   LeaseEvent = "RENEW"   //the lease was extended by the server that granted it
+	EventRebind  LeaseEvent = "REBIND"  //the lease was extended by another server
+	EventReboot  LeaseEvent = "REBOOT"  //the previous lease was confirmed in INIT-REBOOT
+	EventExpire  LeaseEvent = "EXPIRE"  //the lease expired without being extended
+	EventRelease LeaseEvent = "RELEASE" //the lease was released
+	EventNak     LeaseEvent = "NAK"     //the server refused the request
+)
+
+// LeaseHook is notified of lease events on an interface. old is the lease before the event,
+// new the lease after it, either is nil when there is none.
+type LeaseHook interface {
+	LeaseEvent(event LeaseEvent, ifname string, old, new *Lease) error
+}
+
+// LeaseHookFunc adapts a function to LeaseHook.
+type LeaseHookFunc func(event LeaseEvent, ifname string, old, new *Lease) error
+
+func (f LeaseHookFunc) LeaseEvent(event LeaseEvent, ifname string, old, new *Lease) error {
+	return f(event, ifname, old, new)
+}
+
+// ScriptHook executes a script on lease events the way dhclient runs dhclient-script:
+// the event is passed in $reason, the interface in $interface and the lease fields
+// in new_* and old_* variables, see LeaseEnv.
+type ScriptHook struct {
+	Path    string
+	Timeout time.Duration //0 waits until the script exits
+}
+
+func NewScriptHook(path string) *ScriptHook {
+	return &ScriptHook{Path: path, Timeout: 30 * time.Second}
+}
+
+func (h *ScriptHook) LeaseEvent(event LeaseEvent, ifname string, old, new *Lease) error {
+	ctx := context.Background()
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, h.Path)
+	cmd.Env = append(os.Environ(), "reason="+string(event), "interface="+ifname)
+	cmd.Env = append(cmd.Env, LeaseEnv("old_", old)...)
+	cmd.Env = append(cmd.Env, LeaseEnv("new_", new)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("hook %s %s failed:%s", h.Path, event, err.Error())
+	}
+	return nil
+}
+
+// LeaseEnv returns the fields of l as environment variables named with prefix,
+// using the variable names of dhclient-script where one exists:
+// ip_address, subnet_mask, subnet_prefix, network_number, broadcast_address, routers, domain_name_servers,
+// domain_search, classless_static_routes ("destination/prefix router" pairs), dhcp_lease_time,
+// dhcp_renewal_time, dhcp_rebinding_time, dhcp_server_identifier, fqdn_fqdn, vendor_class_identifier,
+// vendor_encapsulated_options (hex), captive_portal, wpad, next_server, server_name and filename.
+func LeaseEnv(prefix string, l *Lease) []string {
+	if l == nil {
+		return nil
+	}
+
+	var env []string
+	add := func(name, value string) {
+		if value != "" {
+			env = append(env, prefix+name+"="+value)
+		}
+	}
+
+	add("ip_address", l.ClientIP.String())
+	if l.SubnetMask != nil {
+		network := &net.IPNet{IP: l.ClientIP.Mask(l.SubnetMask), Mask: l.SubnetMask}
+		ones, _ := l.SubnetMask.Size()
+		add("subnet_mask", net.IP(l.SubnetMask).String())
+		add("subnet_prefix", strconv.Itoa(ones))
+		add("network_number", network.IP.String())
+		if cfg, err := newInterfaceConfig(l); err == nil {
+			add("broadcast_address", cfg.broadcast.String())
+		}
+	}
+	add("routers", joinIPs(l.Routers))
+	add("domain_name_servers", joinIPs(l.DomainNameServer))
+	add("domain_search", strings.Join(l.DomainSearch, " "))
+	if len(l.ClasslessRoutes) > 0 {
+		routes := make([]string, 0, len(l.ClasslessRoutes))
+		for _, r := range l.ClasslessRoutes {
+			routes = append(routes, r.Destination.String()+" "+r.Router.String())
+		}
+		add("classless_static_routes", strings.Join(routes, " "))
+	}
+	if l.LeaseTime != 0 {
+		add("dhcp_lease_time", strconv.FormatUint(uint64(l.LeaseTime), 10))
+	}
+	if l.RenewalTime != 0 {
+		add("dhcp_renewal_time", strconv.FormatUint(uint64(l.RenewalTime), 10))
+	}
+	if l.RebindingTime != 0 {
+		add("dhcp_rebinding_time", strconv.FormatUint(uint64(l.RebindingTime), 10))
+	}
+	if l.ServerIdentifier != nil {
+		add("dhcp_server_identifier", l.ServerIdentifier.String())
+	}
+	add("fqdn_fqdn", l.ClientFQDN)
+	add("vendor_class_identifier", l.VendorClass)
+	if len(l.VendorSpecific) > 0 {
+		var data []byte
+		for _, sub := range l.VendorSpecific {
+			data = append(data, sub.Code, uint8(len(sub.Value)))
+			data = append(data, sub.Value...)
+		}
+		add("vendor_encapsulated_options", hex.EncodeToString(data))
+	}
+	add("captive_portal", l.CaptivePortal)
+	add("wpad", l.WPAD)
+	if l.NextServer != nil {
+		add("next_server", l.NextServer.String())
+	}
+	add("server_name", l.ServerName)
+	add("filename", l.BootFile)
+	return env
+}
@@ -0,0 +1,232 @@
+package dhcp4
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InfiniteLeaseTime is the option 51 value of a lease that never expires.
+const InfiniteLeaseTime = 0xffffffff
+
+// Lease is the configuration handed to the client by a DHCPACK.
+type Lease struct {
+	ClientIP         net.IP
+	SubnetMask       net.IPMask
+	Routers          []net.IP
+	DomainNameServer []net.IP
+	ServerIdentifier net.IP
+	LeaseTime        uint32
+	RenewalTime      uint32
+	RebindingTime    uint32
+	DomainSearch     []string
+	ClasslessRoutes  []ClasslessRoute
+	ClientFQDN       string
+	FQDNFlags        uint8
+	VendorClass      string
+	VendorSpecific   []VendorSubOption
+	CaptivePortal    string
+	WPAD             string
+	NextServer       net.IP
+	ServerName       string //'sname' field
+	BootFile         string //'file' field
+	TFTPServerName   string //option 66
+	BootFileName     string //option 67
+	BootServer       net.IP //proxyDHCP server the boot information came from
+}
+
+func NewLease(m *Message) *Lease {
+	l := &Lease{ClientIP: net.IP(m.YourIP)}
+	l.setBoot(m)
+	for _, option := range m.Options {
+		switch o := option.(type) {
+		case Option1:
+			l.SubnetMask = net.IPMask(o.SubnetMask)
+		case Option3:
+			for _, router := range o.Routers {
+				l.Routers = append(l.Routers, net.IP(router))
+			}
+		case Option6:
+			for _, server := range o.DomainNameServers {
+				l.DomainNameServer = append(l.DomainNameServer, net.IP(server))
+			}
+		case Option43:
+			l.VendorSpecific = o.SubOptions
+		case Option51:
+			l.LeaseTime = BytesToUint32(o.LeaseTime)
+		case Option54:
+			l.ServerIdentifier = net.IP(o.ServerIdentifier)
+		case Option58:
+			l.RenewalTime = BytesToUint32(o.RenewalTime)
+		case Option59:
+			l.RebindingTime = BytesToUint32(o.RebindingTime)
+		case Option60:
+			l.VendorClass = string(o.VendorClass)
+		case Option81:
+			l.ClientFQDN = o.DomainName
+			l.FQDNFlags = o.Flags
+		case Option114:
+			l.CaptivePortal = o.URI
+		case Option119:
+			l.DomainSearch = o.SearchList
+		case Option121:
+			l.ClasslessRoutes = o.Routes
+		case Option252:
+			l.WPAD = o.URL
+		}
+	}
+
+	return l
+}
+
+// Timers returns the renewal time T1, the rebinding time T2 and the lease duration.
+// T1 and T2 default to 0.5 and 0.875 times the lease duration (RFC 2131 section 4.4.5).
+func (l *Lease) Timers() (time.Duration, time.Duration, time.Duration) {
+	lease := time.Duration(l.LeaseTime) * time.Second
+	t1, t2 := lease/2, lease*7/8
+	if l.RenewalTime != 0 && l.RenewalTime < l.LeaseTime {
+		t1 = time.Duration(l.RenewalTime) * time.Second
+	}
+	if l.RebindingTime != 0 && l.RebindingTime < l.LeaseTime {
+		t2 = time.Duration(l.RebindingTime) * time.Second
+	}
+	if t1 > t2 {
+		t1 = t2
+	}
+	return t1, t2, lease
+}
+
+// setBoot takes the boot information of m: next server, 'sname' and 'file' fields and options 66/67.
+func (l *Lease) setBoot(m *Message) {
+	if !net.IP(m.NextServerIP).Equal(net.IPv4zero) {
+		l.NextServer = net.IP(m.NextServerIP)
+	}
+	if serverName := m.ServerName(); serverName != "" {
+		l.ServerName = serverName
+	}
+	if bootFile := m.FileName(); bootFile != "" {
+		l.BootFile = bootFile
+	}
+	if option66, ok := m.getOption(66).(Option66); ok {
+		l.TFTPServerName = option66.TFTPServerName
+	}
+	if option67, ok := m.getOption(67).(Option67); ok {
+		l.BootFileName = option67.BootFileName
+	}
+}
+
+// setBootServer merges the boot information of the proxyDHCP ACK m sent by server.
+func (l *Lease) setBootServer(m *Message, server net.IP) {
+	l.setBoot(m)
+	l.BootServer = server
+}
+
+func (l *Lease) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("IP Address:")
+	buf.WriteString(l.ClientIP.String())
+	buf.WriteString("\n")
+	buf.WriteString("Subnet Mask:")
+	buf.WriteString(l.SubnetMask.String())
+	buf.WriteString("\n")
+	buf.WriteString("Routers:")
+	buf.WriteString(joinIPs(l.Routers))
+	buf.WriteString("\n")
+	buf.WriteString("Domain Name Servers:")
+	buf.WriteString(joinIPs(l.DomainNameServer))
+	buf.WriteString("\n")
+	for _, route := range l.ClasslessRoutes {
+		buf.WriteString("Classless Route:")
+		buf.WriteString(route.String())
+		buf.WriteString("\n")
+	}
+	buf.WriteString("Domain Search:")
+	buf.WriteString(strings.Join(l.DomainSearch, " "))
+	buf.WriteString("\n")
+	if l.ClientFQDN != "" {
+		buf.WriteString("Client FQDN:")
+		buf.WriteString(l.ClientFQDN)
+		buf.WriteString(" Flags:")
+		buf.WriteString(FQDNFlagsString(l.FQDNFlags))
+		buf.WriteString("\n")
+	}
+	if l.VendorClass != "" {
+		buf.WriteString("Vendor Class:")
+		buf.WriteString(l.VendorClass)
+		buf.WriteString("\n")
+	}
+	for _, subOption := range l.VendorSpecific {
+		buf.WriteString("Vendor-Specific Sub-option ")
+		buf.WriteString(strconv.FormatUint(uint64(subOption.Code), 10))
+		buf.WriteString(":")
+		buf.WriteString(hex.EncodeToString(subOption.Value))
+		buf.WriteString("\n")
+	}
+	if l.CaptivePortal != "" {
+		buf.WriteString("Captive Portal:")
+		buf.WriteString(l.CaptivePortal)
+		if err := ValidateCaptivePortalURI(l.CaptivePortal); err != nil {
+			buf.WriteString(" (invalid:")
+			buf.WriteString(err.Error())
+			buf.WriteString(")")
+		}
+		buf.WriteString("\n")
+	}
+	if l.WPAD != "" {
+		buf.WriteString("WPAD:")
+		buf.WriteString(l.WPAD)
+		if err := ValidateWPADURL(l.WPAD); err != nil {
+			buf.WriteString(" (invalid:")
+			buf.WriteString(err.Error())
+			buf.WriteString(")")
+		}
+		buf.WriteString("\n")
+	}
+	if l.NextServer != nil || l.BootFile != "" || l.BootFileName != "" {
+		buf.WriteString("Next Server:")
+		buf.WriteString(l.NextServer.String())
+		buf.WriteString("\n")
+		buf.WriteString("Server Host Name:")
+		buf.WriteString(l.ServerName)
+		buf.WriteString("\n")
+		buf.WriteString("Boot File:")
+		buf.WriteString(l.BootFile)
+		buf.WriteString("\n")
+		buf.WriteString("TFTP Server Name(option 66):")
+		buf.WriteString(l.TFTPServerName)
+		buf.WriteString("\n")
+		buf.WriteString("Bootfile Name(option 67):")
+		buf.WriteString(l.BootFileName)
+		buf.WriteString("\n")
+	}
+	if l.BootServer != nil {
+		buf.WriteString("ProxyDHCP Server:")
+		buf.WriteString(l.BootServer.String())
+		buf.WriteString("\n")
+	}
+	buf.WriteString("Server Identifier:")
+	buf.WriteString(l.ServerIdentifier.String())
+	buf.WriteString("\n")
+	buf.WriteString("Lease Time:")
+	buf.WriteString(strconv.FormatUint(uint64(l.LeaseTime), 10))
+	buf.WriteString("\n")
+	buf.WriteString("Renewal Time:")
+	buf.WriteString(strconv.FormatUint(uint64(l.RenewalTime), 10))
+	buf.WriteString("\n")
+	buf.WriteString("Rebinding Time:")
+	buf.WriteString(strconv.FormatUint(uint64(l.RebindingTime), 10))
+	buf.WriteString("\n")
+
+	return buf.String()
+}
+
+func joinIPs(ips []net.IP) string {
+	s := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		s = append(s, ip.String())
+	}
+	return strings.Join(s, " ")
+}
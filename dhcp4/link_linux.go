@@ -0,0 +1,85 @@
+//go:build linux
+
+package dhcp4
+
+import (
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	iffLowerUp = 0x10000 //IFF_LOWER_UP, the driver signals carrier
+	rtmgrpLink = 0x1     //RTMGRP_LINK multicast group of link events
+)
+
+// watchCarrier sends the carrier state of ifi to changes whenever it changes, until stop is closed.
+// The carrier is assumed up at the start, so the first value sent is false if it is down.
+// Link events are received from the kernel on the RTMGRP_LINK netlink group.
+func watchCarrier(ifi *net.Interface, changes chan<- bool, stop <-chan bool) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: rtmgrpLink}); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+	tv := syscall.NsecToTimeval(time.Second.Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+
+	//the answer to RTM_GETLINK reports the current state like an event
+	msg := syscall.IfInfomsg{Family: syscall.AF_UNSPEC, Index: int32(ifi.Index)}
+	r := newNetlinkRequest(syscall.RTM_GETLINK, 0)
+	r.append((*[syscall.SizeofIfInfomsg]byte)(unsafe.Pointer(&msg))[:])
+	if err := syscall.Sendto(fd, r.serialize(), 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		syscall.Close(fd)
+		return err
+	}
+
+	go func() {
+		defer syscall.Close(fd)
+		carrier := true
+		buf := make([]byte, syscall.Getpagesize())
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			n, _, err := syscall.Recvfrom(fd, buf, 0)
+			if err != nil {
+				continue
+			}
+			msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			for _, m := range msgs {
+				if m.Header.Type != syscall.RTM_NEWLINK || len(m.Data) < syscall.SizeofIfInfomsg {
+					continue
+				}
+				info := (*syscall.IfInfomsg)(unsafe.Pointer(&m.Data[0]))
+				if int(info.Index) != ifi.Index {
+					continue
+				}
+				up := info.Flags&syscall.IFF_UP != 0 && info.Flags&iffLowerUp != 0
+				if up == carrier {
+					continue
+				}
+				carrier = up
+				select {
+				case changes <- up:
+				case <-stop:
+					return
+				}
+			}
+		}
+	}()
+	return nil
+}
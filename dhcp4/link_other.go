@@ -0,0 +1,13 @@
+//go:build !linux
+
+package dhcp4
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+func watchCarrier(ifi *net.Interface, changes chan<- bool, stop <-chan bool) error {
+	return fmt.Errorf("link state watching is not supported on %s", runtime.GOOS)
+}
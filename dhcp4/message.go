@@ -3,6 +3,7 @@ package dhcp4
 import (
 	"bytes"
 	"encoding/hex"
+	"fmt"
 	"net"
 )
 
@@ -30,6 +31,9 @@ var MagicCookie = []byte{0x63, 0x82, 0x53, 0x63}
 
 const MinRequestLength = 300
 
+// MinMessageLength is the length of the fixed fields plus the magic cookie.
+const MinMessageLength = 240
+
 type ClientHardware struct {
 	HardwareAddress        []byte `json:"chaddr"`        //Client hardware address(6 octets)
 	HardwareAddressPadding []byte `json:"chaddrpadding"` //Client hardware address padding(10 octets)
@@ -61,6 +65,33 @@ func (m *Message) getOption(code uint8) OptionInter {
 	return nil
 }
 
+// Option returns the option code of m, nil if m does not carry it.
+func (m *Message) Option(code uint8) OptionInter {
+	return m.getOption(code)
+}
+
+func hasOption(options []OptionInter, code uint8) bool {
+	for _, option := range options {
+		if option.GetCode() == code {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutParameterRequestList drops option 55, which RFC 2131 table 5 forbids in DHCPDECLINE and DHCPRELEASE.
+func withoutParameterRequestList(options []OptionInter) []OptionInter {
+	var filtered []OptionInter
+	for _, option := range options {
+		if option.GetCode() != 55 {
+			filtered = append(filtered, option)
+		}
+	}
+	return filtered
+}
+
+// GenDiscoverMessage builds a DHCPDISCOVER, the default parameter request list
+// is added after option 53 unless options carry their own option 55.
 func GenDiscoverMessage(mac string, options ...OptionInter) *Message {
 	m := &Message{}
 	m.OpCode = 1
@@ -78,7 +109,10 @@ func GenDiscoverMessage(mac string, options ...OptionInter) *Message {
 	m.ServerHostName = make([]byte, 64, 64)
 	m.BootFile = make([]byte, 128, 128)
 	m.MagicCookie = MagicCookie
-	m.Options = []OptionInter{GenOption53(MessageTypeDiscover), GenOption55()}
+	m.Options = []OptionInter{GenOption53(MessageTypeDiscover)}
+	if !hasOption(options, 55) {
+		m.Options = append(m.Options, GenOption55())
+	}
 	for _, option := range options {
 		m.Options = append(m.Options, option)
 	}
@@ -104,8 +138,8 @@ func GenDeclineMessage(mac string, declineIP []byte, options ...OptionInter) *Me
 	m.ServerHostName = make([]byte, 64, 64)
 	m.BootFile = make([]byte, 128, 128)
 	m.MagicCookie = MagicCookie
-	m.Options = []OptionInter{GenOption53(MessageTypeDecline), GenOption55(), GenOption50(declineIP)}
-	for _, option := range options {
+	m.Options = []OptionInter{GenOption53(MessageTypeDecline), GenOption50(declineIP)}
+	for _, option := range withoutParameterRequestList(options) {
 		m.Options = append(m.Options, option)
 	}
 	m.Options = append(m.Options, GenOption255())
@@ -130,8 +164,8 @@ func GenReleaseMessage(mac string, releaseIP []byte, options ...OptionInter) *Me
 	m.ServerHostName = make([]byte, 64, 64)
 	m.BootFile = make([]byte, 128, 128)
 	m.MagicCookie = MagicCookie
-	m.Options = []OptionInter{GenOption53(MessageTypeRelease), GenOption55()}
-	for _, option := range options {
+	m.Options = []OptionInter{GenOption53(MessageTypeRelease)}
+	for _, option := range withoutParameterRequestList(options) {
 		m.Options = append(m.Options, option)
 	}
 	m.Options = append(m.Options, GenOption255())
@@ -139,7 +173,30 @@ func GenReleaseMessage(mac string, releaseIP []byte, options ...OptionInter) *Me
 	return m
 }
 
+// GenRequestMessage builds the DHCPREQUEST answering offer, the default parameter request list
+// is added after option 53 unless options carry their own option 55.
+// Options 50, 54 and 61 are taken from offer unless they are given in options.
 func GenRequestMessage(offer *Message, options ...OptionInter) *Message {
+	var defaults []OptionInter
+	if !hasOption(options, 55) {
+		defaults = append(defaults, GenOption55())
+	}
+	if !hasOption(options, 50) {
+		defaults = append(defaults, GenOption50(offer.YourIP))
+	}
+	options = append(defaults, options...)
+	if option54 := offer.getOption(54); option54 != nil && !hasOption(options, 54) {
+		options = append(options, option54)
+	}
+	if option61 := offer.getOption(61); option61 != nil && !hasOption(options, 61) {
+		options = append(options, option61)
+	}
+	return genRequestMessage(offer, options)
+}
+
+// genRequestMessage builds the DHCPREQUEST answering offer with exactly options after option 53,
+// for profiles that fix the order of the options.
+func genRequestMessage(offer *Message, options []OptionInter) *Message {
 	m := &Message{}
 	m.OpCode = 1
 	m.HardwareType = 1
@@ -156,21 +213,119 @@ func GenRequestMessage(offer *Message, options ...OptionInter) *Message {
 	m.ServerHostName = make([]byte, 64, 64)
 	m.BootFile = make([]byte, 128, 128)
 	m.MagicCookie = MagicCookie
-	m.Options = []OptionInter{GenOption53(MessageTypeRequest), GenOption55(), GenOption50(offer.YourIP)}
+	m.Options = []OptionInter{GenOption53(MessageTypeRequest)}
+	m.Options = append(m.Options, options...)
+	m.Options = append(m.Options, GenOption255())
+	m.MessageType = MessageTypeRequest
+	return m
+}
+
+// GenRenewMessage builds the DHCPREQUEST of the RENEWING and REBINDING states extending the lease of clientIP:
+// ciaddr is filled in and options 50 and 54 MUST NOT be sent (RFC 2131 section 4.3.2).
+func GenRenewMessage(mac string, clientIP []byte, options ...OptionInter) *Message {
+	m := &Message{}
+	m.OpCode = 1
+	m.HardwareType = 1
+	m.HardwareLength = 6
+	m.Hops = 0
+	m.TransactionID = 0
+	m.SecondsElapsed = 0
+	m.Flags = 0
+	m.ClientIP = clientIP
+	m.YourIP = make([]byte, 4, 4)
+	m.NextServerIP = make([]byte, 4, 4)
+	m.RelayAgentIP = make([]byte, 4, 4)
+	m.ClientMAC, _ = GenClientHardware(mac)
+	m.ServerHostName = make([]byte, 64, 64)
+	m.BootFile = make([]byte, 128, 128)
+	m.MagicCookie = MagicCookie
+	m.Options = []OptionInter{GenOption53(MessageTypeRequest)}
+	if !hasOption(options, 55) {
+		m.Options = append(m.Options, GenOption55())
+	}
 	for _, option := range options {
-		m.Options = append(m.Options, option)
+		if code := option.GetCode(); code != 50 && code != 54 {
+			m.Options = append(m.Options, option)
+		}
 	}
-	if option54 := offer.getOption(54); option54 != nil {
-		m.Options = append(m.Options, option54)
+	m.Options = append(m.Options, GenOption255())
+	m.MessageType = MessageTypeRequest
+	return m
+}
+
+// GenRebootMessage builds the DHCPREQUEST of the INIT-REBOOT state verifying a previous lease:
+// ciaddr is zero, the address is requested in option 50 and option 54 MUST NOT be sent (RFC 2131 section 4.3.2).
+func GenRebootMessage(mac string, requestIP []byte, options ...OptionInter) *Message {
+	m := &Message{}
+	m.OpCode = 1
+	m.HardwareType = 1
+	m.HardwareLength = 6
+	m.Hops = 0
+	m.TransactionID = 0
+	m.SecondsElapsed = 0
+	m.Flags = 0
+	m.ClientIP = make([]byte, 4, 4)
+	m.YourIP = make([]byte, 4, 4)
+	m.NextServerIP = make([]byte, 4, 4)
+	m.RelayAgentIP = make([]byte, 4, 4)
+	m.ClientMAC, _ = GenClientHardware(mac)
+	m.ServerHostName = make([]byte, 64, 64)
+	m.BootFile = make([]byte, 128, 128)
+	m.MagicCookie = MagicCookie
+	m.Options = []OptionInter{GenOption53(MessageTypeRequest)}
+	if !hasOption(options, 55) {
+		m.Options = append(m.Options, GenOption55())
 	}
-	if option61 := offer.getOption(61); option61 != nil {
-		m.Options = append(m.Options, option61)
+	m.Options = append(m.Options, GenOption50(requestIP))
+	for _, option := range options {
+		if code := option.GetCode(); code != 50 && code != 54 {
+			m.Options = append(m.Options, option)
+		}
 	}
 	m.Options = append(m.Options, GenOption255())
 	m.MessageType = MessageTypeRequest
 	return m
 }
 
+// GenReplyMessage builds a server reply of type t to request, with xid, flags, giaddr and chaddr
+// copied from the request (RFC 2131 section 4.3.1 table 3). ciaddr and yiaddr are left zero for the caller.
+// The client identifier(option 61) is echoed back (RFC 6842), and the relay agent information(option 82)
+// as the last option (RFC 3046 section 2.2).
+func GenReplyMessage(request *Message, t MessageType, options ...OptionInter) *Message {
+	m := &Message{}
+	m.OpCode = 2
+	m.HardwareType = request.HardwareType
+	m.HardwareLength = request.HardwareLength
+	m.Hops = 0
+	m.TransactionID = request.TransactionID
+	m.SecondsElapsed = 0
+	m.Flags = request.Flags
+	m.ClientIP = make([]byte, 4, 4)
+	m.YourIP = make([]byte, 4, 4)
+	m.NextServerIP = make([]byte, 4, 4)
+	m.RelayAgentIP = make([]byte, 4, 4)
+	copy(m.RelayAgentIP, request.RelayAgentIP)
+	m.ClientMAC = ClientHardware{
+		HardwareAddress:        append([]byte(nil), request.ClientMAC.HardwareAddress...),
+		HardwareAddressPadding: make([]byte, 10, 10),
+	}
+	copy(m.ClientMAC.HardwareAddressPadding, request.ClientMAC.HardwareAddressPadding)
+	m.ServerHostName = make([]byte, 64, 64)
+	m.BootFile = make([]byte, 128, 128)
+	m.MagicCookie = MagicCookie
+	m.Options = []OptionInter{GenOption53(t)}
+	m.Options = append(m.Options, options...)
+	if option61 := request.getOption(61); option61 != nil {
+		m.Options = append(m.Options, option61)
+	}
+	if option82 := request.getOption(82); option82 != nil {
+		m.Options = append(m.Options, option82)
+	}
+	m.Options = append(m.Options, GenOption255())
+	m.MessageType = t
+	return m
+}
+
 func (m *Message) Encode() []byte {
 	var buf bytes.Buffer
 	buf.WriteByte(m.OpCode)
@@ -200,7 +355,14 @@ func (m *Message) Encode() []byte {
 	return buf.Bytes()
 }
 
-func (m *Message) Decode(data []byte) {
+// Decode decodes a DHCP message. Options split over several instances are
+// concatenated (RFC 3396), and options carried in the 'file' and 'sname' fields
+// are recovered when option 52 is present (RFC 2131 section 4.1).
+func (m *Message) Decode(data []byte) error {
+	if len(data) < MinMessageLength {
+		return fmt.Errorf("message too short: %d octets", len(data))
+	}
+
 	var buf bytes.Buffer
 	buf.Grow(len(data))
 	buf.Write(data)
@@ -223,54 +385,177 @@ func (m *Message) Decode(data []byte) {
 	m.ServerHostName = buf.Next(64)
 	m.BootFile = buf.Next(128)
 	m.MagicCookie = buf.Next(4)
+	if !bytes.Equal(m.MagicCookie, MagicCookie) {
+		return fmt.Errorf("invalid magic cookie:%s", hex.EncodeToString(m.MagicCookie))
+	}
+
 	//decode options
-	var options []OptionInter
-	option := buf.Next(1)[0]
-	for {
-		switch option {
-		case 1:
-			options = append(options, Option1{}.Decode(buf.Next(5)))
-		case 3:
-			o := Option3{}
-			o.Code = 3
-			o.Length = buf.Next(1)[0]
-			options = append(options, o.Decode(o.Length, buf.Next(int(o.Length))))
-		case 6:
-			o := Option6{}
-			o.Code = 6
-			o.Length = buf.Next(1)[0]
-			options = append(options, o.Decode(o.Length, buf.Next(int(o.Length))))
-		case 51:
-			options = append(options, Option51{}.Decode(buf.Next(5)))
-		case 53:
-			option53 := Option53{}.Decode(buf.Next(2))
-			options = append(options, option53)
+	fields := &optionFields{values: make(map[uint8][]byte)}
+	end, err := fields.parse(buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	if overload, ok := fields.values[52]; ok && len(overload) == 1 {
+		//the 'file' field MUST be interpreted before the 'sname' field
+		if overload[0]&OverloadFile != 0 {
+			if _, err := fields.parse(m.BootFile); err != nil {
+				return fmt.Errorf("overloaded file field:%s", err.Error())
+			}
+		}
+		if overload[0]&OverloadSname != 0 {
+			if _, err := fields.parse(m.ServerHostName); err != nil {
+				return fmt.Errorf("overloaded sname field:%s", err.Error())
+			}
+		}
+	}
+
+	m.Options = nil
+	for _, code := range fields.codes {
+		option := decodeOption(code, fields.values[code])
+		if option53, ok := option.(Option53); ok {
 			m.MessageType = option53.MessageType
-		case 54:
-			options = append(options, Option54{}.Decode(buf.Next(5)))
-		case 58:
-			options = append(options, Option58{}.Decode(buf.Next(5)))
-		case 59:
-			options = append(options, Option59{}.Decode(buf.Next(5)))
-		case 61:
-			length := buf.Next(1)[0]
-			o := Option61{}.Decode(buf.Next(int(length)))
-			o.Length = length
-			options = append(options, o)
-		case 108:
-			options = append(options, Option108{}.Decode(buf.Next(5)))
-		case 138:
-			length := buf.Next(1)[0]
-			o := Option138{}.Decode(buf.Next(int(length)))
-			o.Length = length
-			options = append(options, o)
+		}
+		m.Options = append(m.Options, option)
+	}
+	if end {
+		m.Options = append(m.Options, GenOption255())
+	}
+
+	return nil
+}
+
+// Overload returns the value of option 52, zero if the 'file' and 'sname' fields are not overloaded.
+func (m *Message) Overload() uint8 {
+	if option52, ok := m.getOption(52).(Option52); ok {
+		return option52.Overload
+	}
+	return 0
+}
+
+// FileName returns the 'file' field up to the first NUL, empty if the field carries options.
+func (m *Message) FileName() string {
+	if m.Overload()&OverloadFile != 0 {
+		return ""
+	}
+	return string(bytes.SplitN(m.BootFile, []byte{0}, 2)[0])
+}
+
+// ServerName returns the 'sname' field up to the first NUL, empty if the field carries options.
+func (m *Message) ServerName() string {
+	if m.Overload()&OverloadSname != 0 {
+		return ""
+	}
+	return string(bytes.SplitN(m.ServerHostName, []byte{0}, 2)[0])
+}
+
+// optionFields collects options in order of first appearance,
+// the values of repeated options are concatenated as required by RFC 3396.
+type optionFields struct {
+	codes  []uint8
+	values map[uint8][]byte
+}
+
+// parse reads options from b until the end option or the end of b, reporting whether the end option was found.
+func (f *optionFields) parse(b []byte) (bool, error) {
+	for i := 0; i < len(b); {
+		code := b[i]
+		switch code {
+		case 0:
+			i++
+			continue
 		case 255:
-			options = append(options, Option255{}.Decode([]byte{255}))
-		default:
-			m.Options = options
-			return
+			return true, nil
+		}
+
+		if i+1 >= len(b) {
+			return false, fmt.Errorf("option %d truncated", code)
 		}
-		option = buf.Next(1)[0]
+		length := int(b[i+1])
+		if i+2+length > len(b) {
+			return false, fmt.Errorf("option %d length %d exceeds message", code, length)
+		}
+
+		value, ok := f.values[code]
+		if !ok {
+			f.codes = append(f.codes, code)
+			value = []byte{}
+		}
+		f.values[code] = append(value, b[i+2:i+2+length]...)
+		i += 2 + length
+	}
+
+	return false, nil
+}
+
+// decodeOption decodes the concatenated value of an option,
+// options of unknown code or with an invalid length are returned as OptionRaw.
+func decodeOption(code uint8, value []byte) OptionInter {
+	length := len(value)
+	withLength := append([]byte{uint8(length)}, value...)
+	switch {
+	case code == 1 && length == 4:
+		return Option1{}.Decode(withLength)
+	case code == 3 && length > 0 && length%4 == 0:
+		return Option3{}.Decode(uint8(length), value)
+	case code == 6 && length > 0 && length%4 == 0:
+		return Option6{}.Decode(uint8(length), value)
+	case code == 12 && length > 0 && length <= 255:
+		return Option12{}.Decode(withLength)
+	case code == 43 && length > 0:
+		return Option43{}.Decode(value)
+	case code == 50 && length == 4:
+		return Option50{}.Decode(withLength)
+	case code == 51 && length == 4:
+		return Option51{}.Decode(withLength)
+	case code == 52 && length == 1:
+		return Option52{}.Decode(withLength)
+	case code == 53 && length == 1:
+		return Option53{}.Decode(withLength)
+	case code == 54 && length == 4:
+		return Option54{}.Decode(withLength)
+	case code == 55 && length > 0 && length <= 255:
+		return Option55{}.Decode(withLength)
+	case code == 57 && length == 2:
+		return Option57{}.Decode(withLength)
+	case code == 58 && length == 4:
+		return Option58{}.Decode(withLength)
+	case code == 59 && length == 4:
+		return Option59{}.Decode(withLength)
+	case code == 60 && length > 0:
+		return Option60{}.Decode(value)
+	case code == 61 && length > 0 && length <= 255:
+		o := Option61{}.Decode(value)
+		o.Length = uint8(length)
+		return o
+	case code == 66 && length > 0:
+		return Option66{}.Decode(value)
+	case code == 67 && length > 0:
+		return Option67{}.Decode(value)
+	case code == 81 && length >= 3 && length <= 255:
+		return Option81{}.Decode(value)
+	case code == 93 && length > 0 && length%2 == 0:
+		return Option93{}.Decode(value)
+	case code == 94 && length == 3:
+		return Option94{}.Decode(withLength)
+	case code == 97 && length > 0:
+		return Option97{}.Decode(value)
+	case code == 108 && length == 4:
+		return Option108{}.Decode(withLength)
+	case code == 114 && length > 0:
+		return Option114{}.Decode(value)
+	case code == 119:
+		return Option119{}.Decode(value)
+	case code == 121 && length >= 5:
+		return Option121{}.Decode(value)
+	case code == 138 && length%4 == 0 && length <= 255:
+		o := Option138{}.Decode(value)
+		o.Length = uint8(length)
+		return o
+	case code == 252 && length > 0:
+		return Option252{}.Decode(value)
+	default:
+		return OptionRaw{}.Decode(code, value)
 	}
 }
 
@@ -0,0 +1,55 @@
+package dhcp4
+
+import (
+	"github.com/Kseleven/agile-dhcp/metrics"
+	"strings"
+)
+
+// Metrics of the client, registered in metrics.Default. The role label is "relay" for a client
+// relaying its messages(a relay ip is set) and "client" otherwise, the exchange label is the
+// exchange a message belongs to: discover, renew, rebind or reboot.
+var (
+	messagesSent     = metrics.Default.NewCounterVec("dhcp4_client_messages_sent_total", "DHCP messages sent by type.", "role", "type")
+	messagesReceived = metrics.Default.NewCounterVec("dhcp4_client_messages_received_total", "DHCP messages received by type.", "role", "type")
+	naksReceived     = metrics.Default.NewCounterVec("dhcp4_client_naks_total", "DHCPNAK received by exchange.", "role", "exchange")
+	exchangeTimeouts = metrics.Default.NewCounterVec("dhcp4_client_timeouts_total", "Exchanges ended without an answer.", "role", "exchange")
+	decodeErrors     = metrics.Default.NewCounterVec("dhcp4_client_decode_errors_total", "Messages received that could not be decoded.", "role")
+	exchangeSeconds  = metrics.Default.NewHistogramVec("dhcp4_client_exchange_seconds",
+		"Time from the first message of an exchange to its DHCPACK or DHCPNAK.", nil, "role", "exchange")
+	activeLeases = metrics.Default.NewGaugeVec("dhcp4_client_leases_active", "Leases the clients hold.", "role")
+)
+
+// Label returns the metric label of the message type, the lowercase name or unknown.
+func (o MessageType) Label() string {
+	if name := o.String(); name != "" {
+		return strings.ToLower(name)
+	}
+	return "unknown"
+}
+
+func (c *Conn) roleLabel() string {
+	if c.isRelay() {
+		return "relay"
+	}
+	return "client"
+}
+
+func (c *Conn) exchangeLabel() string {
+	if c.requestEvent == EventBound {
+		return "discover"
+	}
+	return strings.ToLower(string(c.requestEvent))
+}
+
+// setLease makes lease the current lease, counting the clients holding one. c.mu must be held.
+func (c *Conn) setLease(lease *Lease) {
+	c.Lease = lease
+	if (lease != nil) != c.counted {
+		c.counted = lease != nil
+		if c.counted {
+			activeLeases.With(c.roleLabel()).Add(1)
+		} else {
+			activeLeases.With(c.roleLabel()).Add(-1)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package dhcp4
+
+import (
+	"net"
+	"testing"
+)
+
+func TestLeaseHandOverCountedOnce(t *testing.T) {
+	gauge := activeLeases.With("client")
+	base := gauge.Value()
+	lease := &Lease{ClientIP: net.IPv4(127, 0, 0, 100).To4()}
+
+	stopped, err := newConn("127.0.0.1", "", "", "00:00:00:00:00:01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stopped.Close()
+	stopped.SetLease(lease)
+	if gauge.Value() != base+1 {
+		t.Fatalf("%v active leases, want %v", gauge.Value(), base+1)
+	}
+
+	//a restarted session takes over the lease of the stopped one
+	started, err := newConn("127.0.0.1", "", "", "00:00:00:00:00:01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer started.Close()
+	stopped.SetLease(nil)
+	started.SetLease(lease)
+	if gauge.Value() != base+1 {
+		t.Errorf("%v active leases after the hand over, want %v", gauge.Value(), base+1)
+	}
+	started.SetLease(nil)
+	if gauge.Value() != base {
+		t.Errorf("%v active leases after the release, want %v", gauge.Value(), base)
+	}
+}
@@ -0,0 +1,151 @@
+//go:build linux
+
+package dhcp4
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	rtprotDHCP   = 16 //RTPROT_DHCP, routes installed by a DHCP client
+	nlmsgAlignTo = 4
+)
+
+func nlmsgAlign(n int) int {
+	return (n + nlmsgAlignTo - 1) & ^(nlmsgAlignTo - 1)
+}
+
+// netlinkRequest is a rtnetlink request message: header, family specific message and attributes.
+type netlinkRequest struct {
+	header syscall.NlMsghdr
+	data   []byte
+}
+
+func newNetlinkRequest(msgType uint16, flags uint16) *netlinkRequest {
+	return &netlinkRequest{header: syscall.NlMsghdr{Type: msgType, Flags: syscall.NLM_F_REQUEST | syscall.NLM_F_ACK | flags}}
+}
+
+func (r *netlinkRequest) append(b []byte) {
+	r.data = append(r.data, b...)
+	r.data = append(r.data, make([]byte, nlmsgAlign(len(r.data))-len(r.data))...)
+}
+
+func (r *netlinkRequest) addAttr(attrType uint16, value []byte) {
+	attr := syscall.RtAttr{Len: uint16(syscall.SizeofRtAttr + len(value)), Type: attrType}
+	b := (*[syscall.SizeofRtAttr]byte)(unsafe.Pointer(&attr))[:]
+	r.append(append(append([]byte(nil), b...), value...))
+}
+
+func (r *netlinkRequest) serialize() []byte {
+	r.header.Len = uint32(syscall.NLMSG_HDRLEN + len(r.data))
+	b := (*[syscall.SizeofNlMsghdr]byte)(unsafe.Pointer(&r.header))[:]
+	return append(append([]byte(nil), b...), r.data...)
+}
+
+// execute sends the request on a NETLINK_ROUTE socket and waits for the kernel's acknowledgement.
+func (r *netlinkRequest) execute() error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return err
+	}
+	r.header.Seq = 1
+	if err := syscall.Sendto(fd, r.serialize(), 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, syscall.Getpagesize())
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != r.header.Seq || m.Header.Type != syscall.NLMSG_ERROR {
+				continue
+			}
+			if len(m.Data) < 4 {
+				return fmt.Errorf("short netlink error message")
+			}
+			if errno := *(*int32)(unsafe.Pointer(&m.Data[0])); errno != 0 {
+				return syscall.Errno(-errno)
+			}
+			return nil
+		}
+	}
+}
+
+func addressRequest(msgType uint16, flags uint16, ifi *net.Interface, addr *net.IPNet) *netlinkRequest {
+	ones, _ := addr.Mask.Size()
+	msg := syscall.IfAddrmsg{
+		Family:    syscall.AF_INET,
+		Prefixlen: uint8(ones),
+		Scope:     syscall.RT_SCOPE_UNIVERSE,
+		Index:     uint32(ifi.Index),
+	}
+	r := newNetlinkRequest(msgType, flags)
+	r.append((*[syscall.SizeofIfAddrmsg]byte)(unsafe.Pointer(&msg))[:])
+	r.addAttr(syscall.IFA_LOCAL, addr.IP.To4())
+	r.addAttr(syscall.IFA_ADDRESS, addr.IP.To4())
+	return r
+}
+
+func replaceAddress(ifi *net.Interface, addr *net.IPNet, broadcast net.IP) error {
+	r := addressRequest(syscall.RTM_NEWADDR, syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE, ifi, addr)
+	r.addAttr(syscall.IFA_BROADCAST, broadcast.To4())
+	return r.execute()
+}
+
+func deleteAddress(ifi *net.Interface, addr *net.IPNet) error {
+	return addressRequest(syscall.RTM_DELADDR, 0, ifi, addr).execute()
+}
+
+func routeRequest(msgType uint16, flags uint16, ifi *net.Interface, rt route) *netlinkRequest {
+	ones, _ := rt.destination.Mask.Size()
+	msg := syscall.RtMsg{
+		Family:   syscall.AF_INET,
+		Dst_len:  uint8(ones),
+		Table:    syscall.RT_TABLE_MAIN,
+		Protocol: rtprotDHCP,
+		Scope:    syscall.RT_SCOPE_UNIVERSE,
+		Type:     syscall.RTN_UNICAST,
+	}
+	onLink := rt.gateway == nil || rt.gateway.Equal(net.IPv4zero)
+	if onLink {
+		msg.Scope = syscall.RT_SCOPE_LINK
+	}
+
+	r := newNetlinkRequest(msgType, flags)
+	r.append((*[syscall.SizeofRtMsg]byte)(unsafe.Pointer(&msg))[:])
+	if ones > 0 {
+		r.addAttr(syscall.RTA_DST, rt.destination.IP.To4())
+	}
+	if !onLink {
+		r.addAttr(syscall.RTA_GATEWAY, rt.gateway.To4())
+	}
+	index := uint32(ifi.Index)
+	r.addAttr(syscall.RTA_OIF, (*[4]byte)(unsafe.Pointer(&index))[:])
+	return r
+}
+
+func replaceRoute(ifi *net.Interface, rt route) error {
+	return routeRequest(syscall.RTM_NEWROUTE, syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE, ifi, rt).execute()
+}
+
+func deleteRoute(ifi *net.Interface, rt route) error {
+	return routeRequest(syscall.RTM_DELROUTE, 0, ifi, rt).execute()
+}
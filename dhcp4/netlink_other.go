@@ -0,0 +1,29 @@
+//go:build !linux
+
+package dhcp4
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+func errConfigureUnsupported() error {
+	return fmt.Errorf("interface configuration is not supported on %s", runtime.GOOS)
+}
+
+func replaceAddress(ifi *net.Interface, addr *net.IPNet, broadcast net.IP) error {
+	return errConfigureUnsupported()
+}
+
+func deleteAddress(ifi *net.Interface, addr *net.IPNet) error {
+	return errConfigureUnsupported()
+}
+
+func replaceRoute(ifi *net.Interface, rt route) error {
+	return errConfigureUnsupported()
+}
+
+func deleteRoute(ifi *net.Interface, rt route) error {
+	return errConfigureUnsupported()
+}
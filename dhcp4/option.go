@@ -2,8 +2,12 @@ package dhcp4
 
 import (
 	"bytes"
+	"encoding/hex"
+	"fmt"
 	"net"
+	"net/url"
 	"strconv"
+	"strings"
 )
 
 type OptionInter interface {
@@ -12,6 +16,24 @@ type OptionInter interface {
 	GetCode() uint8
 }
 
+// splitOption encodes data as one or more code/length/value instances,
+// values longer than 255 octets are split across consecutive instances (RFC 3396).
+func splitOption(code uint8, data []byte) []byte {
+	var b []byte
+	for {
+		n := len(data)
+		if n > 255 {
+			n = 255
+		}
+		b = append(b, code, uint8(n))
+		b = append(b, data[:n]...)
+		data = data[n:]
+		if len(data) == 0 {
+			return b
+		}
+	}
+}
+
 type MessageType uint8
 
 const (
@@ -107,16 +129,17 @@ type Option3 struct {
 }
 
 func (o Option3) Encode() []byte {
-	b := []byte{o.Code, o.Length}
+	var b []byte
 	for _, router := range o.Routers {
 		b = append(b, router...)
 	}
-	return b
+	return splitOption(o.Code, b)
 }
 
 func (o Option3) Decode(length uint8, b []byte) Option3 {
 	o.Code = 3
-	for i := 0; i < int(length); i += 4 {
+	o.Length = length
+	for i := 0; i+4 <= len(b); i += 4 {
 		o.Routers = append(o.Routers, b[i:i+4])
 	}
 	return o
@@ -161,16 +184,17 @@ type Option6 struct {
 }
 
 func (o Option6) Encode() []byte {
-	b := []byte{o.Code, o.Length}
+	var b []byte
 	for _, domainServer := range o.DomainNameServers {
 		b = append(b, domainServer...)
 	}
-	return b
+	return splitOption(o.Code, b)
 }
 
 func (o Option6) Decode(length uint8, b []byte) Option6 {
 	o.Code = 6
-	for i := 0; i < int(length); i += 4 {
+	o.Length = length
+	for i := 0; i+4 <= len(b); i += 4 {
 		o.DomainNameServers = append(o.DomainNameServers, b[i:i+4])
 	}
 	return o
@@ -224,7 +248,7 @@ func GenOption12(hostName string) Option12 {
 }
 
 func (o Option12) Encode() []byte {
-	return append([]byte{o.Code, o.Length}, o.HostName...)
+	return splitOption(o.Code, o.HostName)
 }
 
 func (o Option12) Decode(b []byte) Option12 {
@@ -252,6 +276,99 @@ func (o Option12) String() string {
 	return buf.String()
 }
 
+//Option43 Vendor Specific Information
+//This option is used by clients and servers to exchange vendor-specific information.
+//   The information is an opaque object of n octets, presumably interpreted by vendor-specific
+//   code on the clients and servers.  The definition of this information is vendor specific.
+//   The vendor is indicated in the vendor class identifier option.
+//   When encapsulated vendor-specific extensions are used, the information bytes
+//   consist of one or more items in the following format:
+//
+//    Code   Len   Data item        Code   Len   Data item       Code
+//   +-----+-----+-----+-----+---+-----+-----+-----+-----+---+-----+
+//   |  T1 |  n  |  d1 |  d2 |...|  T2 |  n  |  D1 |  D2 |...| ... |
+//   +-----+-----+-----+-----+---+-----+-----+-----+-----+---+-----+
+//The code for this option is 43 and its minimum length is 1.
+type Option43 struct {
+	Code       uint8
+	Length     uint8
+	Data       []byte
+	SubOptions []VendorSubOption //nil if Data is not made of encapsulated sub-options
+}
+
+type VendorSubOption struct {
+	Code  uint8
+	Value []byte
+}
+
+func GenOption43(subOptions ...VendorSubOption) Option43 {
+	var data []byte
+	for _, subOption := range subOptions {
+		data = append(data, subOption.Code, uint8(len(subOption.Value)))
+		data = append(data, subOption.Value...)
+	}
+	return Option43{Code: 43, Length: uint8(len(data)), Data: data, SubOptions: subOptions}
+}
+
+func (o Option43) Encode() []byte {
+	return splitOption(o.Code, o.Data)
+}
+
+func (o Option43) Decode(b []byte) Option43 {
+	o.Code = 43
+	o.Length = uint8(len(b))
+	o.Data = b
+	o.SubOptions = decodeVendorSubOptions(b)
+	return o
+}
+
+// decodeVendorSubOptions parses encapsulated vendor-specific sub-options,
+// returning nil if b is not a well formed sequence of them.
+func decodeVendorSubOptions(b []byte) []VendorSubOption {
+	var subOptions []VendorSubOption
+	for i := 0; i < len(b); {
+		switch b[i] {
+		case 0:
+			i++
+			continue
+		case 255:
+			return subOptions
+		}
+		if i+1 >= len(b) || i+2+int(b[i+1]) > len(b) {
+			return nil
+		}
+		subOptions = append(subOptions, VendorSubOption{Code: b[i], Value: b[i+2 : i+2+int(b[i+1])]})
+		i += 2 + int(b[i+1])
+	}
+
+	return subOptions
+}
+
+func (o Option43) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("Option:(")
+	buf.WriteString(strconv.FormatUint(uint64(o.Code), 10))
+	buf.WriteString(")")
+	buf.WriteString(" Length:")
+	buf.WriteString(strconv.FormatUint(uint64(len(o.Data)), 10))
+	buf.WriteString(" Vendor-Specific Information:")
+	if o.SubOptions == nil {
+		buf.WriteString(hex.EncodeToString(o.Data))
+	}
+	for _, subOption := range o.SubOptions {
+		buf.WriteString(strconv.FormatUint(uint64(subOption.Code), 10))
+		buf.WriteString("=")
+		buf.WriteString(hex.EncodeToString(subOption.Value))
+		buf.WriteString(" ")
+	}
+
+	return buf.String()
+}
+
+func (o Option43) GetCode() uint8 {
+	return o.Code
+}
+
 //Option50 Requested IP Address
 //This option is used in a client request (DHCPDISCOVER) to allow the
 //   client to request that a particular IP address be assigned.
@@ -361,6 +478,79 @@ func (o Option51) GetCode() uint8 {
 	return o.Code
 }
 
+//Option52 Option Overload
+//This option is used to indicate that the DHCP 'sname' or 'file'
+//   fields are being overloaded by using them to carry DHCP options. A
+//   DHCP server inserts this option if the returned parameters will
+//   exceed the usual space allotted for options.
+//   If this option is present, the client interprets the specified
+//   additional fields after it concludes interpretation of the standard
+//   option fields.
+//   The code for this option is 52, and its length is 1.  Legal values
+//   for this option are:
+//           Value   Meaning
+//           -----   --------
+//             1     the 'file' field is used to hold options
+//             2     the 'sname' field is used to hold options
+//             3     both fields are used to hold options
+//
+//    Code   Len  Value
+//   +-----+-----+-----+
+//   |  52 |  1  |1/2/3|
+//   +-----+-----+-----+
+type Option52 struct {
+	Code     uint8
+	Length   uint8
+	Overload uint8
+}
+
+const (
+	OverloadFile  uint8 = 1
+	OverloadSname uint8 = 2
+	OverloadBoth  uint8 = OverloadFile | OverloadSname
+)
+
+func GenOption52(overload uint8) Option52 {
+	return Option52{Code: 52, Length: 1, Overload: overload}
+}
+
+func (o Option52) Encode() []byte {
+	return []byte{o.Code, o.Length, o.Overload}
+}
+
+func (o Option52) Decode(b []byte) Option52 {
+	o.Code = 52
+	o.Length = b[0]
+	o.Overload = b[1]
+	return o
+}
+
+func (o Option52) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("Option:(")
+	buf.WriteString(strconv.FormatUint(uint64(o.Code), 10))
+	buf.WriteString(")")
+	buf.WriteString(" Length:")
+	buf.WriteString(strconv.FormatUint(uint64(o.Length), 10))
+	buf.WriteString(" Option Overload:")
+	switch o.Overload {
+	case OverloadFile:
+		buf.WriteString("file")
+	case OverloadSname:
+		buf.WriteString("sname")
+	case OverloadBoth:
+		buf.WriteString("file and sname")
+	default:
+		buf.WriteString(strconv.FormatUint(uint64(o.Overload), 10))
+	}
+
+	return buf.String()
+}
+
+func (o Option52) GetCode() uint8 {
+	return o.Code
+}
+
 // Option53 DHCP Message Type(3 octets)
 //Value   Message Type
 //-----   ------------
@@ -478,29 +668,47 @@ func (o Option55) GetCode() uint8 {
 	return o.Code
 }
 
-func GenOption55() Option55 {
-	//option1: Subnet Mask
-	//option3: Router
-	//option6: Domain Name Server
-	//option15: Domain Name
-	//option46: NetBIOS over TCP/IP Node Type
-	//option95: LDAP
-	//option108: IPv6-Only Preferred
-	//option114: DHCP Captive-Portal(URL)
-	//option118: Subnet Selection Option
-	//option119: DNS Domain Search List
-	//option121: Classless Static Route
-	//option252: Private/Proxy autodiscovery
-	var parameters = []byte{1, 3, 6, 15, 44, 46, 95, 108, 138, 114, 119, 121, 252}
-	return Option55{Code: 55, Length: uint8(len(parameters)), Parameters: parameters}
+//DefaultParameterRequestList is requested when the caller does not give its own list.
+//option1: Subnet Mask
+//option3: Router
+//option6: Domain Name Server
+//option15: Domain Name
+//option44: NetBIOS over TCP/IP Name Server
+//option46: NetBIOS over TCP/IP Node Type
+//option95: LDAP
+//option108: IPv6-Only Preferred
+//option138: CAPWAP Access Controller addresses
+//option114: DHCP Captive-Portal(URL)
+//option119: DNS Domain Search List
+//option121: Classless Static Route
+//option252: Private/Proxy autodiscovery
+var DefaultParameterRequestList = []byte{1, 3, 6, 15, 44, 46, 95, 108, 138, 114, 119, 121, 252}
+
+// GenOption55 builds a parameter request list keeping the order of parameters,
+// servers and fingerprinting systems depend on it. Without parameters DefaultParameterRequestList is used.
+func GenOption55(parameters ...byte) Option55 {
+	if len(parameters) == 0 {
+		parameters = DefaultParameterRequestList
+	}
+	return Option55{Code: 55, Length: uint8(len(parameters)), Parameters: append([]byte(nil), parameters...)}
+}
+
+// ParseParameterRequestList parses a comma separated list of option codes such as "1,3,6,15".
+func ParseParameterRequestList(s string) ([]byte, error) {
+	var parameters []byte
+	for _, field := range strings.Split(s, ",") {
+		code, err := strconv.ParseUint(strings.TrimSpace(field), 10, 8)
+		if err != nil || code == 0 || code == 255 {
+			return nil, fmt.Errorf("invalid option code %q in parameter request list", field)
+		}
+		parameters = append(parameters, byte(code))
+	}
+
+	return parameters, nil
 }
 
 func (o Option55) Encode() []byte {
-	var buf bytes.Buffer
-	buf.WriteByte(o.Code)
-	buf.WriteByte(o.Length)
-	buf.Write(o.Parameters)
-	return buf.Bytes()
+	return splitOption(o.Code, o.Parameters)
 }
 
 func (o Option55) Decode(b []byte) Option55 {
@@ -556,6 +764,7 @@ func (o Option57) Encode() []byte {
 }
 
 func (o Option57) Decode(b []byte) Option57 {
+	o.Code = 57
 	o.Length = b[0]
 	o.MaximumMessageSize = b[1:]
 	return o
@@ -674,6 +883,56 @@ func (o Option59) GetCode() uint8 {
 	return o.Code
 }
 
+//Option60 Vendor class identifier
+//This option is used by DHCP clients to optionally identify the vendor
+//   type and configuration of a DHCP client.  The information is a string
+//   of n octets, interpreted by servers.  Vendors may choose to define
+//   specific vendor class identifiers to convey particular configuration
+//   or other identification information about a client.
+//The code for this option is 60, and its minimum length is 1.
+//
+//   Code   Len   Vendor class Identifier
+//   +-----+-----+-----+-----+---
+//   |  60 |  n  |  i1 |  i2 | ...
+//   +-----+-----+-----+-----+---
+type Option60 struct {
+	Code        uint8
+	Length      uint8
+	VendorClass []byte
+}
+
+func GenOption60(vendorClass string) Option60 {
+	return Option60{Code: 60, Length: uint8(len(vendorClass)), VendorClass: []byte(vendorClass)}
+}
+
+func (o Option60) Encode() []byte {
+	return splitOption(o.Code, o.VendorClass)
+}
+
+func (o Option60) Decode(b []byte) Option60 {
+	o.Code = 60
+	o.Length = uint8(len(b))
+	o.VendorClass = b
+	return o
+}
+
+func (o Option60) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("Option:(")
+	buf.WriteString(strconv.FormatUint(uint64(o.Code), 10))
+	buf.WriteString(")")
+	buf.WriteString(" Length:")
+	buf.WriteString(strconv.FormatUint(uint64(o.Length), 10))
+	buf.WriteString(" Vendor Class Identifier:")
+	buf.Write(o.VendorClass)
+
+	return buf.String()
+}
+
+func (o Option60) GetCode() uint8 {
+	return o.Code
+}
+
 //Option61 Client-identifier
 //This option is used by DHCP clients to specify their unique
 //   identifier.  DHCP servers use this value to index their database of
@@ -701,7 +960,7 @@ func GenOption61(mac []byte) Option61 {
 }
 
 func (o Option61) Encode() []byte {
-	return append([]byte{o.Code, o.Length, o.HardwareType}, o.ClientIdentifier...)
+	return splitOption(o.Code, append([]byte{o.HardwareType}, o.ClientIdentifier...))
 }
 
 func (o Option61) String() string {
@@ -731,6 +990,396 @@ func (o Option61) Decode(b []byte) Option61 {
 	return o
 }
 
+//Option66 TFTP server name
+//This option is used to identify a TFTP server when the 'sname' field
+//   in the DHCP header has been used for DHCP options.
+//The code for this option is 66, and its minimum length is 1.
+//
+//    Code  Len   TFTP server
+//   +-----+-----+-----+-----+-----+---
+//   | 66  |  n  |  c1 |  c2 |  c3 | ...
+//   +-----+-----+-----+-----+-----+---
+type Option66 struct {
+	Code           uint8
+	Length         uint8
+	TFTPServerName string
+}
+
+func GenOption66(serverName string) Option66 {
+	return Option66{Code: 66, Length: uint8(len(serverName)), TFTPServerName: serverName}
+}
+
+func (o Option66) Encode() []byte {
+	return splitOption(o.Code, []byte(o.TFTPServerName))
+}
+
+func (o Option66) Decode(b []byte) Option66 {
+	o.Code = 66
+	o.Length = uint8(len(b))
+	o.TFTPServerName = strings.TrimRight(string(b), "\x00")
+	return o
+}
+
+func (o Option66) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("Option:(")
+	buf.WriteString(strconv.FormatUint(uint64(o.Code), 10))
+	buf.WriteString(")")
+	buf.WriteString(" Length:")
+	buf.WriteString(strconv.FormatUint(uint64(o.Length), 10))
+	buf.WriteString(" TFTP Server Name:")
+	buf.WriteString(o.TFTPServerName)
+
+	return buf.String()
+}
+
+func (o Option66) GetCode() uint8 {
+	return o.Code
+}
+
+//Option67 Bootfile name
+//This option is used to identify a bootfile when the 'file' field in
+//   the DHCP header has been used for DHCP options.
+//The code for this option is 67, and its minimum length is 1.
+//
+//    Code  Len   Bootfile name
+//   +-----+-----+-----+-----+-----+---
+//   | 67  |  n  |  c1 |  c2 |  c3 | ...
+//   +-----+-----+-----+-----+-----+---
+type Option67 struct {
+	Code         uint8
+	Length       uint8
+	BootFileName string
+}
+
+func GenOption67(bootFileName string) Option67 {
+	return Option67{Code: 67, Length: uint8(len(bootFileName)), BootFileName: bootFileName}
+}
+
+func (o Option67) Encode() []byte {
+	return splitOption(o.Code, []byte(o.BootFileName))
+}
+
+func (o Option67) Decode(b []byte) Option67 {
+	o.Code = 67
+	o.Length = uint8(len(b))
+	o.BootFileName = strings.TrimRight(string(b), "\x00")
+	return o
+}
+
+func (o Option67) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("Option:(")
+	buf.WriteString(strconv.FormatUint(uint64(o.Code), 10))
+	buf.WriteString(")")
+	buf.WriteString(" Length:")
+	buf.WriteString(strconv.FormatUint(uint64(o.Length), 10))
+	buf.WriteString(" Bootfile Name:")
+	buf.WriteString(o.BootFileName)
+
+	return buf.String()
+}
+
+func (o Option67) GetCode() uint8 {
+	return o.Code
+}
+
+//Option81 Client Fully Qualified Domain Name
+//The Client FQDN option is used by DHCP clients and servers to exchange
+//   information about the client's fully qualified domain name and about
+//   who has the responsibility for updating the DNS with the associated
+//   A and PTR RRs (RFC 4702).
+//        Code   Len    Flags  RCODE1 RCODE2   Domain Name
+//       +------+------+------+------+------+------+--
+//       |  81  |   n  |      |      |      |       ...
+//       +------+------+------+------+------+------+--
+//The format of the Flags field:
+//        0 1 2 3 4 5 6 7
+//       +-+-+-+-+-+-+-+-+
+//       |  MBZ  |N|E|O|S|
+//       +-+-+-+-+-+-+-+-+
+//S: the server SHOULD (1) or SHOULD NOT (0) perform the A RR (FQDN-to-address) DNS updates.
+//O: set by the server if it has overridden the client's preference for the S bit.
+//E: the domain name is in canonical wire format (1) or in deprecated ASCII encoding (0).
+//N: the server SHOULD NOT (1) or SHOULD (0) perform any DNS updates.
+//RCODE1 and RCODE2 are deprecated, a client sets them to 0 and a server to 255.
+type Option81 struct {
+	Code       uint8
+	Length     uint8
+	Flags      uint8
+	RCode1     uint8
+	RCode2     uint8
+	DomainName string
+}
+
+const (
+	FQDNFlagS uint8 = 1 << iota
+	FQDNFlagO
+	FQDNFlagE
+	FQDNFlagN
+)
+
+// ParseFQDNFlags parses a combination of the letters S, E and N into the option 81 flags of a client,
+// the O flag is set by servers only (RFC 4702 section 2.1).
+func ParseFQDNFlags(s string) (uint8, error) {
+	var flags uint8
+	for _, c := range strings.ToUpper(s) {
+		switch c {
+		case 'S':
+			flags |= FQDNFlagS
+		case 'O':
+			return 0, fmt.Errorf("FQDN flag O is set by servers only")
+		case 'E':
+			flags |= FQDNFlagE
+		case 'N':
+			flags |= FQDNFlagN
+		default:
+			return 0, fmt.Errorf("invalid FQDN flag %q", c)
+		}
+	}
+	if flags&FQDNFlagS != 0 && flags&FQDNFlagN != 0 {
+		return 0, fmt.Errorf("FQDN flags S and N are mutually exclusive")
+	}
+
+	return flags, nil
+}
+
+// FQDNFlagsString formats option 81 flags as the letters of the bits that are set.
+func FQDNFlagsString(flags uint8) string {
+	var buf bytes.Buffer
+	for _, f := range []struct {
+		flag uint8
+		name byte
+	}{{FQDNFlagS, 'S'}, {FQDNFlagO, 'O'}, {FQDNFlagE, 'E'}, {FQDNFlagN, 'N'}} {
+		if flags&f.flag != 0 {
+			buf.WriteByte(f.name)
+		}
+	}
+	return buf.String()
+}
+
+// GenOption81 builds a client FQDN option, the name is always sent in canonical wire format.
+// A name without dots is sent as a partial name for the server to complete.
+func GenOption81(domainName string, flags uint8) (Option81, error) {
+	o := Option81{Code: 81, Flags: flags | FQDNFlagE, DomainName: domainName}
+	name, err := encodeFQDN(domainName)
+	if err != nil {
+		return Option81{}, err
+	}
+	if len(name) > 252 {
+		return Option81{}, fmt.Errorf("domain name %q too long for option 81", domainName)
+	}
+
+	o.Length = uint8(3 + len(name))
+	return o, nil
+}
+
+func (o Option81) Encode() []byte {
+	var name []byte
+	if o.Flags&FQDNFlagE != 0 {
+		name, _ = encodeFQDN(o.DomainName)
+	} else {
+		name = []byte(o.DomainName)
+	}
+	return append([]byte{o.Code, uint8(3 + len(name)), o.Flags, o.RCode1, o.RCode2}, name...)
+}
+
+func (o Option81) Decode(b []byte) Option81 {
+	o.Code = 81
+	o.Length = uint8(len(b))
+	o.Flags = b[0]
+	o.RCode1 = b[1]
+	o.RCode2 = b[2]
+	if o.Flags&FQDNFlagE != 0 {
+		o.DomainName, _ = decodeFQDN(b[3:])
+	} else {
+		o.DomainName = string(b[3:])
+	}
+	return o
+}
+
+func (o Option81) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("Option:(")
+	buf.WriteString(strconv.FormatUint(uint64(o.Code), 10))
+	buf.WriteString(")")
+	buf.WriteString(" Length:")
+	buf.WriteString(strconv.FormatUint(uint64(o.Length), 10))
+	buf.WriteString(" Flags:")
+	buf.WriteString(FQDNFlagsString(o.Flags))
+	buf.WriteString(" RCODE1:")
+	buf.WriteString(strconv.FormatUint(uint64(o.RCode1), 10))
+	buf.WriteString(" RCODE2:")
+	buf.WriteString(strconv.FormatUint(uint64(o.RCode2), 10))
+	buf.WriteString(" Client FQDN:")
+	buf.WriteString(o.DomainName)
+
+	return buf.String()
+}
+
+func (o Option81) GetCode() uint8 {
+	return o.Code
+}
+
+//Option93 Client System Architecture Type
+//This option contains a list of one or more 16-bit processor architecture
+//   types of the PXE client, in order of preference (RFC 4578 section 2.1).
+//   The values are registered by IANA, e.g. 0 x86 BIOS, 6 EFI IA32, 7 EFI x64, 11 ARM64 EFI.
+//
+//    Code  Len  16-bit Type
+//   +----+-----+-----+-----+
+//   | 93 |  n  | n1  | n2  |
+//   +----+-----+-----+-----+
+type Option93 struct {
+	Code          uint8
+	Length        uint8
+	Architectures []uint16
+}
+
+func GenOption93(architectures ...uint16) Option93 {
+	return Option93{Code: 93, Length: uint8(2 * len(architectures)), Architectures: architectures}
+}
+
+func (o Option93) Encode() []byte {
+	var b []byte
+	for _, architecture := range o.Architectures {
+		b = append(b, Uint16ToBytes(architecture)...)
+	}
+	return splitOption(o.Code, b)
+}
+
+func (o Option93) Decode(b []byte) Option93 {
+	o.Code = 93
+	o.Length = uint8(len(b))
+	for i := 0; i+2 <= len(b); i += 2 {
+		o.Architectures = append(o.Architectures, BytesToUint16(b[i:i+2]))
+	}
+	return o
+}
+
+func (o Option93) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("Option:(")
+	buf.WriteString(strconv.FormatUint(uint64(o.Code), 10))
+	buf.WriteString(")")
+	buf.WriteString(" Length:")
+	buf.WriteString(strconv.FormatUint(uint64(o.Length), 10))
+	buf.WriteString(" Client System Architecture:")
+	for _, architecture := range o.Architectures {
+		buf.WriteString(strconv.FormatUint(uint64(architecture), 10))
+		buf.WriteString(" ")
+	}
+
+	return buf.String()
+}
+
+func (o Option93) GetCode() uint8 {
+	return o.Code
+}
+
+//Option94 Client Network Interface Identifier
+//This option carries the type and version of the client's network interface (RFC 4578 section 2.2).
+//   Type 1 is Universal Network Device Interface (UNDI), version 2.1 for
+//   PXE 2.1 BIOS ROMs and 3.16 for UEFI.
+//
+//    Code  Len  Type  Major Minor
+//   +----+-----+----+-----+-----+
+//   | 94 |  3  | t  |  M  |  m  |
+//   +----+-----+----+-----+-----+
+type Option94 struct {
+	Code   uint8
+	Length uint8
+	Type   uint8
+	Major  uint8
+	Minor  uint8
+}
+
+func GenOption94(major, minor uint8) Option94 {
+	return Option94{Code: 94, Length: 3, Type: 1, Major: major, Minor: minor}
+}
+
+func (o Option94) Encode() []byte {
+	return []byte{o.Code, o.Length, o.Type, o.Major, o.Minor}
+}
+
+func (o Option94) Decode(b []byte) Option94 {
+	o.Code = 94
+	o.Length = b[0]
+	o.Type = b[1]
+	o.Major = b[2]
+	o.Minor = b[3]
+	return o
+}
+
+func (o Option94) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("Option:(")
+	buf.WriteString(strconv.FormatUint(uint64(o.Code), 10))
+	buf.WriteString(")")
+	buf.WriteString(" Length:")
+	buf.WriteString(strconv.FormatUint(uint64(o.Length), 10))
+	buf.WriteString(" Client Network Interface Identifier: type ")
+	buf.WriteString(strconv.FormatUint(uint64(o.Type), 10))
+	buf.WriteString(" version ")
+	buf.WriteString(strconv.FormatUint(uint64(o.Major), 10))
+	buf.WriteString(".")
+	buf.WriteString(strconv.FormatUint(uint64(o.Minor), 10))
+
+	return buf.String()
+}
+
+func (o Option94) GetCode() uint8 {
+	return o.Code
+}
+
+//Option97 Client Machine Identifier
+//This option carries a type octet followed by the machine's 16 octet
+//   UUID/GUID (RFC 4578 section 2.3). The only defined type is 0.
+//
+//    Code  Len  Type  Machine Identifier
+//   +----+-----+----+-----+ . . . +-----+
+//   | 97 |  n  | t  |     |  . . .  |     |
+//   +----+-----+----+-----+ . . . +-----+
+type Option97 struct {
+	Code   uint8
+	Length uint8
+	Type   uint8
+	UUID   []byte
+}
+
+func GenOption97(uuid []byte) Option97 {
+	return Option97{Code: 97, Length: uint8(1 + len(uuid)), Type: 0, UUID: uuid}
+}
+
+func (o Option97) Encode() []byte {
+	return splitOption(o.Code, append([]byte{o.Type}, o.UUID...))
+}
+
+func (o Option97) Decode(b []byte) Option97 {
+	o.Code = 97
+	o.Length = uint8(len(b))
+	o.Type = b[0]
+	o.UUID = b[1:]
+	return o
+}
+
+func (o Option97) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("Option:(")
+	buf.WriteString(strconv.FormatUint(uint64(o.Code), 10))
+	buf.WriteString(")")
+	buf.WriteString(" Length:")
+	buf.WriteString(strconv.FormatUint(uint64(o.Length), 10))
+	buf.WriteString(" Client Machine Identifier:")
+	buf.WriteString(FormatUUID(o.UUID))
+
+	return buf.String()
+}
+
+func (o Option97) GetCode() uint8 {
+	return o.Code
+}
+
 //Option108 IPv6-Only Preferred Option
 //Code:
 //8-bit identifier of the IPv6-Only Preferred option code as assigned by IANA: 108.
@@ -759,6 +1408,21 @@ type Option108 struct {
 	Value  []byte
 }
 
+// MinV6OnlyWait is the minimum time in seconds a client disables DHCPv4 for (MIN_V6ONLY_WAIT, RFC 8925 section 3.4).
+const MinV6OnlyWait = 300
+
+func GenOption108(v6OnlyWait uint32) Option108 {
+	return Option108{Code: 108, Length: 4, Value: Uint32ToBytes(v6OnlyWait)}
+}
+
+// V6OnlyWait returns the number of seconds the client disables DHCPv4 for, never less than MinV6OnlyWait.
+func (o Option108) V6OnlyWait() uint32 {
+	if wait := BytesToUint32(o.Value); wait > MinV6OnlyWait {
+		return wait
+	}
+	return MinV6OnlyWait
+}
+
 func (o Option108) Encode() []byte {
 	return append([]byte{o.Code, o.Length}, o.Value...)
 }
@@ -788,6 +1452,227 @@ func (o Option108) GetCode() uint8 {
 	return o.Code
 }
 
+//Option114 DHCP Captive-Portal
+//The Captive-Portal option contains the URI of the captive portal API endpoint (RFC 8910).
+//   The URI MUST be an https URI, or the special URN
+//   "urn:ietf:params:capport:unrestricted" telling the client that there is no captive portal.
+//
+//    Code   Len          Data
+//   +------+------+------+------+------+--   --+-----+
+//   | 114  |  n   | URI                 ...          |
+//   +------+------+------+------+------+--   --+-----+
+type Option114 struct {
+	Code   uint8
+	Length uint8
+	URI    string
+}
+
+// CaptivePortalUnrestricted is the URN signaling that the network has no captive portal.
+const CaptivePortalUnrestricted = "urn:ietf:params:capport:unrestricted"
+
+func GenOption114(uri string) Option114 {
+	return Option114{Code: 114, Length: uint8(len(uri)), URI: uri}
+}
+
+// ValidateCaptivePortalURI checks that uri is an absolute https URI or CaptivePortalUnrestricted.
+func ValidateCaptivePortalURI(uri string) error {
+	if uri == CaptivePortalUnrestricted {
+		return nil
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("captive portal API URI %q is not an absolute https URI", uri)
+	}
+	return nil
+}
+
+func (o Option114) Encode() []byte {
+	return splitOption(o.Code, []byte(o.URI))
+}
+
+func (o Option114) Decode(b []byte) Option114 {
+	o.Code = 114
+	o.Length = uint8(len(b))
+	o.URI = string(b)
+	return o
+}
+
+func (o Option114) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("Option:(")
+	buf.WriteString(strconv.FormatUint(uint64(o.Code), 10))
+	buf.WriteString(")")
+	buf.WriteString(" Length:")
+	buf.WriteString(strconv.FormatUint(uint64(o.Length), 10))
+	buf.WriteString(" Captive-Portal:")
+	buf.WriteString(o.URI)
+
+	return buf.String()
+}
+
+func (o Option114) GetCode() uint8 {
+	return o.Code
+}
+
+//Option119 Domain Search Option
+//The domain search option contains a list of domain names in the wire format of RFC 1035
+//   section 3.1, compressed with pointers as described in RFC 1035 section 4.1.4.
+//   Pointers are offsets from the start of the concatenated search list.
+//   The list may exceed 255 octets, in which case it is split into multiple
+//   instances of the option as defined in RFC 3396 (RFC 3397).
+//
+//    Code   Len       Data
+//   +-----+-----+------+------+------+------+--
+//   | 119 |  n  |  s1  |  s2  |  s3  |  s4  | ...
+//   +-----+-----+------+------+------+------+--
+type Option119 struct {
+	Code       uint8
+	Length     uint8
+	SearchList []string
+	Data       []byte //encoded search list
+}
+
+func GenOption119(searchList ...string) (Option119, error) {
+	data, err := encodeDomainList(searchList)
+	if err != nil {
+		return Option119{}, err
+	}
+
+	o := Option119{Code: 119, SearchList: searchList, Data: data}
+	if len(data) > 255 {
+		o.Length = 255
+	} else {
+		o.Length = uint8(len(data))
+	}
+	return o, nil
+}
+
+func (o Option119) Encode() []byte {
+	return splitOption(o.Code, o.Data)
+}
+
+// Decode decodes the concatenated data of all option 119 instances.
+func (o Option119) Decode(b []byte) Option119 {
+	o.Code = 119
+	o.Data = b
+	o.SearchList, _ = decodeDomainList(b)
+	return o
+}
+
+func (o Option119) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("Option:(")
+	buf.WriteString(strconv.FormatUint(uint64(o.Code), 10))
+	buf.WriteString(")")
+	buf.WriteString(" Length:")
+	buf.WriteString(strconv.FormatUint(uint64(len(o.Data)), 10))
+	buf.WriteString(" Domain Search:")
+	for _, domain := range o.SearchList {
+		buf.WriteString(domain)
+		buf.WriteString(" ")
+	}
+
+	return buf.String()
+}
+
+func (o Option119) GetCode() uint8 {
+	return o.Code
+}
+
+//Option121 Classless Static Route Option
+//This option specifies a list of classless static routes that the
+//   client should install in its routing cache (RFC 3442).
+//   Each route consists of a destination descriptor and the IP address of the router.
+//   The destination descriptor is the subnet mask width followed by the
+//   significant octets of the subnet number. A router of 0.0.0.0 means the
+//   destination is on-link. If the option is present, the client MUST ignore the Router option.
+//
+//    Code Len Destination 1    Router 1
+//   +-----+---+----+-----+----+----+----+----+----+
+//   | 121 | n | d1 | ... | dN | r1 | r2 | r3 | r4 |
+//   +-----+---+----+-----+----+----+----+----+----+
+type Option121 struct {
+	Code   uint8
+	Length uint8
+	Routes []ClasslessRoute
+}
+
+type ClasslessRoute struct {
+	Destination *net.IPNet
+	Router      net.IP
+}
+
+func (r ClasslessRoute) String() string {
+	return r.Destination.String() + " via " + r.Router.String()
+}
+
+func GenOption121(routes ...ClasslessRoute) Option121 {
+	o := Option121{Code: 121, Routes: routes}
+	o.Length = uint8(len(o.encodeRoutes()))
+	return o
+}
+
+func (o Option121) encodeRoutes() []byte {
+	var b []byte
+	for _, route := range o.Routes {
+		width, _ := route.Destination.Mask.Size()
+		b = append(b, uint8(width))
+		b = append(b, route.Destination.IP.To4()[:(width+7)/8]...)
+		b = append(b, route.Router.To4()...)
+	}
+	return b
+}
+
+func (o Option121) Encode() []byte {
+	return splitOption(o.Code, o.encodeRoutes())
+}
+
+// Decode decodes the routes, stopping at the first malformed destination descriptor.
+func (o Option121) Decode(b []byte) Option121 {
+	o.Code = 121
+	o.Length = uint8(len(b))
+	for i := 0; i < len(b); {
+		width := int(b[i])
+		significant := (width + 7) / 8
+		if width > 32 || i+1+significant+4 > len(b) {
+			break
+		}
+		destination := make(net.IP, 4)
+		copy(destination, b[i+1:i+1+significant])
+		router := net.IP(b[i+1+significant : i+1+significant+4])
+		o.Routes = append(o.Routes, ClasslessRoute{
+			Destination: &net.IPNet{IP: destination, Mask: net.CIDRMask(width, 32)},
+			Router:      router,
+		})
+		i += 1 + significant + 4
+	}
+	return o
+}
+
+func (o Option121) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("Option:(")
+	buf.WriteString(strconv.FormatUint(uint64(o.Code), 10))
+	buf.WriteString(")")
+	buf.WriteString(" Length:")
+	buf.WriteString(strconv.FormatUint(uint64(o.Length), 10))
+	buf.WriteString(" Classless Static Route:")
+	for _, route := range o.Routes {
+		buf.WriteString(route.String())
+		buf.WriteString(" ")
+	}
+
+	return buf.String()
+}
+
+func (o Option121) GetCode() uint8 {
+	return o.Code
+}
+
 /*Option138
 The DHCPv4 option for CAPWAP has the format shown in the following
    figure:
@@ -815,7 +1700,7 @@ type Option138 struct {
 }
 
 func (o Option138) Encode() []byte {
-	return append([]byte{o.Code, o.Length}, o.ACIPv4s...)
+	return splitOption(o.Code, o.ACIPv4s)
 }
 
 func (o Option138) Decode(b []byte) Option138 {
@@ -844,6 +1729,108 @@ func (o Option138) GetCode() uint8 {
 	return o.Code
 }
 
+//Option252 Web Proxy Auto-Discovery
+//The WPAD option carries the URL of the proxy auto-config (PAC) file,
+//   as described in draft-ietf-wrec-wpad. Some servers terminate the string with a NUL octet,
+//   which is removed when decoding.
+//
+//    Code   Len          Data
+//   +------+------+------+------+------+--   --+-----+
+//   | 252  |  n   | URL                 ...          |
+//   +------+------+------+------+------+--   --+-----+
+type Option252 struct {
+	Code   uint8
+	Length uint8
+	URL    string
+}
+
+func GenOption252(u string) Option252 {
+	return Option252{Code: 252, Length: uint8(len(u)), URL: u}
+}
+
+// ValidateWPADURL checks that u is an absolute http or https URL.
+func ValidateWPADURL(u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("WPAD URL %q is not an absolute http or https URL", u)
+	}
+	return nil
+}
+
+func (o Option252) Encode() []byte {
+	return splitOption(o.Code, []byte(o.URL))
+}
+
+func (o Option252) Decode(b []byte) Option252 {
+	o.Code = 252
+	o.Length = uint8(len(b))
+	o.URL = strings.TrimRight(string(b), "\x00")
+	return o
+}
+
+func (o Option252) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("Option:(")
+	buf.WriteString(strconv.FormatUint(uint64(o.Code), 10))
+	buf.WriteString(")")
+	buf.WriteString(" Length:")
+	buf.WriteString(strconv.FormatUint(uint64(o.Length), 10))
+	buf.WriteString(" Proxy Auto-Discovery:")
+	buf.WriteString(o.URL)
+
+	return buf.String()
+}
+
+func (o Option252) GetCode() uint8 {
+	return o.Code
+}
+
+// OptionRaw carries any option this package has no dedicated type for.
+// The value is kept as received and is split into several instances on encoding if it is longer than 255 octets.
+type OptionRaw struct {
+	Code   uint8
+	Length uint8
+	Value  []byte
+}
+
+func GenOptionRaw(code uint8, value []byte) OptionRaw {
+	o := OptionRaw{Code: code, Value: value}
+	if len(value) > 255 {
+		o.Length = 255
+	} else {
+		o.Length = uint8(len(value))
+	}
+	return o
+}
+
+func (o OptionRaw) Encode() []byte {
+	return splitOption(o.Code, o.Value)
+}
+
+func (o OptionRaw) Decode(code uint8, b []byte) OptionRaw {
+	return GenOptionRaw(code, b)
+}
+
+func (o OptionRaw) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("Option:(")
+	buf.WriteString(strconv.FormatUint(uint64(o.Code), 10))
+	buf.WriteString(")")
+	buf.WriteString(" Length:")
+	buf.WriteString(strconv.FormatUint(uint64(len(o.Value)), 10))
+	buf.WriteString(" Value:")
+	buf.WriteString(hex.EncodeToString(o.Value))
+
+	return buf.String()
+}
+
+func (o OptionRaw) GetCode() uint8 {
+	return o.Code
+}
+
 // Option255 End Option
 //The end option marks the end of valid information in the vendor
 //   field.  Subsequent octets should be filled with pad options.
@@ -0,0 +1,25 @@
+package dhcp4
+
+import "testing"
+
+func TestParseFQDNFlags(t *testing.T) {
+	for _, tc := range []struct {
+		s     string
+		flags uint8
+		ok    bool
+	}{
+		{"", 0, true},
+		{"S", FQDNFlagS, true},
+		{"se", FQDNFlagS | FQDNFlagE, true},
+		{"N", FQDNFlagN, true},
+		{"SN", 0, false},
+		{"O", 0, false}, //RFC 4702 section 2.1: clients MUST send O=0
+		{"SO", 0, false},
+		{"X", 0, false},
+	} {
+		flags, err := ParseFQDNFlags(tc.s)
+		if (err == nil) != tc.ok || flags != tc.flags {
+			t.Errorf("ParseFQDNFlags(%q) = %d, %v", tc.s, flags, err)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package dhcp4
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Profile describes how a kind of device fills in the vendor class identifier (option 60),
+// the parameter request list (option 55) and the maximum message size (option 57).
+// Profiles of operating systems also record the order in which the options follow option 53,
+// so that the messages are byte-identical to the ones DHCP fingerprinting systems expect.
+type Profile struct {
+	Name                 string
+	Description          string
+	VendorClass          string
+	ParameterRequestList []byte
+	MaxMessageSize       uint16  //zero if the device does not send option 57
+	DiscoverOptions      []uint8 //option order in DHCPDISCOVER, nil to keep the client's order
+	RequestOptions       []uint8 //option order in DHCPREQUEST, nil to keep the client's order
+}
+
+var profiles = map[string]Profile{
+	"pxe": {
+		Name:                 "pxe",
+		Description:          "PXE boot ROM (Intel UNDI, x86 BIOS)",
+		VendorClass:          "PXEClient:Arch:00000:UNDI:002001",
+		ParameterRequestList: PXEParameterRequestList,
+		MaxMessageSize:       1260,
+	},
+	"msft": {
+		Name:                 "msft",
+		Description:          "Microsoft Windows (MSFT 5.0)",
+		VendorClass:          "MSFT 5.0",
+		ParameterRequestList: []byte{1, 3, 6, 15, 31, 33, 43, 44, 46, 47, 119, 121, 249, 252},
+	},
+	"cisco-ap": {
+		Name:                 "cisco-ap",
+		Description:          "Cisco lightweight access point",
+		VendorClass:          "Cisco AP c9120",
+		ParameterRequestList: []byte{1, 6, 15, 44, 3, 7, 33, 150, 43},
+		MaxMessageSize:       1152,
+	},
+	"aruba-ap": {
+		Name:                 "aruba-ap",
+		Description:          "Aruba access point",
+		VendorClass:          "ArubaAP",
+		ParameterRequestList: []byte{1, 3, 6, 12, 15, 28, 43},
+		MaxMessageSize:       1500,
+	},
+	"ruckus-ap": {
+		Name:                 "ruckus-ap",
+		Description:          "Ruckus access point",
+		VendorClass:          "Ruckus CPE",
+		ParameterRequestList: []byte{1, 3, 6, 12, 15, 28, 42, 43},
+		MaxMessageSize:       576,
+	},
+	"ubnt": {
+		Name:                 "ubnt",
+		Description:          "Ubiquiti UniFi device",
+		VendorClass:          "ubnt",
+		ParameterRequestList: []byte{1, 3, 6, 12, 15, 28, 42, 43},
+		MaxMessageSize:       576,
+	},
+	//operating systems and printers, fingerprinted by the order of their options
+	"windows10": {
+		Name:                 "windows10",
+		Description:          "Microsoft Windows 10",
+		VendorClass:          "MSFT 5.0",
+		ParameterRequestList: []byte{1, 3, 6, 15, 31, 33, 43, 44, 46, 47, 119, 121, 249, 252},
+		DiscoverOptions:      []uint8{61, 50, 12, 60, 55},
+		RequestOptions:       []uint8{61, 50, 54, 12, 81, 60, 55},
+	},
+	"windows11": {
+		Name:                 "windows11",
+		Description:          "Microsoft Windows 11",
+		VendorClass:          "MSFT 5.0",
+		ParameterRequestList: []byte{1, 3, 6, 15, 31, 33, 43, 44, 46, 47, 119, 121, 249, 252},
+		DiscoverOptions:      []uint8{61, 50, 12, 60, 55},
+		RequestOptions:       []uint8{61, 50, 54, 12, 81, 60, 55},
+	},
+	"macos": {
+		Name:                 "macos",
+		Description:          "Apple macOS",
+		ParameterRequestList: []byte{1, 121, 3, 6, 15, 108, 114, 119, 252, 95, 44, 46},
+		MaxMessageSize:       1500,
+		DiscoverOptions:      []uint8{55, 57, 61, 50, 51, 12},
+		RequestOptions:       []uint8{55, 57, 61, 50, 54, 51, 12},
+	},
+	"ios": {
+		Name:                 "ios",
+		Description:          "Apple iOS/iPadOS",
+		ParameterRequestList: []byte{1, 121, 3, 6, 15, 108, 114, 119, 252},
+		MaxMessageSize:       1500,
+		DiscoverOptions:      []uint8{55, 57, 61, 50, 51, 12},
+		RequestOptions:       []uint8{55, 57, 61, 50, 54, 51, 12},
+	},
+	"android": {
+		Name:                 "android",
+		Description:          "Google Android 11 or later",
+		VendorClass:          "android-dhcp-11",
+		ParameterRequestList: []byte{1, 3, 6, 15, 26, 28, 51, 58, 59, 43, 114, 108},
+		MaxMessageSize:       1500,
+		DiscoverOptions:      []uint8{61, 57, 60, 12, 55},
+		RequestOptions:       []uint8{61, 50, 54, 57, 60, 12, 55},
+	},
+	"dhclient": {
+		Name:                 "dhclient",
+		Description:          "Linux ISC dhclient",
+		ParameterRequestList: []byte{1, 28, 2, 3, 15, 6, 119, 12, 44, 47, 26, 121, 42},
+		DiscoverOptions:      []uint8{50, 12, 55},
+		RequestOptions:       []uint8{54, 50, 12, 55},
+	},
+	"systemd-networkd": {
+		Name:                 "systemd-networkd",
+		Description:          "Linux systemd-networkd",
+		ParameterRequestList: []byte{1, 3, 6, 12, 15, 28, 42, 119, 121},
+		MaxMessageSize:       1472,
+		DiscoverOptions:      []uint8{57, 61, 55, 12},
+		RequestOptions:       []uint8{57, 61, 55, 50, 54, 12},
+	},
+	"hp-printer": {
+		Name:                 "hp-printer",
+		Description:          "HP JetDirect printer",
+		VendorClass:          "Hewlett-Packard JetDirect",
+		ParameterRequestList: []byte{1, 3, 44, 6, 7, 12, 15, 22, 54, 58, 59, 69, 18, 144, 81},
+		MaxMessageSize:       1500,
+		DiscoverOptions:      []uint8{57, 61, 60, 12, 55},
+		RequestOptions:       []uint8{57, 61, 50, 54, 60, 12, 55},
+	},
+	"epson-printer": {
+		Name:                 "epson-printer",
+		Description:          "Epson network printer",
+		VendorClass:          "EPSON",
+		ParameterRequestList: []byte{1, 3, 6, 15, 44, 46, 47, 81},
+		DiscoverOptions:      []uint8{61, 12, 60, 55},
+		RequestOptions:       []uint8{61, 50, 54, 12, 60, 55},
+	},
+}
+
+// Options orders the options of a message of type t the way the profiled system does,
+// options the system does not send are dropped.
+// Without a recorded order options are returned unchanged.
+func (p *Profile) Options(t MessageType, options []OptionInter) []OptionInter {
+	var order []uint8
+	switch t {
+	case MessageTypeDiscover:
+		order = p.DiscoverOptions
+	case MessageTypeRequest:
+		order = p.RequestOptions
+	}
+	if order == nil {
+		return options
+	}
+
+	ordered := make([]OptionInter, 0, len(order))
+	for _, code := range order {
+		for _, option := range options {
+			if option.GetCode() == code {
+				ordered = append(ordered, option)
+				break
+			}
+		}
+	}
+	return ordered
+}
+
+// LookupProfile returns the built-in device profile named name.
+func LookupProfile(name string) (*Profile, error) {
+	p, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown device profile %q", name)
+	}
+
+	return &p, nil
+}
+
+// ProfileNames returns the names of the built-in device profiles in alphabetical order.
+func ProfileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *Profile) String() string {
+	return fmt.Sprintf("%s: %s (option 60 %q)", p.Name, p.Description, p.VendorClass)
+}
@@ -0,0 +1,39 @@
+package dhcp4
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+// TestProfileRequestOrder checks that a DHCPREQUEST of a profile carries the options of its order only.
+func TestProfileRequestOrder(t *testing.T) {
+	p, err := LookupProfile("dhclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := []byte{0, 0, 0, 0, 0, 1}
+	chaddr, _ := GenClientHardware("00:00:00:00:00:01")
+	offer := &Message{ClientMAC: chaddr, YourIP: net.IPv4(192, 168, 1, 10).To4()}
+	offer.Options = []OptionInter{GenOption53(MessageTypeOffer), GenOption54(net.IPv4(192, 168, 1, 1).To4()), GenOption61(mac)}
+
+	options := []OptionInter{GenOption55(), GenOption12("host"), GenOption50(offer.YourIP), GenOption61(mac), GenOption54(net.IPv4(192, 168, 1, 1).To4())}
+	m := genRequestMessage(offer, p.Options(MessageTypeRequest, options))
+	var codes []uint8
+	for _, option := range m.Options {
+		codes = append(codes, option.GetCode())
+	}
+	//dhclient sends no option 61
+	if got := fmt.Sprint(codes); got != "[53 54 50 12 55 255]" {
+		t.Errorf("options %s, want [53 54 50 12 55 255]", got)
+	}
+
+	//without a profile the options of the offer are added
+	codes = nil
+	for _, option := range GenRequestMessage(offer, GenOption55()).Options {
+		codes = append(codes, option.GetCode())
+	}
+	if got := fmt.Sprint(codes); got != "[53 50 55 54 61 255]" {
+		t.Errorf("options %s, want [53 50 55 54 61 255]", got)
+	}
+}
@@ -0,0 +1,143 @@
+package dhcp4
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/Kseleven/agile-dhcp/tftp"
+	"io"
+	"sort"
+	"strings"
+)
+
+// PXEBootServerPort is the port proxyDHCP and boot servers listen on for the client's second request.
+const PXEBootServerPort = 4011
+
+// PXEParameterRequestList is requested by PXE clients, options 128-135 are reserved for PXE.
+var PXEParameterRequestList = []byte{1, 2, 3, 4, 5, 6, 11, 12, 13, 15, 16, 17, 18, 22, 23, 28, 40, 41, 42, 43, 50, 51, 54, 58, 59, 60, 66, 67, 97, 128, 129, 130, 131, 132, 133, 134, 135}
+
+type pxeArchitecture struct {
+	Type      uint16
+	UNDIMajor uint8
+	UNDIMinor uint8
+}
+
+// pxeArchitectures maps names to the client system architecture types of RFC 4578 and the IANA registry.
+var pxeArchitectures = map[string]pxeArchitecture{
+	"bios":          {Type: 0, UNDIMajor: 2, UNDIMinor: 1},
+	"uefi-ia32":     {Type: 6, UNDIMajor: 3, UNDIMinor: 16},
+	"uefi-x64":      {Type: 7, UNDIMajor: 3, UNDIMinor: 16},
+	"uefi-arm32":    {Type: 10, UNDIMajor: 3, UNDIMinor: 16},
+	"arm64":         {Type: 11, UNDIMajor: 3, UNDIMinor: 16},
+	"uefi-x64-http": {Type: 16, UNDIMajor: 3, UNDIMinor: 16},
+}
+
+// PXE holds what a PXE boot ROM sends about itself in options 60, 93, 94 and 97.
+type PXE struct {
+	Architecture uint16
+	UNDIMajor    uint8
+	UNDIMinor    uint8
+	UUID         []byte
+}
+
+// NewPXE returns the PXE identity of the named architecture, see PXEArchitectureNames.
+// Without uuid a machine identifier is derived from mac.
+func NewPXE(architecture string, uuid string, mac []byte) (*PXE, error) {
+	arch, ok := pxeArchitectures[architecture]
+	if !ok {
+		return nil, fmt.Errorf("unknown PXE architecture %q", architecture)
+	}
+
+	p := &PXE{Architecture: arch.Type, UNDIMajor: arch.UNDIMajor, UNDIMinor: arch.UNDIMinor}
+	if uuid != "" {
+		id, err := ParseUUID(uuid)
+		if err != nil {
+			return nil, err
+		}
+		p.UUID = id
+	} else {
+		p.UUID = make([]byte, 16)
+		copy(p.UUID[16-len(mac):], mac)
+	}
+
+	return p, nil
+}
+
+// PXEArchitectureNames returns the architecture names accepted by NewPXE.
+func PXEArchitectureNames() []string {
+	names := make([]string, 0, len(pxeArchitectures))
+	for name := range pxeArchitectures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// VendorClass returns the option 60 value "PXEClient:Arch:xxxxx:UNDI:yyyzzz".
+func (p *PXE) VendorClass() string {
+	return fmt.Sprintf("PXEClient:Arch:%05d:UNDI:%03d%03d", p.Architecture, p.UNDIMajor, p.UNDIMinor)
+}
+
+func (p *PXE) Options() []OptionInter {
+	return []OptionInter{
+		GenOption60(p.VendorClass()),
+		GenOption93(p.Architecture),
+		GenOption94(p.UNDIMajor, p.UNDIMinor),
+		GenOption97(p.UUID),
+	}
+}
+
+// isProxyOffer reports whether m is a proxyDHCP offer: a PXEClient offer without an address.
+func isProxyOffer(m *Message) bool {
+	option60, ok := m.getOption(60).(Option60)
+	return ok && bytes.HasPrefix(option60.VendorClass, []byte("PXEClient")) && bytes.Equal(m.YourIP, []byte{0, 0, 0, 0})
+}
+
+// ParseUUID parses a UUID in the 8-4-4-4-12 hex form.
+func ParseUUID(s string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil || len(b) != 16 {
+		return nil, fmt.Errorf("invalid UUID %q", s)
+	}
+	return b, nil
+}
+
+// FormatUUID formats a 16 octet UUID in the 8-4-4-4-12 hex form, other lengths as plain hex.
+func FormatUUID(b []byte) string {
+	s := hex.EncodeToString(b)
+	if len(b) != 16 {
+		return s
+	}
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+// BootSource returns the TFTP server and boot file of the lease: the next server (siaddr),
+// or else option 66 or the 'sname' field, and the 'file' field or else option 67.
+func (l *Lease) BootSource() (string, string) {
+	var server, file string
+	switch {
+	case l.NextServer != nil:
+		server = l.NextServer.String()
+	case l.TFTPServerName != "":
+		server = l.TFTPServerName
+	default:
+		server = l.ServerName
+	}
+
+	if l.BootFile != "" {
+		file = l.BootFile
+	} else {
+		file = l.BootFileName
+	}
+	return server, file
+}
+
+// FetchBootFile downloads the boot file of the lease over TFTP and writes it to w.
+func (l *Lease) FetchBootFile(w io.Writer, opts tftp.Options) (*tftp.Result, error) {
+	server, file := l.BootSource()
+	if server == "" || file == "" {
+		return nil, fmt.Errorf("lease has no boot server or boot file")
+	}
+
+	return tftp.Get(server, file, w, opts)
+}
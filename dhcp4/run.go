@@ -0,0 +1,209 @@
+package dhcp4
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MinRetransmitWait is the shortest time the client waits between DHCPREQUEST retransmissions
+// in RENEWING and REBINDING state (RFC 2131 section 4.4.5).
+const MinRetransmitWait = 60 * time.Second
+
+// InitBackoff is the time the client waits before discovering again when no lease was acquired.
+const InitBackoff = 10 * time.Second
+
+// wakeup is what ended a wait of Run.
+type wakeup int
+
+const (
+	wakeTimer wakeup = iota
+	wakeRenew
+	wakeStop
+	wakeCarrierUp
+	wakeCarrierDown
+)
+
+// Run keeps a lease on the interface until Stop is called: it acquires a lease, renews it with
+// the granting server at T1, rebinds with any server at T2 and starts over when the lease expires
+// or a server refuses it (RFC 2131 section 4.4). A Lease set before Run is verified first (INIT-REBOOT).
+//
+// With LinkWatch the client pauses while the interface has no carrier. When the carrier returns,
+// a lease is verified with INIT-REBOOT and kept if no server answers, without one it discovers again.
+// The lease still expires while the carrier is down.
+func (c *Conn) Run() error {
+	if !c.persistent {
+		return errors.New("run needs a Conn created by NewDHCPClient")
+	}
+	conn, err := c.listen()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	c.listener = conn
+
+	if c.LinkWatch && c.ifnname != nil {
+		stop := make(chan bool)
+		defer close(stop)
+		if err := watchCarrier(c.ifnname, c.carrierChan, stop); err != nil {
+			fmt.Printf("watch link %s failed:%s\n", c.ifnname.Name, err.Error())
+		}
+	}
+
+	if previous := c.currentLease(); previous != nil {
+		c.exchange(func() error { return c.Reboot(previous.ClientIP) })
+		c.mu.Lock()
+		if c.Lease == previous {
+			//not confirmed, the address stays configured until a new lease replaces it
+			c.setLease(nil)
+		}
+		c.mu.Unlock()
+	}
+
+	carrier := true
+	for {
+		var wait time.Duration
+		lease, boundAt := c.binding()
+		switch {
+		case !carrier:
+			wait = -1
+		case lease == nil:
+			c.exchange(c.Discovery)
+			if c.currentLease() != nil {
+				continue
+			}
+			wait = InitBackoff
+			if c.V6OnlyWait > 0 {
+				wait, c.V6OnlyWait = c.V6OnlyWait, 0
+			}
+		default:
+			elapsed := time.Since(boundAt)
+			t1, t2, expiry := lease.Timers()
+			switch {
+			case elapsed < t1:
+				wait = t1 - elapsed
+			case elapsed < t2:
+				c.exchange(c.Renew)
+				wait = retransmitWait(t2 - elapsed)
+			case elapsed < expiry:
+				c.exchange(c.Rebind)
+				wait = retransmitWait(expiry - elapsed)
+			default:
+				c.expire(lease)
+				continue
+			}
+			if c.currentLease() != lease {
+				continue
+			}
+		}
+
+		switch c.wait(wait) {
+		case wakeStop:
+			return c.stop()
+		case wakeRenew:
+			if carrier && c.currentLease() != nil {
+				c.exchange(c.Renew)
+			}
+		case wakeCarrierDown:
+			fmt.Printf("carrier lost on %s, waiting for the link\n", c.ifnname.Name)
+			carrier = false
+		case wakeCarrierUp:
+			fmt.Printf("carrier up on %s\n", c.ifnname.Name)
+			carrier = true
+			if lease := c.currentLease(); lease != nil {
+				c.exchange(func() error { return c.Reboot(lease.ClientIP) })
+			}
+		}
+	}
+}
+
+// Renewal makes Run renew the lease now, or discover again if it has none.
+func (c *Conn) Renewal() {
+	select {
+	case c.renewChan <- true:
+	default:
+	}
+}
+
+// Stop makes Run return, releasing the lease if release is set.
+// Without release the lease stays configured on the interface.
+func (c *Conn) Stop(release bool) {
+	select {
+	case c.stopChan <- release:
+	default:
+	}
+}
+
+// wait waits for d to elapse, a renewal, a carrier change or Stop. A negative d waits without timeout.
+func (c *Conn) wait(d time.Duration) wakeup {
+	var timeout <-chan time.Time
+	if d >= 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-timeout:
+		return wakeTimer
+	case <-c.renewChan:
+		return wakeRenew
+	case up := <-c.carrierChan:
+		if up {
+			return wakeCarrierUp
+		}
+		return wakeCarrierDown
+	case release := <-c.stopChan:
+		c.stopChan <- release
+		return wakeStop
+	}
+}
+
+func (c *Conn) stop() error {
+	release := <-c.stopChan
+	lease := c.currentLease()
+	if !release || lease == nil {
+		c.mu.Lock()
+		c.stopExpiry()
+		c.mu.Unlock()
+		return nil
+	}
+
+	c.TransactionID = RandomTransactionID()
+	return c.Release(lease.ClientIP.String())
+}
+
+// binding returns the current lease and the time it was bound.
+func (c *Conn) binding() (*Lease, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Lease, c.boundAt
+}
+
+// exchange sends the message of send with a new transaction ID and handles the answers until the exchange is done.
+func (c *Conn) exchange(send func() error) {
+	c.TransactionID = RandomTransactionID()
+	c.SecondsElapsed = 0
+	c.retry = 0
+	c.declines = 0
+	c.proxyOffer = nil
+	c.proxyRequested = false
+	if err := send(); err != nil {
+		fmt.Printf("send message failed:%s\n", err.Error())
+		return
+	}
+
+	go c.serve(c.listener)
+	<-c.doneChan
+}
+
+func retransmitWait(remaining time.Duration) time.Duration {
+	wait := remaining / 2
+	if wait < MinRetransmitWait {
+		wait = MinRetransmitWait
+	}
+	if wait > remaining {
+		wait = remaining
+	}
+	return wait
+}
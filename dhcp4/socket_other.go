@@ -0,0 +1,12 @@
+//go:build !linux
+
+package dhcp4
+
+import (
+	"syscall"
+)
+
+// socketControl leaves the socket as it is, binding to an interface is only supported on linux.
+func socketControl(ifname string) func(network, address string, conn syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,317 @@
+// Package kv is a small embedded key-value store: records are appended to a data file,
+// an in-memory index maps each key to the offset of its latest value, and Compact rewrites
+// the file with the live records only. A torn record at the end of the file, left by a crash
+// during a write, is cut off when the file is opened.
+package kv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+const (
+	headerSize = 13 //crc32, kind, key length, value length
+
+	kindPut    = 1
+	kindDelete = 2
+
+	MaxKeySize   = 1 << 16
+	MaxValueSize = 1 << 24
+)
+
+var ErrClosed = errors.New("kv: database is closed")
+
+type entry struct {
+	offset int64 //offset of the value in the file
+	size   uint32
+}
+
+// DB is a key-value database in one file, safe for concurrent use.
+type DB struct {
+	path string
+
+	mu      sync.RWMutex
+	file    *os.File
+	size    int64 //offset the next record is written at
+	index   map[string]entry
+	garbage int //records superseded or deleted since the last compaction
+	sync    bool
+}
+
+// Options of Open.
+type Options struct {
+	NoSync bool //do not fsync after each write
+}
+
+// Open opens or creates the database in path and rebuilds the index from its records.
+func Open(path string, opts Options) (*DB, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	db := &DB{path: path, file: f, index: make(map[string]entry), sync: !opts.NoSync}
+	if err := db.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// load reads all records, the file is truncated after the last complete record.
+func (db *DB) load() error {
+	if _, err := db.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(db.file)
+	var offset int64
+	for {
+		kind, key, value, n, err := readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Printf("kv: %s: dropping records from offset %d:%s\n", db.path, offset, err.Error())
+			break
+		}
+
+		if _, ok := db.index[string(key)]; ok {
+			db.garbage++
+		}
+		switch kind {
+		case kindPut:
+			db.index[string(key)] = entry{offset: offset + headerSize + int64(len(key)), size: uint32(len(value))}
+		case kindDelete:
+			delete(db.index, string(key))
+			db.garbage++
+		}
+		offset += n
+	}
+
+	db.size = offset
+	if err := db.file.Truncate(offset); err != nil {
+		return err
+	}
+	_, err := db.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+func readRecord(r io.Reader) (uint8, []byte, []byte, int64, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, nil, nil, 0, errors.New("torn record header")
+		}
+		return 0, nil, nil, 0, err
+	}
+
+	kind := header[4]
+	keySize, valueSize := binary.BigEndian.Uint32(header[5:9]), binary.BigEndian.Uint32(header[9:13])
+	if (kind != kindPut && kind != kindDelete) || keySize > MaxKeySize || valueSize > MaxValueSize {
+		return 0, nil, nil, 0, errors.New("invalid record header")
+	}
+	body := make([]byte, keySize+valueSize)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, nil, 0, errors.New("torn record")
+	}
+	if crc32.ChecksumIEEE(append(header[4:], body...)) != binary.BigEndian.Uint32(header[:4]) {
+		return 0, nil, nil, 0, errors.New("checksum mismatch")
+	}
+	return kind, body[:keySize], body[keySize:], int64(headerSize) + int64(len(body)), nil
+}
+
+func encodeRecord(kind uint8, key, value []byte) []byte {
+	b := make([]byte, headerSize, headerSize+len(key)+len(value))
+	b[4] = kind
+	binary.BigEndian.PutUint32(b[5:9], uint32(len(key)))
+	binary.BigEndian.PutUint32(b[9:13], uint32(len(value)))
+	b = append(append(b, key...), value...)
+	binary.BigEndian.PutUint32(b[:4], crc32.ChecksumIEEE(b[4:]))
+	return b
+}
+
+// write appends record at db.size, a record that failed to be written or synced is cut off
+// so that the next one follows the last good record.
+func (db *DB) write(record []byte) error {
+	if db.file == nil {
+		return ErrClosed
+	}
+	_, err := db.file.WriteAt(record, db.size)
+	if err == nil && db.sync {
+		err = db.file.Sync()
+	}
+	if err != nil {
+		db.file.Truncate(db.size)
+		return err
+	}
+	return nil
+}
+
+func (db *DB) Put(key, value []byte) error {
+	if len(key) == 0 || len(key) > MaxKeySize || len(value) > MaxValueSize {
+		return fmt.Errorf("kv: invalid key or value size")
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.write(encodeRecord(kindPut, key, value)); err != nil {
+		return err
+	}
+	if _, ok := db.index[string(key)]; ok {
+		db.garbage++
+	}
+	db.index[string(key)] = entry{offset: db.size + headerSize + int64(len(key)), size: uint32(len(value))}
+	db.size += int64(headerSize + len(key) + len(value))
+	return nil
+}
+
+func (db *DB) Delete(key []byte) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.index[string(key)]; !ok {
+		return nil
+	}
+
+	record := encodeRecord(kindDelete, key, nil)
+	if err := db.write(record); err != nil {
+		return err
+	}
+	delete(db.index, string(key))
+	db.size += int64(len(record))
+	db.garbage += 2
+	return nil
+}
+
+// Get returns the value of key, false if the key does not exist.
+func (db *DB) Get(key []byte) ([]byte, bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.file == nil {
+		return nil, false, ErrClosed
+	}
+
+	e, ok := db.index[string(key)]
+	if !ok {
+		return nil, false, nil
+	}
+	value := make([]byte, e.size)
+	if _, err := db.file.ReadAt(value, e.offset); err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (db *DB) Len() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return len(db.index)
+}
+
+// Garbage returns the number of records Compact would drop.
+func (db *DB) Garbage() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.garbage
+}
+
+// ForEach calls fn with every key and value in key order, stopping at the first error.
+func (db *DB) ForEach(fn func(key, value []byte) error) error {
+	db.mu.RLock()
+	keys := make([]string, 0, len(db.index))
+	for key := range db.index {
+		keys = append(keys, key)
+	}
+	db.mu.RUnlock()
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value, ok, err := db.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err := fn([]byte(key), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Compact rewrites the file with the live records. The new file replaces the old one
+// with a rename, so a crash leaves either of them intact.
+func (db *DB) Compact() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.file == nil {
+		return ErrClosed
+	}
+
+	tmp := db.path + ".compact"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	index := make(map[string]entry, len(db.index))
+	var size int64
+	for key, e := range db.index {
+		value := make([]byte, e.size)
+		if _, err := db.file.ReadAt(value, e.offset); err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+		record := encodeRecord(kindPut, []byte(key), value)
+		if _, err := w.Write(record); err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+		index[key] = entry{offset: size + headerSize + int64(len(key)), size: e.size}
+		size += int64(len(record))
+	}
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, db.path); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	db.file.Close()
+	db.file = f
+	db.index = index
+	db.size = size
+	db.garbage = 0
+	_, err = f.Seek(size, io.SeekStart)
+	return err
+}
+
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.file == nil {
+		return nil
+	}
+	err := db.file.Close()
+	db.file = nil
+	return err
+}
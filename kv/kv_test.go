@@ -0,0 +1,146 @@
+package kv
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openTest(t *testing.T, path string) *DB {
+	db, err := Open(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func put(t *testing.T, db *DB, key, value string) {
+	if err := db.Put([]byte(key), []byte(value)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// contents returns the records of db as key=value.
+func contents(t *testing.T, db *DB) string {
+	var s string
+	if err := db.ForEach(func(key, value []byte) error {
+		s += fmt.Sprintf("%s=%s ", key, value)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := openTest(t, path)
+	put(t, db, "a", "1")
+	put(t, db, "b", "2")
+	db.Close()
+
+	info, _ := os.Stat(path)
+	complete := info.Size()
+	//a crash in the middle of the third record
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write(encodeRecord(kindPut, []byte("c"), []byte("3"))[:headerSize+1])
+	f.Close()
+
+	db = openTest(t, path)
+	if got := contents(t, db); got != "a=1 b=2 " {
+		t.Errorf("got %q after a torn record", got)
+	}
+	if info, _ := os.Stat(path); info.Size() != complete {
+		t.Errorf("file size %d, want %d without the torn record", info.Size(), complete)
+	}
+	put(t, db, "c", "3")
+	db.Close()
+	if got := contents(t, openTest(t, path)); got != "a=1 b=2 c=3 " {
+		t.Errorf("got %q after writing past the torn record", got)
+	}
+}
+
+func TestFailedWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := openTest(t, path)
+	put(t, db, "a", "1")
+
+	//a short write leaves part of a record and moves the file offset
+	db.file.WriteAt(encodeRecord(kindPut, []byte("x"), []byte("lost"))[:headerSize+2], db.size)
+	db.file.Seek(0, io.SeekEnd)
+	put(t, db, "b", "2")
+	if value, ok, err := db.Get([]byte("b")); err != nil || !ok || string(value) != "2" {
+		t.Errorf("got %q %t %v after a failed write", value, ok, err)
+	}
+	db.Close()
+	if got := contents(t, openTest(t, path)); got != "a=1 b=2 " {
+		t.Errorf("got %q after reopening", got)
+	}
+}
+
+func TestCorruptRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := openTest(t, path)
+	put(t, db, "a", "1")
+	put(t, db, "b", "2")
+	put(t, db, "c", "3")
+	db.Close()
+
+	//flip the value of the second record, the records after it are dropped with it
+	b, _ := os.ReadFile(path)
+	b[2*(headerSize+2)-1] ^= 0xff
+	os.WriteFile(path, b, 0644)
+
+	db = openTest(t, path)
+	if got := contents(t, db); got != "a=1 " {
+		t.Errorf("got %q after a corrupt record", got)
+	}
+	if info, _ := os.Stat(path); info.Size() != headerSize+2 {
+		t.Errorf("file size %d, want %d", info.Size(), headerSize+2)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := openTest(t, path)
+	for i := 0; i < 10; i++ {
+		put(t, db, "a", fmt.Sprint(i))
+		put(t, db, fmt.Sprintf("k%d", i), "x")
+	}
+	for i := 1; i < 10; i++ {
+		if err := db.Delete([]byte(fmt.Sprintf("k%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if db.Garbage() != 27 {
+		t.Errorf("garbage %d before compaction", db.Garbage())
+	}
+	before, _ := os.Stat(path)
+
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if db.Garbage() != 0 {
+		t.Errorf("garbage %d after compaction", db.Garbage())
+	}
+	after, _ := os.Stat(path)
+	if after.Size() != 2*(headerSize+2)+1 || after.Size() >= before.Size() {
+		t.Errorf("file size %d after compaction, %d before", after.Size(), before.Size())
+	}
+	if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left:%v", err)
+	}
+	if got := contents(t, db); got != "a=9 k0=x " {
+		t.Errorf("got %q after compaction", got)
+	}
+
+	//writes go to the compacted file
+	put(t, db, "b", "2")
+	db.Close()
+	if got := contents(t, openTest(t, path)); got != "a=9 b=2 k0=x " {
+		t.Errorf("got %q after reopening", got)
+	}
+}
@@ -0,0 +1,404 @@
+// Package metrics keeps counters, gauges and histograms and serves them to Prometheus
+// in its text exposition format (version 0.0.4).
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds of histogram buckets in seconds, suited to DHCP exchanges.
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Default is the registry the packages of this module register their metrics in.
+var Default = NewRegistry()
+
+// Registry holds metric families by name.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]family
+}
+
+type family interface {
+	write(b *strings.Builder)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]family)}
+}
+
+// register adds f as name, replacing a family of the same name.
+func (r *Registry) register(name string, f family) {
+	r.mu.Lock()
+	r.families[name] = f
+	r.mu.Unlock()
+}
+
+// Unregister removes the family name.
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	delete(r.families, name)
+	r.mu.Unlock()
+}
+
+// Text returns the metrics in the text exposition format, families sorted by name.
+func (r *Registry) Text() string {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	families := make([]family, len(names))
+	for i, name := range names {
+		families[i] = r.families[name]
+	}
+	r.mu.Unlock()
+
+	b := &strings.Builder{}
+	for _, f := range families {
+		f.write(b)
+	}
+	return b.String()
+}
+
+// ServeHTTP writes the metrics of r.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprint(w, r.Text())
+}
+
+// ListenAndServe serves the metrics of r on /metrics at addr in the background, errors are logged.
+func (r *Registry) ListenAndServe(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fmt.Printf("serve metrics on %s failed:%s\n", addr, err.Error())
+		}
+	}()
+	return server
+}
+
+func writeHeader(b *strings.Builder, name, help, kind string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, strings.NewReplacer("\\", `\\`, "\n", `\n`).Replace(help), name, kind)
+}
+
+// writeSample writes a sample of name with the labels names=values and extra, a name="value" pair.
+func writeSample(b *strings.Builder, name string, names, values []string, extra string, v float64) {
+	b.WriteString(name)
+	if len(names) > 0 || extra != "" {
+		b.WriteByte('{')
+		for i, label := range names {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(label)
+			b.WriteString(`="`)
+			b.WriteString(strings.NewReplacer("\\", `\\`, "\n", `\n`, `"`, `\"`).Replace(values[i]))
+			b.WriteByte('"')
+		}
+		if extra != "" {
+			if len(names) > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(extra)
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatFloat(v))
+	b.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// value is a float updated atomically.
+type value struct {
+	bits uint64
+}
+
+func (v *value) add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&v.bits)
+		if atomic.CompareAndSwapUint64(&v.bits, old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (v *value) set(f float64) {
+	atomic.StoreUint64(&v.bits, math.Float64bits(f))
+}
+
+func (v *value) get() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&v.bits))
+}
+
+// vec holds the children of a family by label values.
+type vec struct {
+	name, help, kind string
+	labels           []string
+
+	mu       sync.Mutex
+	children map[string]interface{}
+	values   map[string][]string
+}
+
+func newVec(name, help, kind string, labels []string) *vec {
+	return &vec{name: name, help: help, kind: kind, labels: labels,
+		children: make(map[string]interface{}), values: make(map[string][]string)}
+}
+
+// child returns the child of values, created with create the first time.
+func (v *vec) child(values []string, create func() interface{}) interface{} {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c, ok := v.children[key]
+	if !ok {
+		c = create()
+		v.children[key] = c
+		v.values[key] = append([]string(nil), values...)
+	}
+	return c
+}
+
+// empty reports whether the family has no samples yet, it is left out of the metrics.
+func (v *vec) empty() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return len(v.children) == 0
+}
+
+// each calls f with the children sorted by label values.
+func (v *vec) each(f func(values []string, child interface{})) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	children := make([]interface{}, len(keys))
+	values := make([][]string, len(keys))
+	for i, key := range keys {
+		children[i], values[i] = v.children[key], v.values[key]
+	}
+	v.mu.Unlock()
+	for i := range keys {
+		f(values[i], children[i])
+	}
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	v value
+}
+
+func (c *Counter) Inc() {
+	c.v.add(1)
+}
+
+// Add adds delta, which must not be negative.
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		return
+	}
+	c.v.add(delta)
+}
+
+func (c *Counter) Value() float64 {
+	return c.v.get()
+}
+
+type CounterVec struct {
+	*vec
+}
+
+// NewCounterVec registers a counter family partitioned by labels.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, "counter", labels)}
+	r.register(name, c)
+	return c
+}
+
+// NewCounter registers a counter without labels.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+// With returns the counter of the label values, in the order of the labels.
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.child(values, func() interface{} { return &Counter{} }).(*Counter)
+}
+
+func (c *CounterVec) write(b *strings.Builder) {
+	if c.empty() {
+		return
+	}
+	writeHeader(b, c.name, c.help, c.kind)
+	c.each(func(values []string, child interface{}) {
+		writeSample(b, c.name, c.labels, values, "", child.(*Counter).Value())
+	})
+}
+
+// Gauge is a value that goes up and down.
+type Gauge struct {
+	v value
+}
+
+func (g *Gauge) Set(f float64) {
+	g.v.set(f)
+}
+
+func (g *Gauge) Add(delta float64) {
+	g.v.add(delta)
+}
+
+func (g *Gauge) Value() float64 {
+	return g.v.get()
+}
+
+type GaugeVec struct {
+	*vec
+}
+
+// NewGaugeVec registers a gauge family partitioned by labels.
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newVec(name, help, "gauge", labels)}
+	r.register(name, g)
+	return g
+}
+
+// NewGauge registers a gauge without labels.
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return r.NewGaugeVec(name, help).With()
+}
+
+// With returns the gauge of the label values, in the order of the labels.
+func (g *GaugeVec) With(values ...string) *Gauge {
+	return g.child(values, func() interface{} { return &Gauge{} }).(*Gauge)
+}
+
+func (g *GaugeVec) write(b *strings.Builder) {
+	if g.empty() {
+		return
+	}
+	writeHeader(b, g.name, g.help, g.kind)
+	g.each(func(values []string, child interface{}) {
+		writeSample(b, g.name, g.labels, values, "", child.(*Gauge).Value())
+	})
+}
+
+// Histogram counts observations in buckets of upper bounds.
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64 //per bucket, not cumulative
+	sum     float64
+	count   uint64
+}
+
+func (h *Histogram) Observe(f float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i := sort.SearchFloat64s(h.buckets, f); i < len(h.buckets) {
+		h.counts[i]++
+	}
+	h.sum += f
+	h.count++
+}
+
+// ObserveSince observes the seconds elapsed since start.
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+type HistogramVec struct {
+	*vec
+	buckets []float64
+}
+
+// NewHistogramVec registers a histogram family partitioned by labels, with DefaultBuckets if buckets is nil.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{vec: newVec(name, help, "histogram", labels), buckets: buckets}
+	r.register(name, h)
+	return h
+}
+
+// With returns the histogram of the label values, in the order of the labels.
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return h.child(values, func() interface{} {
+		return &Histogram{buckets: h.buckets, counts: make([]uint64, len(h.buckets))}
+	}).(*Histogram)
+}
+
+func (h *HistogramVec) write(b *strings.Builder) {
+	if h.empty() {
+		return
+	}
+	writeHeader(b, h.name, h.help, h.kind)
+	h.each(func(values []string, child interface{}) {
+		hist := child.(*Histogram)
+		hist.mu.Lock()
+		var cumulative uint64
+		for i, bound := range hist.buckets {
+			cumulative += hist.counts[i]
+			writeSample(b, h.name+"_bucket", h.labels, values, `le="`+formatFloat(bound)+`"`, float64(cumulative))
+		}
+		writeSample(b, h.name+"_bucket", h.labels, values, `le="+Inf"`, float64(hist.count))
+		writeSample(b, h.name+"_sum", h.labels, values, "", hist.sum)
+		writeSample(b, h.name+"_count", h.labels, values, "", float64(hist.count))
+		hist.mu.Unlock()
+	})
+}
+
+// Collector reports the samples of a family when the metrics are read, for values kept elsewhere.
+type Collector struct {
+	name, help, kind string
+	labels           []string
+	collect          func(emit func(v float64, values ...string))
+}
+
+// NewCollector registers a family of kind counter or gauge whose samples collect emits on each read.
+func (r *Registry) NewCollector(name, help, kind string, labels []string, collect func(emit func(v float64, values ...string))) *Collector {
+	c := &Collector{name: name, help: help, kind: kind, labels: labels, collect: collect}
+	r.register(name, c)
+	return c
+}
+
+func (c *Collector) write(b *strings.Builder) {
+	writeHeader(b, c.name, c.help, c.kind)
+	c.collect(func(v float64, values ...string) {
+		if len(values) != len(c.labels) {
+			panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", c.name, len(c.labels), len(values)))
+		}
+		writeSample(b, c.name, c.labels, values, "", v)
+	})
+}
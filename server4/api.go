@@ -0,0 +1,352 @@
+package server4
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// APIConfig enables the management API, HTTP with JSON bodies. Every request carries the token
+// in an "Authorization: Bearer <token>" header.
+//
+//	GET    /api/leases               leases, filtered by ip, mac, client, hostname, subnet and state(active or expired)
+//	GET    /api/leases/{ip}          a lease
+//	DELETE /api/leases/{ip}          removes a lease and frees its address, like a release by the client
+//	POST   /api/leases/{ip}/expire   ends a lease now, its address is freed by the next sweep
+//	GET    /api/reservations         host reservations
+//	POST   /api/reservations         adds a host reservation, a HostConfig with its subnet
+//	GET    /api/pools                address statistics of the subnets
+//
+// Reservations added through the API are kept across reloads but not restarts,
+// add them to the config file to keep them.
+type APIConfig struct {
+	Address string `json:"address"` //"host:port" to listen on
+	Token   string `json:"token"`
+}
+
+// apiReservation is a reservation added through the API.
+type apiReservation struct {
+	Subnet string `json:"subnet"` //CIDR, the subnet of the address by default
+	HostConfig
+}
+
+// reservationStatus is a host reservation as the API shows it.
+type reservationStatus struct {
+	Name     string   `json:"name"`
+	Subnet   string   `json:"subnet"`
+	Clients  []string `json:"clients"` //keys of the client, see Request.Key
+	Address  string   `json:"address,omitempty"`
+	HostName string   `json:"hostname,omitempty"`
+}
+
+type poolStatus struct {
+	Stats
+	Utilization float64 `json:"utilization"`
+}
+
+func (ac *APIConfig) compile(errs *configErrors) {
+	if _, _, err := net.SplitHostPort(ac.Address); err != nil {
+		errs.addf("api.address", "invalid address %q, use host:port", ac.Address)
+	}
+	if ac.Token == "" {
+		errs.addf("api.token", "api has no token")
+	}
+}
+
+// startAPI listens on the address of the management API, it is served until Close.
+func (s *Server) startAPI(ac *APIConfig) (*http.Server, error) {
+	listener, err := net.Listen("tcp", ac.Address)
+	if err != nil {
+		return nil, fmt.Errorf("listen api on %s failed:%s", ac.Address, err.Error())
+	}
+	server := &http.Server{Handler: s.apiHandler(ac.Token), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			fmt.Printf("serve api failed:%s\n", err.Error())
+		}
+	}()
+	return server, nil
+}
+
+// apiHandler returns the handler of the management API accepting token.
+func (s *Server) apiHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/leases", s.apiLeases)
+	mux.HandleFunc("/api/leases/", s.apiLease)
+	mux.HandleFunc("/api/reservations", s.apiReservations)
+	mux.HandleFunc("/api/pools", s.apiPools)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") || subtle.ConstantTimeCompare([]byte(auth[len("Bearer "):]), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="dhcp_server4"`)
+			writeError(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Printf("write api response failed:%s\n", err.Error())
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, format string, a ...interface{}) {
+	writeJSON(w, status, map[string]string{"error": fmt.Sprintf(format, a...)})
+}
+
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+	return false
+}
+
+// apiLeases lists the leases matching the query parameters.
+func (s *Server) apiLeases(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	query := r.URL.Query()
+	var network *net.IPNet
+	if subnet := query.Get("subnet"); subnet != "" {
+		var err error
+		if _, network, err = net.ParseCIDR(subnet); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid subnet %q", subnet)
+			return
+		}
+	}
+	var mac net.HardwareAddr
+	if query.Get("mac") != "" {
+		var err error
+		if mac, err = net.ParseMAC(query.Get("mac")); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid mac %q", query.Get("mac"))
+			return
+		}
+	}
+	state := query.Get("state")
+	if state != "" && state != "active" && state != "expired" {
+		writeError(w, http.StatusBadRequest, "invalid state %q, use active or expired", state)
+		return
+	}
+
+	leases, err := s.Store.Leases()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "list leases failed:%s", err.Error())
+		return
+	}
+	now := time.Now()
+	matched := make([]*Lease, 0, len(leases))
+	for _, l := range leases {
+		switch {
+		case query.Get("ip") != "" && !l.IP.Equal(net.ParseIP(query.Get("ip"))):
+		case mac != nil && l.MAC != mac.String():
+		case query.Get("client") != "" && l.Client != query.Get("client"):
+		case query.Get("hostname") != "" &&
+			!strings.Contains(strings.ToLower(l.HostName+" "+l.FQDN), strings.ToLower(query.Get("hostname"))):
+		case network != nil && !network.Contains(l.IP):
+		case state == "active" && l.Expired(now), state == "expired" && !l.Expired(now):
+		default:
+			matched = append(matched, l)
+		}
+	}
+	writeJSON(w, http.StatusOK, matched)
+}
+
+// apiLease shows, deletes or expires the lease of /api/leases/{ip}[/expire].
+func (s *Server) apiLease(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/leases/")
+	action := ""
+	if i := strings.IndexByte(path, '/'); i >= 0 {
+		path, action = path[:i], path[i+1:]
+	}
+	ip := net.ParseIP(path).To4()
+	if ip == nil {
+		writeError(w, http.StatusBadRequest, "invalid address %q", path)
+		return
+	}
+	if action != "" && action != "expire" {
+		writeError(w, http.StatusNotFound, "unknown action %q", action)
+		return
+	}
+
+	l, ok, err := s.Store.Get(ip)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "get lease %s failed:%s", ip, err.Error())
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "no lease of %s", ip)
+		return
+	}
+
+	switch action {
+	case "":
+		if !allowMethods(w, r, http.MethodGet, http.MethodDelete) {
+			return
+		}
+		if r.Method == http.MethodGet {
+			writeJSON(w, http.StatusOK, l)
+			return
+		}
+		s.mu.RLock()
+		if subnet := s.settings.pool.Subnet(ip); subnet != nil {
+			if owner, ok := subnet.Owner(ip); ok && owner == l.Client {
+				subnet.Release(ip)
+			}
+		}
+		s.deleteLease(s.settings, ip, l.Client)
+		s.mu.RUnlock()
+		fmt.Printf("lease %s of %s deleted through the api\n", ip, l.Client)
+		w.WriteHeader(http.StatusNoContent)
+	case "expire":
+		if !allowMethods(w, r, http.MethodPost) {
+			return
+		}
+		now := time.Now()
+		if !l.Expired(now) {
+			l.Expiry = now
+			if err := s.Store.Put(l); err != nil {
+				writeError(w, http.StatusInternalServerError, "store lease %s failed:%s", ip, err.Error())
+				return
+			}
+			if s.failover != nil {
+				s.failover.update(l, now)
+			}
+			fmt.Printf("lease %s of %s expired through the api\n", ip, l.Client)
+		}
+		writeJSON(w, http.StatusOK, l)
+	}
+}
+
+// apiReservations lists or adds host reservations.
+func (s *Server) apiReservations(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		s.mu.RLock()
+		reservations := s.settings.reservations()
+		s.mu.RUnlock()
+		writeJSON(w, http.StatusOK, reservations)
+		return
+	}
+
+	var ar apiReservation
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&ar); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid reservation:%s", err.Error())
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, err := s.settings.addReservation(ar)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+	s.reservations = append(s.reservations, ar)
+	fmt.Printf("reservation %s added through the api\n", h.name)
+	writeJSON(w, http.StatusCreated, h.status(s.settings.hosts))
+}
+
+// apiPools shows the address statistics of the subnets.
+func (s *Server) apiPools(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	stats := s.poolStats()
+	pools := make([]poolStatus, len(stats))
+	for i, st := range stats {
+		pools[i] = poolStatus{Stats: st, Utilization: st.Utilization()}
+	}
+	writeJSON(w, http.StatusOK, pools)
+}
+
+// addReservation adds the host of ar to st, nothing is changed if it is invalid.
+func (st *settings) addReservation(ar apiReservation) (*host, error) {
+	var subnet *Subnet
+	if _, network, err := net.ParseCIDR(ar.Subnet); err == nil {
+		for _, sn := range st.pool.Subnets() {
+			if sn.Network.String() == network.String() {
+				subnet = sn
+			}
+		}
+	}
+	if ar.Subnet == "" {
+		if ip := net.ParseIP(ar.Address); ip != nil {
+			subnet = st.pool.Subnet(ip)
+		}
+	}
+	if subnet == nil {
+		return nil, fmt.Errorf("no subnet %q for the reservation", ar.Subnet)
+	}
+	if ar.Name == "" {
+		return nil, fmt.Errorf("reservation has no name")
+	}
+
+	hosts := make(map[string]*host, len(st.hosts))
+	for key, h := range st.hosts {
+		hosts[key] = h
+	}
+	added := &settings{config: st.config, pool: st.pool, hosts: hosts}
+	errs := &configErrors{config: &Config{file: "reservation " + ar.Name}}
+	ar.HostConfig.compile("", subnet, added, errs)
+	if len(errs.errs) > 0 {
+		return nil, errs.errs
+	}
+	var h *host
+	for key, other := range hosts {
+		if _, ok := st.hosts[key]; !ok {
+			h = other
+		}
+	}
+	st.hosts = hosts
+	return h, nil
+}
+
+// reservations returns the host reservations of st, by name.
+func (st *settings) reservations() []reservationStatus {
+	seen := make(map[*host]bool)
+	var hosts []*host
+	for _, h := range st.hosts {
+		if !seen[h] {
+			seen[h] = true
+			hosts = append(hosts, h)
+		}
+	}
+	reservations := make([]reservationStatus, 0, len(hosts))
+	for _, h := range hosts {
+		reservations = append(reservations, h.status(st.hosts))
+	}
+	sort.Slice(reservations, func(i, j int) bool { return reservations[i].Name < reservations[j].Name })
+	return reservations
+}
+
+// status returns the reservation of h, with its keys in hosts.
+func (h *host) status(hosts map[string]*host) reservationStatus {
+	status := reservationStatus{Name: h.name, Subnet: h.subnet.Network.String(), HostName: h.hostName}
+	if h.address != nil {
+		status.Address = h.address.String()
+	}
+	for key, other := range hosts {
+		if other == h {
+			status.Clients = append(status.Clients, key)
+		}
+	}
+	sort.Strings(status.Clients)
+	return status
+}
@@ -0,0 +1,81 @@
+package server4
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIToken(t *testing.T) {
+	h := newTestServer(t, false).apiHandler("secret")
+	for _, tc := range []struct {
+		auth   string
+		status int
+	}{
+		{"Bearer secret", http.StatusOK},
+		{"secret", http.StatusUnauthorized}, //the scheme is required
+		{"Bearer other", http.StatusUnauthorized},
+		{"", http.StatusUnauthorized},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/api/pools", nil)
+		if tc.auth != "" {
+			r.Header.Set("Authorization", tc.auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Errorf("Authorization %q: status %d, want %d", tc.auth, w.Code, tc.status)
+		}
+	}
+}
+
+func TestAPIReservationSubnet(t *testing.T) {
+	h := newTestServer(t, false).apiHandler("secret")
+	for _, tc := range []struct {
+		body   string
+		status int
+	}{
+		{`{"subnet": "127.0.0.0/24", "name": "a", "mac": "00:00:00:00:00:01", "address": "127.0.0.10"}`, http.StatusCreated},
+		{`{"subnet": "127.0.0.5/24", "name": "b", "mac": "00:00:00:00:00:02", "address": "127.0.0.11"}`, http.StatusCreated},
+		{`{"subnet": "10.0.0.0/24", "name": "c", "mac": "00:00:00:00:00:03", "address": "127.0.0.12"}`, http.StatusBadRequest},
+		{`{"subnet": "127.0.0.0", "name": "d", "mac": "00:00:00:00:00:04", "address": "127.0.0.13"}`, http.StatusBadRequest},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/api/reservations", strings.NewReader(tc.body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Errorf("%s: status %d, want %d:%s", tc.body, w.Code, tc.status, w.Body.String())
+		}
+	}
+}
+
+// TestAPIReservationConflict checks that a rejected reservation leaves the reservations of the pool unchanged.
+func TestAPIReservationConflict(t *testing.T) {
+	config, err := ParseConfig("test.json", []byte(`{"interfaces": ["lo"], "leaseTime": 3600,
+	"subnets": [{"subnet": "127.0.0.0/24", "pools": [{"range": "127.0.0.100-127.0.0.150"}],
+		"hosts": [{"name": "cfg", "mac": "00:00:00:00:00:01", "address": "127.0.0.10"}]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewServer(config, NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"subnet": "127.0.0.0/24", "name": "x", "mac": "00:00:00:00:00:01", "address": "127.0.0.20"}`
+	r := httptest.NewRequest(http.MethodPost, "/api/reservations", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	s.apiHandler("secret").ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "is also host cfg") {
+		t.Fatalf("status %d:%s", w.Code, w.Body.String())
+	}
+	subnet := s.settings.pool.Subnets()[0]
+	mac, _ := net.ParseMAC("00:00:00:00:00:01")
+	if ip, ok := subnet.Reservation(Request{MAC: mac}); !ok || ip.String() != "127.0.0.10" {
+		t.Errorf("reservation moved to %s", ip)
+	}
+}
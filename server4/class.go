@@ -0,0 +1,396 @@
+package server4
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/Kseleven/agile-dhcp/dhcp4"
+	"net"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expression classifies clients by the fields of their messages. The grammar is
+//
+//	expr    = and { "or" and }
+//	and     = not { "and" not }
+//	not     = "not" not | compare
+//	compare = value [ ( "==" | "!=" | "startswith" | "endswith" | "contains" ) value ]
+//	value   = field | string | number | hex | "(" expr ")"
+//
+// Fields are vendor-class(option 60), user-class(option 77), client-id(option 61), hostname(option 12),
+// circuit-id and remote-id(option 82 sub-options 1 and 2), mac(lowercase, colon separated), htype(decimal),
+// giaddr(dotted) and option[code] for the value of any option. A field alone is true if the message carries it.
+// Strings are double quoted with Go escapes, hex values are written 0x0102 and compared as octets.
+//
+//	vendor-class startswith "PXEClient" and htype == 1
+//	mac startswith "00:11:22" or circuit-id == 0x000400010001
+type Expression struct {
+	source string
+	root   expressionNode
+}
+
+// expressionNode evaluates to a value and its truth: whether it is present, or the result of a comparison.
+type expressionNode interface {
+	eval(m *dhcp4.Message) ([]byte, bool)
+}
+
+var expressionFields = map[string]func(m *dhcp4.Message) ([]byte, bool){
+	"vendor-class": func(m *dhcp4.Message) ([]byte, bool) { return optionData(m, 60) },
+	"user-class":   func(m *dhcp4.Message) ([]byte, bool) { return optionData(m, 77) },
+	"client-id":    func(m *dhcp4.Message) ([]byte, bool) { return optionData(m, 61) },
+	"hostname":     func(m *dhcp4.Message) ([]byte, bool) { return optionData(m, 12) },
+	"circuit-id":   func(m *dhcp4.Message) ([]byte, bool) { return relayAgentSubOption(m, 1) },
+	"remote-id":    func(m *dhcp4.Message) ([]byte, bool) { return relayAgentSubOption(m, 2) },
+	"mac": func(m *dhcp4.Message) ([]byte, bool) {
+		mac := m.ClientMAC.HardwareAddress
+		if int(m.HardwareLength) < len(mac) {
+			mac = mac[:m.HardwareLength]
+		}
+		return []byte(net.HardwareAddr(mac).String()), len(mac) > 0
+	},
+	"htype": func(m *dhcp4.Message) ([]byte, bool) {
+		return []byte(strconv.Itoa(int(m.HardwareType))), true
+	},
+	"giaddr": func(m *dhcp4.Message) ([]byte, bool) {
+		giaddr := net.IP(m.RelayAgentIP)
+		if giaddr.To4() == nil || giaddr.Equal(net.IPv4zero) {
+			return nil, false
+		}
+		return []byte(giaddr.String()), true
+	},
+}
+
+// optionData returns the value of option code of m, the values of split instances concatenated.
+func optionData(m *dhcp4.Message, code uint8) ([]byte, bool) {
+	option := m.Option(code)
+	if option == nil {
+		return nil, false
+	}
+	b := option.Encode()
+	var data []byte
+	for i := 0; i+1 < len(b); i += 2 + int(b[i+1]) {
+		end := i + 2 + int(b[i+1])
+		if end > len(b) {
+			end = len(b)
+		}
+		data = append(data, b[i+2:end]...)
+	}
+	return data, true
+}
+
+// relayAgentSubOption returns sub-option code of the relay agent information(option 82, RFC 3046).
+func relayAgentSubOption(m *dhcp4.Message, code uint8) ([]byte, bool) {
+	data, ok := optionData(m, 82)
+	if !ok {
+		return nil, false
+	}
+	for i := 0; i+1 < len(data); i += 2 + int(data[i+1]) {
+		if end := i + 2 + int(data[i+1]); data[i] == code && end <= len(data) {
+			return data[i+2 : end], true
+		}
+	}
+	return nil, false
+}
+
+// ParseExpression compiles an expression, errors give the column of the offending token.
+func ParseExpression(s string) (*Expression, error) {
+	p := &expressionParser{source: s}
+	if err := p.scan(); err != nil {
+		return nil, err
+	}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.token.kind != tokenEnd {
+		return nil, p.errorf("unexpected %s", p.token)
+	}
+	return &Expression{source: s, root: root}, nil
+}
+
+// Match reports whether m satisfies the expression.
+func (e *Expression) Match(m *dhcp4.Message) bool {
+	_, ok := e.root.eval(m)
+	return ok
+}
+
+func (e *Expression) String() string {
+	return e.source
+}
+
+type tokenKind int
+
+const (
+	tokenEnd  tokenKind = iota
+	tokenWord           //field name, keyword or operator word
+	tokenString
+	tokenNumber
+	tokenHex
+	tokenOperator //== !=
+	tokenLeft
+	tokenRight
+)
+
+type token struct {
+	kind   tokenKind
+	text   string
+	value  []byte
+	column int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEnd {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+type expressionParser struct {
+	source string
+	offset int
+	token  token
+}
+
+func (p *expressionParser) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("column %d: %s", p.token.column, fmt.Sprintf(format, a...))
+}
+
+// scan reads the next token.
+func (p *expressionParser) scan() error {
+	for p.offset < len(p.source) && unicode.IsSpace(rune(p.source[p.offset])) {
+		p.offset++
+	}
+	start := p.offset
+	p.token = token{column: start + 1}
+	if start == len(p.source) {
+		p.token.kind = tokenEnd
+		return nil
+	}
+
+	rest := p.source[start:]
+	switch c := rest[0]; {
+	case c == '(' || c == ')':
+		p.token.kind = map[byte]tokenKind{'(': tokenLeft, ')': tokenRight}[c]
+		p.offset++
+	case strings.HasPrefix(rest, "==") || strings.HasPrefix(rest, "!="):
+		p.token.kind = tokenOperator
+		p.offset += 2
+	case c == '"':
+		end := 1
+		for ; end < len(rest) && rest[end] != '"'; end++ {
+			if rest[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(rest) {
+			return p.errorf("unterminated string")
+		}
+		value, err := strconv.Unquote(rest[:end+1])
+		if err != nil {
+			return p.errorf("invalid string %s", rest[:end+1])
+		}
+		p.token.kind, p.token.value = tokenString, []byte(value)
+		p.offset += end + 1
+	case strings.HasPrefix(rest, "0x"):
+		end := 2
+		for end < len(rest) && strings.IndexByte("0123456789abcdefABCDEF", rest[end]) >= 0 {
+			end++
+		}
+		value, err := hex.DecodeString(rest[2:end])
+		if err != nil || end == 2 {
+			return p.errorf("invalid hex value %s", rest[:end])
+		}
+		p.token.kind, p.token.value = tokenHex, value
+		p.offset += end
+	case c >= '0' && c <= '9':
+		end := 0
+		for end < len(rest) && rest[end] >= '0' && rest[end] <= '9' {
+			end++
+		}
+		p.token.kind, p.token.value = tokenNumber, []byte(rest[:end])
+		p.offset += end
+	case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		end := 0
+		for end < len(rest) && (unicode.IsLetter(rune(rest[end])) || unicode.IsDigit(rune(rest[end])) ||
+			rest[end] == '-' || rest[end] == '[' || rest[end] == ']') {
+			end++
+		}
+		p.token.kind = tokenWord
+		p.offset += end
+	default:
+		return p.errorf("unexpected character %q", c)
+	}
+	p.token.text = p.source[start:p.offset]
+	return nil
+}
+
+func (p *expressionParser) or() (expressionNode, error) {
+	left, err := p.and()
+	for err == nil && p.token.kind == tokenWord && p.token.text == "or" {
+		if err = p.scan(); err != nil {
+			return nil, err
+		}
+		var right expressionNode
+		if right, err = p.and(); err == nil {
+			left = orNode{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *expressionParser) and() (expressionNode, error) {
+	left, err := p.not()
+	for err == nil && p.token.kind == tokenWord && p.token.text == "and" {
+		if err = p.scan(); err != nil {
+			return nil, err
+		}
+		var right expressionNode
+		if right, err = p.not(); err == nil {
+			left = andNode{left, right}
+		}
+	}
+	return left, err
+}
+
+func (p *expressionParser) not() (expressionNode, error) {
+	if p.token.kind == tokenWord && p.token.text == "not" {
+		if err := p.scan(); err != nil {
+			return nil, err
+		}
+		operand, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+	}
+	return p.compare()
+}
+
+var compareOperators = map[string]func(a, b []byte) bool{
+	"==":         bytes.Equal,
+	"!=":         func(a, b []byte) bool { return !bytes.Equal(a, b) },
+	"startswith": bytes.HasPrefix,
+	"endswith":   bytes.HasSuffix,
+	"contains":   bytes.Contains,
+}
+
+func (p *expressionParser) compare() (expressionNode, error) {
+	left, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+
+	compare, ok := compareOperators[p.token.text]
+	if !ok || (p.token.kind != tokenOperator && p.token.kind != tokenWord) {
+		return left, nil
+	}
+	operator := p.token.text
+	if err := p.scan(); err != nil {
+		return nil, err
+	}
+	right, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	return compareNode{operator: operator, compare: compare, left: left, right: right}, nil
+}
+
+func (p *expressionParser) value() (expressionNode, error) {
+	t := p.token
+	switch t.kind {
+	case tokenLeft:
+		if err := p.scan(); err != nil {
+			return nil, err
+		}
+		node, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.token.kind != tokenRight {
+			return nil, p.errorf("expected \")\", found %s", p.token)
+		}
+		return node, p.scan()
+	case tokenString, tokenNumber, tokenHex:
+		return literalNode(t.value), p.scan()
+	case tokenWord:
+		if field, ok := expressionFields[t.text]; ok {
+			return fieldNode(field), p.scan()
+		}
+		if strings.HasPrefix(t.text, "option[") && strings.HasSuffix(t.text, "]") {
+			code, err := strconv.ParseUint(t.text[len("option["):len(t.text)-1], 10, 8)
+			if err != nil {
+				return nil, p.errorf("invalid option code in %s", t.text)
+			}
+			return fieldNode(func(m *dhcp4.Message) ([]byte, bool) { return optionData(m, uint8(code)) }), p.scan()
+		}
+		return nil, p.errorf("unknown field %s", t)
+	default:
+		return nil, p.errorf("expected a value, found %s", t)
+	}
+}
+
+type literalNode []byte
+
+func (n literalNode) eval(*dhcp4.Message) ([]byte, bool) {
+	return n, true
+}
+
+type fieldNode func(m *dhcp4.Message) ([]byte, bool)
+
+func (n fieldNode) eval(m *dhcp4.Message) ([]byte, bool) {
+	return n(m)
+}
+
+type compareNode struct {
+	operator    string
+	compare     func(a, b []byte) bool
+	left, right expressionNode
+}
+
+// eval is false when either side is missing, "!=" included.
+func (n compareNode) eval(m *dhcp4.Message) ([]byte, bool) {
+	left, ok := n.left.eval(m)
+	if !ok {
+		return nil, false
+	}
+	right, ok := n.right.eval(m)
+	if !ok {
+		return nil, false
+	}
+	return nil, n.compare(left, right)
+}
+
+type andNode struct {
+	left, right expressionNode
+}
+
+func (n andNode) eval(m *dhcp4.Message) ([]byte, bool) {
+	if _, ok := n.left.eval(m); !ok {
+		return nil, false
+	}
+	_, ok := n.right.eval(m)
+	return nil, ok
+}
+
+type orNode struct {
+	left, right expressionNode
+}
+
+func (n orNode) eval(m *dhcp4.Message) ([]byte, bool) {
+	if _, ok := n.left.eval(m); ok {
+		return nil, true
+	}
+	_, ok := n.right.eval(m)
+	return nil, ok
+}
+
+type notNode struct {
+	operand expressionNode
+}
+
+func (n notNode) eval(m *dhcp4.Message) ([]byte, bool) {
+	_, ok := n.operand.eval(m)
+	return nil, !ok
+}
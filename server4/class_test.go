@@ -0,0 +1,108 @@
+package server4
+
+import (
+	"github.com/Kseleven/agile-dhcp/dhcp4"
+	"net"
+	"testing"
+)
+
+// classTestMessage returns a relayed DHCPDISCOVER with vendor and user class, host name and relay agent information.
+func classTestMessage(t *testing.T, relayed bool) *dhcp4.Message {
+	options := []dhcp4.OptionInter{
+		dhcp4.GenOption60("PXEClient:Arch:00000"),
+		dhcp4.GenOptionRaw(77, []byte("iPXE")),
+		dhcp4.GenOption12("host1"),
+	}
+	if relayed {
+		//circuit-id 0x000400010001 and remote-id "r1"
+		options = append(options, dhcp4.GenOptionRaw(82, []byte{1, 6, 0, 4, 0, 1, 0, 1, 2, 2, 'r', '1'}))
+	}
+	m := dhcp4.GenDiscoverMessage("00:11:22:33:44:55", options...)
+	if relayed {
+		m.RelayAgentIP = net.IPv4(10, 0, 0, 1).To4()
+	}
+	d := &dhcp4.Message{}
+	if err := d.Decode(m.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestExpressionMatch(t *testing.T) {
+	relayed, direct := classTestMessage(t, true), classTestMessage(t, false)
+	for _, tc := range []struct {
+		expr    string
+		relayed bool
+		direct  bool
+	}{
+		//fields alone are true when present
+		{`hostname`, true, true},
+		{`user-class`, true, true},
+		{`option[43]`, false, false},
+		{`giaddr`, true, false},
+		{`circuit-id`, true, false},
+
+		{`vendor-class startswith "PXEClient"`, true, true},
+		{`vendor-class == "PXEClient"`, false, false},
+		{`option[60] endswith "00000"`, true, true},
+		{`user-class contains "PX"`, true, true},
+		{`mac startswith "00:11:22"`, true, true},
+		{`giaddr == "10.0.0.1"`, true, false},
+		//option 82 sub-options, as hex or escaped string
+		{`circuit-id == 0x000400010001`, true, false},
+		{`circuit-id == "\x00\x04\x00\x01\x00\x01"`, true, false},
+		{`remote-id == "r1"`, true, false},
+		//numbers and strings compare as text, hex values as octets
+		{`htype == 1`, true, true},
+		{`htype == "1"`, true, true},
+		{`htype == 0x01`, false, false},
+		//a comparison with a missing side is false, != included
+		{`option[43] != "x"`, false, false},
+		{`remote-id != "r2"`, true, false},
+
+		{`not option[43]`, true, true},
+		{`not not hostname`, true, true},
+		{`not giaddr`, false, true},
+		//and binds tighter than or, not tighter than both
+		{`hostname or option[43] and option[44]`, true, true},
+		{`(hostname or option[43]) and option[44]`, false, false},
+		{`not hostname or htype == 1`, true, true},
+		{`not hostname and htype == 1`, false, false},
+		{`not (giaddr and htype == 1)`, false, true},
+	} {
+		e, err := ParseExpression(tc.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tc.expr, err)
+			continue
+		}
+		if got := e.Match(relayed); got != tc.relayed {
+			t.Errorf("%s matches the relayed message: %t", tc.expr, got)
+		}
+		if got := e.Match(direct); got != tc.direct {
+			t.Errorf("%s matches the direct message: %t", tc.expr, got)
+		}
+	}
+}
+
+func TestParseExpressionErrors(t *testing.T) {
+	for _, tc := range []struct {
+		expr string
+		err  string
+	}{
+		{`vendor-class ==`, `column 16: expected a value, found end of expression`},
+		{`foo == 1`, `column 1: unknown field "foo"`},
+		{`hostname and (htype == 1`, `column 25: expected ")", found end of expression`},
+		{`hostname == "abc`, `column 13: unterminated string`},
+		{`option[300]`, `column 1: invalid option code in option[300]`},
+		{`hostname == 0xZZ`, `column 13: invalid hex value 0x`},
+		{`hostname == 0x123`, `column 13: invalid hex value 0x123`},
+		{`hostname & 1`, `column 10: unexpected character '&'`},
+		{`hostname hostname`, `column 10: unexpected "hostname"`},
+		{`not`, `column 4: expected a value, found end of expression`},
+	} {
+		_, err := ParseExpression(tc.expr)
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("%s: got error %v, want %s", tc.expr, err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,773 @@
+package server4
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Kseleven/agile-dhcp/dhcp4"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultLeaseTime = 86400 //seconds, when no scope sets leaseTime
+
+// Config is the configuration file of the server, in JSON. Options and lease times are set in
+// scopes: global, client class, subnet, pool and host, each overriding the scopes before it.
+type Config struct {
+	Interfaces       []string        `json:"interfaces"`
+	ServerIdentifier string          `json:"serverIdentifier"` //address of the receiving interface by default
+	LeaseStore       StoreConfig     `json:"leaseStore"`
+	PingCheck        bool            `json:"pingCheck"`     //ping addresses before offering them
+	Authoritative    bool            `json:"authoritative"` //NAK requests the server has no record of instead of ignoring them
+	Failover         *FailoverConfig `json:"failover"`
+	DDNS             *DDNSConfig     `json:"ddns"`
+	Limits           *LimitsConfig   `json:"limits"`
+	API              *APIConfig      `json:"api"`
+	ScopeConfig
+	Classes []ClassConfig  `json:"classes"`
+	Subnets []SubnetConfig `json:"subnets"`
+
+	file   string
+	source []byte
+}
+
+type StoreConfig struct {
+	Type string `json:"type"` //memory, journal or kv
+	Path string `json:"path"`
+}
+
+// FailoverConfig pairs the server with a partner sharing its leases, see Failover.
+type FailoverConfig struct {
+	Role             string `json:"role"`             //primary or secondary
+	Address          string `json:"address"`          //address the secondary listens on, ":647" by default
+	Peer             string `json:"peer"`             //address of the partner, the primary connects to it
+	MCLT             uint32 `json:"mclt"`             //maximum client lead time, seconds
+	Split            *int   `json:"split"`            //hash buckets below split are served by the primary, 0 to 256
+	MaxResponseDelay uint32 `json:"maxResponseDelay"` //seconds of silence before the partner is unreachable
+	AutoPartnerDown  uint32 `json:"autoPartnerDown"`  //seconds unreachable before the partner is assumed down, 0 never
+	Secret           string `json:"secret"`           //shared by the partners, which prove they know it on connect
+}
+
+// ScopeConfig holds the settings of a scope, zero values are inherited from the enclosing scope.
+type ScopeConfig struct {
+	Options       map[string]OptionValue `json:"options"`       //by name or decimal code
+	LeaseTime     uint32                 `json:"leaseTime"`     //option 51, seconds
+	RenewalTime   uint32                 `json:"renewalTime"`   //option 58, half of the lease time by default
+	RebindingTime uint32                 `json:"rebindingTime"` //option 59, 7/8 of the lease time by default
+}
+
+// ClassConfig is a client class, the settings apply to the clients it matches.
+// A class matches either an Expression or, as a shorthand, a prefix of option 60.
+type ClassConfig struct {
+	Name        string `json:"name"`
+	Match       string `json:"match"`       //see Expression
+	VendorClass string `json:"vendorClass"` //prefix of option 60
+	ScopeConfig
+}
+
+type SubnetConfig struct {
+	Subnet     string       `json:"subnet"` //CIDR
+	Pools      []PoolConfig `json:"pools"`
+	Exclusions []string     `json:"exclusions"` //addresses or ranges "start-end"
+	Hosts      []HostConfig `json:"hosts"`
+	ScopeConfig
+}
+
+type PoolConfig struct {
+	Range   string   `json:"range"`   //"start-end"
+	Classes []string `json:"classes"` //names of the classes allowed in the range, any client if empty
+	ScopeConfig
+}
+
+// HostConfig is a client identified by its hardware address or client identifier,
+// with an optional fixed address.
+type HostConfig struct {
+	Name     string `json:"name"`
+	MAC      string `json:"mac"`
+	ClientID string `json:"clientId"` //option 61 in hex, type octet first
+	Address  string `json:"address"`
+	HostName string `json:"hostname"` //sent in option 12
+	ScopeConfig
+}
+
+// ConfigError is an invalid setting located by its line and column in the file
+// and its path in the configuration, like subnets[0].pools[1].range.
+type ConfigError struct {
+	File   string
+	Line   int
+	Column int
+	Path   string
+	Err    error
+}
+
+func (e *ConfigError) Error() string {
+	var buf bytes.Buffer
+	buf.WriteString(e.File)
+	if e.Line > 0 {
+		buf.WriteString(":" + strconv.Itoa(e.Line) + ":" + strconv.Itoa(e.Column))
+	}
+	buf.WriteString(": ")
+	if e.Path != "" {
+		buf.WriteString(e.Path + ": ")
+	}
+	buf.WriteString(e.Err.Error())
+	return buf.String()
+}
+
+// ConfigErrors are all errors found in a configuration.
+type ConfigErrors []*ConfigError
+
+func (errs ConfigErrors) Error() string {
+	lines := make([]string, 0, len(errs))
+	for _, err := range errs {
+		lines = append(lines, err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// LoadConfig reads and validates the configuration file in path.
+func LoadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(path, b)
+}
+
+// ParseConfig parses and validates a configuration, file names it in errors.
+func ParseConfig(file string, b []byte) (*Config, error) {
+	c := &Config{file: file, source: b}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		ce := &ConfigError{File: file, Err: err}
+		offset := dec.InputOffset()
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntaxErr):
+			//the offset is past the offending character
+			if offset = syntaxErr.Offset; offset > 0 {
+				offset--
+			}
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			offset = int64(len(b))
+		case errors.As(err, &typeErr):
+			//the offset is past the value
+			ce.Path, offset = valueBefore(b, typeErr.Offset)
+		case strings.HasPrefix(err.Error(), unknownFieldError):
+			name, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), unknownFieldError))
+			if path, ok := unknownField(b, name); ok {
+				ce.Path, offset = path, positions(b)[path]
+			}
+		}
+		ce.Line, ce.Column = lineColumn(b, offset)
+		return nil, ce
+	}
+
+	if _, err := c.compile(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func lineColumn(b []byte, offset int64) (int, int) {
+	if offset > int64(len(b)) {
+		offset = int64(len(b))
+	}
+	before := b[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	return line, int(offset) - bytes.LastIndexByte(before, '\n')
+}
+
+// positions maps the path of every value in a JSON document to the offset of the value,
+// or of its key in an object.
+func positions(b []byte) map[string]int64 {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	pos := make(map[string]int64)
+	next := func() int64 {
+		offset := dec.InputOffset()
+		for offset < int64(len(b)) && strings.IndexByte(" \t\r\n,:", b[offset]) >= 0 {
+			offset++
+		}
+		return offset
+	}
+
+	var walk func(path string, start int64) bool
+	walk = func(path string, start int64) bool {
+		pos[path] = start
+		token, err := dec.Token()
+		if err != nil {
+			return false
+		}
+		switch token {
+		case json.Delim('{'):
+			for dec.More() {
+				start := next()
+				key, err := dec.Token()
+				if err != nil {
+					return false
+				}
+				name, _ := key.(string)
+				if path != "" {
+					name = path + "." + name
+				}
+				if !walk(name, start) {
+					return false
+				}
+			}
+			_, err = dec.Token()
+		case json.Delim('['):
+			for i := 0; dec.More(); i++ {
+				if !walk(path+"["+strconv.Itoa(i)+"]", next()) {
+					return false
+				}
+			}
+			_, err = dec.Token()
+		}
+		return err == nil
+	}
+	walk("", next())
+	return pos
+}
+
+// unknownFieldError prefixes the error of json.Decoder.DisallowUnknownFields, which has no offset.
+const unknownFieldError = "json: unknown field "
+
+// valueBefore returns the path and offset of the last value starting before end.
+func valueBefore(b []byte, end int64) (string, int64) {
+	var path string
+	var offset int64
+	for p, o := range positions(b) {
+		if o < end && o >= offset && p != "" {
+			path, offset = p, o
+		}
+	}
+	return path, offset
+}
+
+// unknownField returns the path of the first key called name that is no setting of Config.
+func unknownField(b []byte, name string) (string, bool) {
+	var path string
+	offset := int64(-1)
+	for p, o := range positions(b) {
+		if (p == name || strings.HasSuffix(p, "."+name)) && !isSetting(reflect.TypeOf(Config{}), p) && (offset < 0 || o < offset) {
+			path, offset = p, o
+		}
+	}
+	return path, offset >= 0
+}
+
+var jsonUnmarshaler = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// isSetting reports whether path names a value t decodes, values decoded by their own UnmarshalJSON included.
+func isSetting(t reflect.Type, path string) bool {
+	for _, segment := range strings.Split(path, ".") {
+		name, indexes := segment, 0
+		if i := strings.IndexByte(segment, '['); i >= 0 {
+			name, indexes = segment[:i], strings.Count(segment[i:], "[")
+		}
+		if t = decodedType(t); t == nil {
+			return true
+		}
+		switch t.Kind() {
+		case reflect.Map:
+			t = t.Elem()
+		case reflect.Struct:
+			f, ok := jsonField(t, name)
+			if !ok {
+				return false
+			}
+			t = f.Type
+		default:
+			return true
+		}
+		for ; indexes > 0; indexes-- {
+			if t = decodedType(t); t == nil || (t.Kind() != reflect.Slice && t.Kind() != reflect.Array) {
+				return true
+			}
+			t = t.Elem()
+		}
+	}
+	return true
+}
+
+// decodedType returns t without its pointer, nil if it is decoded by its own UnmarshalJSON.
+func decodedType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(jsonUnmarshaler) {
+		return nil
+	}
+	return t
+}
+
+// jsonField returns the field of struct t decoded from the key name, embedded structs included.
+func jsonField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct {
+			if embedded, ok := jsonField(f.Type, name); ok {
+				return embedded, true
+			}
+			continue
+		}
+		if tag == "-" || !f.IsExported() {
+			continue
+		}
+		if tag == "" {
+			tag = f.Name
+		}
+		//encoding/json matches keys case-insensitively
+		if strings.EqualFold(tag, name) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// configErrors collects the errors of a configuration, located in its source.
+type configErrors struct {
+	config    *Config
+	positions map[string]int64
+	errs      ConfigErrors
+}
+
+func (e *configErrors) add(path string, err error) {
+	ce := &ConfigError{File: e.config.file, Path: path, Err: err}
+	if e.config.source != nil {
+		if e.positions == nil {
+			e.positions = positions(e.config.source)
+		}
+		//a setting missing from the file is located at the closest enclosing value
+		for p := path; ; {
+			if offset, ok := e.positions[p]; ok {
+				ce.Line, ce.Column = lineColumn(e.config.source, offset)
+				break
+			}
+			i := strings.LastIndexAny(p, ".[")
+			if i < 0 {
+				break
+			}
+			p = p[:i]
+		}
+	}
+	e.errs = append(e.errs, ce)
+}
+
+func (e *configErrors) addf(path string, format string, a ...interface{}) {
+	e.add(path, fmt.Errorf(format, a...))
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// scope is a compiled ScopeConfig.
+type scope struct {
+	options       optionSet
+	leaseTime     uint32
+	renewalTime   uint32
+	rebindingTime uint32
+}
+
+// merge returns s overridden by the settings of child.
+func (s scope) merge(child scope) scope {
+	merged := scope{options: s.options.merge(child.options), leaseTime: s.leaseTime,
+		renewalTime: s.renewalTime, rebindingTime: s.rebindingTime}
+	if child.leaseTime != 0 {
+		merged.leaseTime = child.leaseTime
+		merged.renewalTime, merged.rebindingTime = 0, 0
+	}
+	if child.renewalTime != 0 {
+		merged.renewalTime = child.renewalTime
+	}
+	if child.rebindingTime != 0 {
+		merged.rebindingTime = child.rebindingTime
+	}
+	return merged
+}
+
+// times returns the lease, renewal and rebinding times of the scope, capping the lease
+// at requested if the client asked for a shorter one.
+func (s scope) times(requested uint32) (uint32, uint32, uint32) {
+	lease := s.leaseTime
+	if lease == 0 {
+		lease = DefaultLeaseTime
+	}
+	if requested != 0 && requested < lease {
+		lease = requested
+	}
+	t1, t2 := s.renewalTime, s.rebindingTime
+	if t1 == 0 || t1 >= lease {
+		t1 = lease / 2
+	}
+	if t2 == 0 || t2 >= lease || t2 <= t1 {
+		t2 = uint32(uint64(lease) * 7 / 8)
+	}
+	if t1 >= t2 {
+		t1 = lease / 2
+	}
+	return lease, t1, t2
+}
+
+func (c *ScopeConfig) compile(path string, errs *configErrors) scope {
+	s := scope{options: make(optionSet), leaseTime: c.LeaseTime, renewalTime: c.RenewalTime, rebindingTime: c.RebindingTime}
+
+	names := make([]string, 0, len(c.Options))
+	for name := range c.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		optionPath := joinPath(path, "options."+name)
+		def, err := lookupOption(name)
+		if err != nil {
+			errs.add(optionPath, err)
+			continue
+		}
+		if reason, ok := serverOptions[def.code]; ok {
+			errs.addf(optionPath, "option %d(%s) is set by the server", def.code, reason)
+			continue
+		}
+		if _, ok := s.options[def.code]; ok {
+			errs.addf(optionPath, "option %d is set twice", def.code)
+			continue
+		}
+		option, err := def.encode(c.Options[name])
+		if err != nil {
+			errs.add(optionPath, err)
+			continue
+		}
+		s.options[def.code] = option
+	}
+
+	if c.RenewalTime != 0 && c.RebindingTime != 0 && c.RenewalTime >= c.RebindingTime {
+		errs.addf(joinPath(path, "renewalTime"), "renewal time %d is not less than rebinding time %d", c.RenewalTime, c.RebindingTime)
+	}
+	if c.LeaseTime != 0 {
+		if c.RebindingTime >= c.LeaseTime {
+			errs.addf(joinPath(path, "rebindingTime"), "rebinding time %d is not less than lease time %d", c.RebindingTime, c.LeaseTime)
+		} else if c.RenewalTime >= c.LeaseTime {
+			errs.addf(joinPath(path, "renewalTime"), "renewal time %d is not less than lease time %d", c.RenewalTime, c.LeaseTime)
+		}
+	}
+	return s
+}
+
+// settings is a compiled configuration.
+type settings struct {
+	config   *Config
+	pool     *Pool
+	global   scope
+	classes  []*class
+	subnets  map[*Subnet]*subnetSettings
+	failover *failoverSettings //nil without a partner
+	ddns     *ddnsSettings     //nil without dynamic DNS
+	limits   *limits           //nil without limits
+	hosts    map[string]*host  //by MACKey and ClientIDKey
+}
+
+type class struct {
+	name  string
+	match *Expression
+	scope
+}
+
+type subnetSettings struct {
+	scope
+	pools []*poolSettings
+}
+
+type poolSettings struct {
+	ipRange
+	scope
+}
+
+type host struct {
+	name     string
+	subnet   *Subnet
+	address  net.IP //nil without a fixed address
+	hostName string
+	scope
+}
+
+// compile validates the configuration and builds the pool and scopes it describes.
+func (c *Config) compile() (*settings, error) {
+	errs := &configErrors{config: c}
+	st := &settings{config: c, pool: NewPool(), subnets: make(map[*Subnet]*subnetSettings), hosts: make(map[string]*host)}
+
+	if len(c.Interfaces) == 0 {
+		errs.addf("interfaces", "no interface to serve")
+	}
+	if c.ServerIdentifier != "" && net.ParseIP(c.ServerIdentifier).To4() == nil {
+		errs.addf("serverIdentifier", "invalid address %q", c.ServerIdentifier)
+	}
+	switch c.LeaseStore.Type {
+	case StoreMemory, "":
+	case StoreJournal, StoreKV:
+		if c.LeaseStore.Path == "" {
+			errs.addf("leaseStore.path", "%s store requires a path", c.LeaseStore.Type)
+		}
+	default:
+		errs.addf("leaseStore.type", "unknown lease store %q, use memory, journal or kv", c.LeaseStore.Type)
+	}
+	if c.Failover != nil {
+		st.failover = c.Failover.compile(errs)
+	}
+	if c.DDNS != nil {
+		st.ddns = c.DDNS.compile(errs)
+	}
+	if c.Limits != nil {
+		st.limits = c.Limits.compile(errs)
+	}
+	if c.API != nil {
+		c.API.compile(errs)
+	}
+	st.global = c.ScopeConfig.compile("", errs)
+
+	classNames := make(map[string]bool)
+	for i, cc := range c.Classes {
+		path := "classes[" + strconv.Itoa(i) + "]"
+		if cc.Name == "" {
+			errs.addf(path, "class has no name")
+		} else if classNames[cc.Name] {
+			errs.addf(joinPath(path, "name"), "class %s is defined twice", cc.Name)
+		}
+		classNames[cc.Name] = true
+		cl := &class{name: cc.Name, scope: cc.ScopeConfig.compile(path, errs)}
+		var err error
+		switch {
+		case cc.Match != "" && cc.VendorClass != "":
+			errs.addf(path, "class %s has both match and vendorClass", cc.Name)
+		case cc.Match != "":
+			if cl.match, err = ParseExpression(cc.Match); err != nil {
+				errs.add(joinPath(path, "match"), err)
+			}
+		case cc.VendorClass != "":
+			cl.match, _ = ParseExpression("vendor-class startswith " + strconv.Quote(cc.VendorClass))
+		default:
+			errs.addf(path, "class %s has no match or vendorClass", cc.Name)
+		}
+		st.classes = append(st.classes, cl)
+	}
+
+	for i := range c.Subnets {
+		c.Subnets[i].compile("subnets["+strconv.Itoa(i)+"]", st, errs)
+	}
+
+	if len(errs.errs) > 0 {
+		return nil, errs.errs
+	}
+	if c.PingCheck {
+		st.pool.Pinger = NewICMPPinger(PingTimeout)
+	}
+	return st, nil
+}
+
+func (fc *FailoverConfig) compile(errs *configErrors) *failoverSettings {
+	fs := &failoverSettings{primary: fc.Role == "primary", address: fc.Address, peer: fc.Peer,
+		mclt: DefaultMCLT * time.Second, split: DefaultSplit, maxResponseDelay: DefaultMaxResponseDelay * time.Second,
+		autoPartnerDown: time.Duration(fc.AutoPartnerDown) * time.Second, secret: fc.Secret}
+	if fc.Role != "primary" && fc.Role != "secondary" {
+		errs.addf("failover.role", "unknown role %q, use primary or secondary", fc.Role)
+	}
+	if fs.address == "" {
+		fs.address = ":" + strconv.Itoa(FailoverPort)
+	}
+	if _, _, err := net.SplitHostPort(fs.address); err != nil {
+		errs.addf("failover.address", "invalid address %q", fc.Address)
+	}
+	if fs.peer == "" {
+		errs.addf("failover", "failover has no peer")
+	} else if _, _, err := net.SplitHostPort(fs.peer); err != nil {
+		fs.peer = net.JoinHostPort(fs.peer, strconv.Itoa(FailoverPort))
+	}
+	if fc.MCLT != 0 {
+		fs.mclt = time.Duration(fc.MCLT) * time.Second
+	}
+	if fc.Split != nil {
+		if fs.split = *fc.Split; fs.split < 0 || fs.split > 256 {
+			errs.addf("failover.split", "split %d is not between 0 and 256", fs.split)
+		}
+	}
+	if fc.MaxResponseDelay != 0 {
+		fs.maxResponseDelay = time.Duration(fc.MaxResponseDelay) * time.Second
+	}
+	return fs
+}
+
+func (sc *SubnetConfig) compile(path string, st *settings, errs *configErrors) {
+	subnet, err := st.pool.AddSubnet(sc.Subnet)
+	if err != nil {
+		errs.add(joinPath(path, "subnet"), err)
+		return
+	}
+	ss := &subnetSettings{scope: sc.ScopeConfig.compile(path, errs)}
+	st.subnets[subnet] = ss
+
+	for i, e := range sc.Exclusions {
+		start, end := splitRange(e)
+		if err := subnet.AddExclusion(start, end); err != nil {
+			errs.add(path+".exclusions["+strconv.Itoa(i)+"]", err)
+		}
+	}
+
+	for i, pc := range sc.Pools {
+		poolPath := path + ".pools[" + strconv.Itoa(i) + "]"
+		start, end := splitRange(pc.Range)
+		if start == "" || end == "" {
+			errs.addf(joinPath(poolPath, "range"), "range %q is not \"start-end\"", pc.Range)
+			continue
+		}
+		for j, name := range pc.Classes {
+			if st.class(name) == nil {
+				errs.addf(poolPath+".classes["+strconv.Itoa(j)+"]", "unknown class %s", name)
+			}
+		}
+		if err := subnet.AddRange(start, end, pc.Classes...); err != nil {
+			errs.add(joinPath(poolPath, "range"), err)
+			continue
+		}
+		r, _ := subnet.parseRange(start, end)
+		ss.pools = append(ss.pools, &poolSettings{ipRange: r, scope: pc.ScopeConfig.compile(poolPath, errs)})
+	}
+
+	for i, hc := range sc.Hosts {
+		hc.compile(path+".hosts["+strconv.Itoa(i)+"]", subnet, st, errs)
+	}
+}
+
+// splitRange splits "start-end", a single address is a range of one.
+func splitRange(s string) (string, string) {
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+	}
+	s = strings.TrimSpace(s)
+	return s, s
+}
+
+// compile adds the host to st and reserves its address, the subnet is left unchanged if the host is invalid.
+func (hc *HostConfig) compile(path string, subnet *Subnet, st *settings, errs *configErrors) {
+	failed := len(errs.errs)
+	h := &host{name: hc.Name, subnet: subnet, hostName: hc.HostName, scope: hc.ScopeConfig.compile(path, errs)}
+
+	var keys []string
+	if hc.MAC != "" {
+		mac, err := net.ParseMAC(hc.MAC)
+		if err != nil {
+			errs.addf(joinPath(path, "mac"), "invalid hardware address %q", hc.MAC)
+		} else {
+			keys = append(keys, MACKey(mac))
+		}
+	}
+	if hc.ClientID != "" {
+		id, err := hex.DecodeString(strings.ReplaceAll(hc.ClientID, ":", ""))
+		if err != nil || len(id) < 2 {
+			errs.addf(joinPath(path, "clientId"), "invalid client identifier %q", hc.ClientID)
+		} else {
+			keys = append(keys, ClientIDKey(id))
+		}
+	}
+	if hc.MAC == "" && hc.ClientID == "" {
+		errs.addf(path, "host has neither mac nor clientId")
+	}
+	if len(hc.HostName) > 255 {
+		errs.addf(joinPath(path, "hostname"), "host name longer than 255 octets")
+	}
+
+	for _, key := range keys {
+		if other, ok := st.hosts[key]; ok {
+			errs.addf(path, "%s is also host %s in subnet %s", key, other.name, other.subnet.Network)
+			continue
+		}
+		st.hosts[key] = h
+	}
+
+	if hc.Address == "" {
+		return
+	}
+	if h.address = net.ParseIP(hc.Address).To4(); h.address == nil {
+		errs.addf(joinPath(path, "address"), "invalid address %q", hc.Address)
+		return
+	}
+	//a key of another host would move its reservation
+	if len(errs.errs) > failed {
+		return
+	}
+	//the client identifier takes precedence when a client sends one, so it owns the reservation
+	if len(keys) > 0 {
+		if err := subnet.AddReservation(keys[len(keys)-1], h.address); err != nil {
+			errs.add(joinPath(path, "address"), err)
+		}
+	}
+}
+
+// classesOf returns the classes matching m, in the order they are configured.
+func (st *settings) classesOf(m *dhcp4.Message) []*class {
+	var classes []*class
+	for _, c := range st.classes {
+		if c.match != nil && c.match.Match(m) {
+			classes = append(classes, c)
+		}
+	}
+	return classes
+}
+
+// class returns the class called name.
+func (st *settings) class(name string) *class {
+	for _, c := range st.classes {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// host returns the host configured for the client of r.
+func (st *settings) host(r Request) *host {
+	if len(r.ClientID) > 0 {
+		if h, ok := st.hosts[ClientIDKey(r.ClientID)]; ok {
+			return h
+		}
+	}
+	return st.hosts[MACKey(r.MAC)]
+}
+
+// scope returns the settings for a client of classes given ip in subnet, in order of precedence
+// from lowest: global, classes, subnet, the pool containing ip and the host.
+func (st *settings) scope(subnet *Subnet, ip net.IP, classes []*class, h *host) scope {
+	s := st.global
+	for _, c := range classes {
+		s = s.merge(c.scope)
+	}
+	if ss, ok := st.subnets[subnet]; ok {
+		s = s.merge(ss.scope)
+		if ip != nil {
+			v := ipToUint32(ip)
+			for _, p := range ss.pools {
+				if p.contains(v) {
+					s = s.merge(p.scope)
+					break
+				}
+			}
+		}
+	}
+	if h != nil && h.subnet == subnet {
+		s = s.merge(h.scope)
+	}
+	return s
+}
@@ -0,0 +1,107 @@
+package server4
+
+import (
+	"github.com/Kseleven/agile-dhcp/dhcp4"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{"syntax", `{
+  "interfaces": ["lo"],
+  "leaseTime": 3600,
+}`, `dhcp.json:4:1: invalid character '}' looking for beginning of object key string`},
+		{"truncated", `{"interfaces": ["lo"],`, `dhcp.json:1:23: unexpected EOF`},
+		{"type", `{
+  "interfaces": ["lo"],
+  "leaseTime": "long"
+}`, `dhcp.json:3:3: leaseTime: json: cannot unmarshal string into Go struct field Config.leaseTime of type uint32`},
+		{"nested type", `{"interfaces": ["lo"], "subnets": [{"subnet": 5}]}`,
+			`dhcp.json:1:37: subnets[0].subnet: json: cannot unmarshal number into Go struct field Config.subnets.0.subnet of type string`},
+		{"unknown field", `{
+  "interfaces": ["lo"],
+  "color": 1
+}`, `dhcp.json:3:3: color: json: unknown field "color"`},
+		//name is a setting of hosts, not of subnets
+		{"misplaced field", `{"interfaces": ["lo"], "subnets": [{"subnet": "127.0.0.0/24",
+  "hosts": [{"name": "a", "mac": "00:00:00:00:00:01"}], "name": "x"}]}`,
+			`dhcp.json:2:57: subnets[0].name: json: unknown field "name"`},
+		{"semantic", `{
+  "interfaces": ["lo"],
+  "subnets": [
+    {"subnet": "127.0.0.0/24",
+     "pools": [{"range": "127.0.0.100-127.0.1.150"}]}
+  ]
+}`, `dhcp.json:5:17: subnets[0].pools[0].range: "127.0.1.150" is not an address of subnet 127.0.0.0/24`},
+		{"semantic errors", `{
+  "interfaces": ["lo"],
+  "subnets": [
+    {"subnet": "127.0.0.0/24", "pools": [{"range": "127.0.0.100-127.0.0.150", "classes": ["x"]}],
+     "hosts": [{"name": "a", "mac": "zz"}]}
+  ]
+}`, `dhcp.json:4:91: subnets[0].pools[0].classes[0]: unknown class x
+dhcp.json:5:30: subnets[0].hosts[0].mac: invalid hardware address "zz"`},
+		{"expression", `{
+  "interfaces": ["lo"],
+  "classes": [{"name": "pxe", "match": "vendor-class =="}]
+}`, `dhcp.json:3:31: classes[0].match: column 16: expected a value, found end of expression`},
+	} {
+		_, err := ParseConfig("dhcp.json", []byte(tc.src))
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("%s: got error\n%v\nwant\n%s", tc.name, err, tc.err)
+		}
+	}
+}
+
+// TestReload checks that the leases of the store keep their addresses in the pools of a new configuration.
+func TestReload(t *testing.T) {
+	config := func(pool string) *Config {
+		c, err := ParseConfig("dhcp.json", []byte(`{"interfaces": ["lo"], "leaseTime": 3600,
+	"subnets": [{"subnet": "127.0.0.0/24", "pools": [{"range": "`+pool+`"}]}]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	s, err := NewServer(config("127.0.0.100-127.0.0.150"), NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	offer := handle(t, s, dhcp4.GenDiscoverMessage("00:00:00:00:05:01"))
+	ack := handle(t, s, dhcp4.GenRequestMessage(offer))
+	if ack.MessageType != dhcp4.MessageTypeAck {
+		t.Fatalf("got %s to the request", ack.MessageType)
+	}
+	ip := net.IP(ack.YourIP)
+
+	//the new pool starts at the leased address
+	if err := s.Reload(config(ip.String() + "-127.0.0.200")); err != nil {
+		t.Fatal(err)
+	}
+	mac, _ := net.ParseMAC("00:00:00:00:05:01")
+	if owner, ok := s.settings.pool.Subnet(ip).Owner(ip); !ok || owner != MACKey(mac) {
+		t.Errorf("%s allocated to %q after the reload", ip, owner)
+	}
+	if offer := handle(t, s, dhcp4.GenDiscoverMessage("00:00:00:00:05:02")); net.IP(offer.YourIP).Equal(ip) {
+		t.Errorf("leased address %s offered to another client", ip)
+	}
+	renewal := handle(t, s, dhcp4.GenRenewMessage(mac.String(), ip.To4()))
+	if renewal.MessageType != dhcp4.MessageTypeAck || !net.IP(renewal.YourIP).Equal(ip) {
+		t.Errorf("got %s of %s to the renewal of %s", renewal.MessageType, net.IP(renewal.YourIP), ip)
+	}
+
+	//an invalid configuration is rejected and the current one kept
+	if err := s.Reload(&Config{Interfaces: []string{"lo"}, Subnets: []SubnetConfig{{Subnet: "bad"}}}); err == nil ||
+		!strings.Contains(err.Error(), "invalid subnet") {
+		t.Errorf("got %v reloading an invalid configuration", err)
+	}
+	if owner, ok := s.settings.pool.Subnet(ip).Owner(ip); !ok || owner != MACKey(mac) {
+		t.Errorf("%s allocated to %q after a failed reload", ip, owner)
+	}
+}
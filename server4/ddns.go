@@ -0,0 +1,300 @@
+package server4
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/Kseleven/agile-dhcp/ddns"
+	"github.com/Kseleven/agile-dhcp/dhcp4"
+	"net"
+	"strings"
+	"time"
+)
+
+const DNSQueueSize = 1024 //updates waiting to be sent, more are dropped
+
+// DDNSConfig makes the server register the names of its clients in DNS (RFC 2136): an A record
+// of the name and a PTR record of the address, owned by the client through a DHCID record (RFC 4703).
+// The name is the host name of the reservation, the client FQDN (option 81) or host name (option 12).
+type DDNSConfig struct {
+	Server       string      `json:"server"`       //DNS server taking the updates, "host[:port]"
+	ForwardZone  string      `json:"forwardZone"`  //zone of the A records, names outside it get none
+	ReverseZones []string    `json:"reverseZones"` //zones of the PTR records, the longest one containing the address is updated
+	Domain       string      `json:"domain"`       //completes names without a domain, forwardZone by default
+	TTL          uint32      `json:"ttl"`          //of the records, a third of the lease time by default
+	Override     bool        `json:"override"`     //update the A record even when the client asks to (option 81 without S)
+	TSIG         *TSIGConfig `json:"tsig"`
+}
+
+type TSIGConfig struct {
+	Name      string `json:"name"`
+	Algorithm string `json:"algorithm"` //hmac-md5, hmac-sha1, hmac-sha256 or hmac-sha512, hmac-sha256 by default
+	Secret    string `json:"secret"`    //base64
+}
+
+// ddnsSettings is a compiled DDNSConfig.
+type ddnsSettings struct {
+	client       *ddns.Client
+	forwardZone  string
+	reverseZones []string
+	domain       string
+	ttl          uint32
+	override     bool
+}
+
+func (dc *DDNSConfig) compile(errs *configErrors) *ddnsSettings {
+	d := &ddnsSettings{client: &ddns.Client{Server: dc.Server, Retries: 2}, forwardZone: fqdn(dc.ForwardZone),
+		domain: fqdn(dc.Domain), ttl: dc.TTL, override: dc.Override}
+	if dc.Server == "" {
+		errs.addf("ddns.server", "no DNS server to update")
+	}
+	if dc.ForwardZone == "" && len(dc.ReverseZones) == 0 {
+		errs.addf("ddns", "no forwardZone or reverseZones to update")
+	}
+	if d.domain == "" {
+		d.domain = d.forwardZone
+	}
+	for i, zone := range dc.ReverseZones {
+		if !ddns.InZone(zone, "in-addr.arpa") {
+			errs.addf(fmt.Sprintf("ddns.reverseZones[%d]", i), "%s is not an in-addr.arpa zone", zone)
+		}
+		d.reverseZones = append(d.reverseZones, fqdn(zone))
+	}
+
+	if tc := dc.TSIG; tc != nil {
+		key := &ddns.Key{Name: fqdn(tc.Name), Algorithm: tc.Algorithm}
+		if key.Algorithm == "" {
+			key.Algorithm = "hmac-sha256"
+		}
+		if tc.Name == "" {
+			errs.addf("ddns.tsig", "TSIG key has no name")
+		}
+		if !ddns.ValidAlgorithm(key.Algorithm) {
+			errs.addf("ddns.tsig.algorithm", "unknown algorithm %q, use hmac-md5, hmac-sha1, hmac-sha256 or hmac-sha512", tc.Algorithm)
+		}
+		secret, err := base64.StdEncoding.DecodeString(tc.Secret)
+		if err != nil || len(secret) == 0 {
+			errs.addf("ddns.tsig.secret", "secret is not base64")
+		}
+		key.Secret = secret
+		d.client.Key = key
+	}
+	return d
+}
+
+// fqdn returns name with the root label, lowercase.
+func fqdn(name string) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name == "" {
+		return ""
+	}
+	return name + "."
+}
+
+// hostLabels makes a client supplied name a valid host name: letters, digits and hyphens in
+// labels neither starting nor ending with a hyphen (RFC 952, RFC 1123).
+func hostLabels(name string) string {
+	var labels []string
+	for _, label := range strings.Split(strings.ToLower(strings.TrimSuffix(name, ".")), ".") {
+		label = strings.Trim(strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' {
+				return r
+			}
+			return '-'
+		}, label), "-")
+		if len(label) > 63 {
+			label = label[:63]
+		}
+		if label != "" {
+			labels = append(labels, label)
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+// clientName returns the name to register for the client of t, empty when none is, whether the
+// server updates its A record, and the client FQDN option of the reply when the client sent one
+// (RFC 4702 section 4).
+func (t *transaction) clientName(d *ddnsSettings) (string, bool, dhcp4.OptionInter) {
+	option81, has81 := t.m.Option(81).(dhcp4.Option81)
+	var name string
+	switch {
+	case t.host != nil && t.host.hostName != "":
+		name = t.host.hostName
+	case has81 && option81.DomainName != "":
+		name = option81.DomainName
+	default:
+		if option12, ok := t.m.Option(12).(dhcp4.Option12); ok {
+			name = string(option12.HostName)
+		}
+	}
+
+	complete := strings.HasSuffix(name, ".")
+	if name = hostLabels(name); name != "" {
+		if complete || strings.Contains(name, ".") {
+			name = fqdn(name)
+		} else if d.domain != "" {
+			name = name + "." + d.domain
+		} else {
+			name = ""
+		}
+	}
+
+	forward := !has81 || option81.Flags&dhcp4.FQDNFlagS != 0 || d.override
+	if !has81 {
+		return name, forward, nil
+	}
+	flags := dhcp4.FQDNFlagE
+	switch {
+	case option81.Flags&dhcp4.FQDNFlagN != 0:
+		//the client asked for no updates at all
+		name, forward = "", false
+		flags |= dhcp4.FQDNFlagN
+	case forward:
+		flags |= dhcp4.FQDNFlagS
+		if option81.Flags&dhcp4.FQDNFlagS == 0 {
+			flags |= dhcp4.FQDNFlagO
+		}
+	}
+	reply, err := dhcp4.GenOption81(name, flags)
+	if err != nil {
+		return name, forward, nil
+	}
+	reply.RCode1, reply.RCode2 = 255, 255
+	return name, forward, reply
+}
+
+// dhcid returns the DHCID of the client of l on its name (RFC 4701 section 3.3): by the DUID of
+// an RFC 4361 client identifier, by its client identifier, or by its hardware address without one.
+func dhcid(l *Lease) ([]byte, error) {
+	if strings.HasPrefix(l.Client, "id:") {
+		id, err := hex.DecodeString(strings.TrimPrefix(l.Client, "id:"))
+		if err != nil {
+			return nil, err
+		}
+		if len(id) > 5 && id[0] == 255 {
+			//type 255, the IAID and the DUID (RFC 4361 section 6.1)
+			return ddns.DHCID(ddns.IdentifierDUID, id[5:], l.FQDN)
+		}
+		return ddns.DHCID(ddns.IdentifierClientID, id, l.FQDN)
+	}
+	mac, err := net.ParseMAC(strings.TrimPrefix(l.Client, "mac:"))
+	if err != nil {
+		return nil, err
+	}
+	htype := l.HType
+	if htype == 0 {
+		htype = 1
+	}
+	return ddns.DHCID(ddns.IdentifierHardware, append([]byte{htype}, mac...), l.FQDN)
+}
+
+func (d *ddnsSettings) recordTTL(l *Lease) uint32 {
+	if d.ttl != 0 {
+		return d.ttl
+	}
+	if l.Expiry.IsZero() {
+		return DefaultLeaseTime / 3
+	}
+	if ttl := uint32(l.Expiry.Sub(l.Start)/time.Second) / 3; ttl > 0 {
+		return ttl
+	}
+	return 1
+}
+
+// reverseZone returns the longest reverse zone containing ip, empty if there is none.
+func (d *ddnsSettings) reverseZone(ip net.IP) string {
+	name := ddns.ReverseName(ip)
+	var zone string
+	for _, z := range d.reverseZones {
+		if ddns.InZone(name, z) && len(z) > len(zone) {
+			zone = z
+		}
+	}
+	return zone
+}
+
+// register adds the records of the name of lease l, the A record only if forward.
+func (d *ddnsSettings) register(l *Lease, forward bool) {
+	id, err := dhcid(l)
+	if err != nil {
+		fmt.Printf("dhcid of %s failed:%s\n", l.Client, err.Error())
+		return
+	}
+	ttl := d.recordTTL(l)
+	if forward && d.forwardZone != "" && ddns.InZone(l.FQDN, d.forwardZone) {
+		if err := d.client.AddForward(d.forwardZone, l.FQDN, l.IP, id, ttl); err != nil {
+			fmt.Printf("add A record %s %s failed:%s\n", l.FQDN, l.IP, err.Error())
+			//the address must not point to a name the client does not own
+			return
+		}
+		fmt.Printf("added A record %s %s\n", l.FQDN, l.IP)
+	}
+	if zone := d.reverseZone(l.IP); zone != "" {
+		if err := d.client.AddReverse(zone, l.IP, l.FQDN, id, ttl); err != nil {
+			fmt.Printf("add PTR record %s %s failed:%s\n", ddns.ReverseName(l.IP), l.FQDN, err.Error())
+			return
+		}
+		fmt.Printf("added PTR record %s %s\n", ddns.ReverseName(l.IP), l.FQDN)
+	}
+}
+
+// unregister removes the records of the name of lease l.
+func (d *ddnsSettings) unregister(l *Lease) {
+	if d.forwardZone != "" && ddns.InZone(l.FQDN, d.forwardZone) {
+		id, err := dhcid(l)
+		if err == nil {
+			err = d.client.RemoveForward(d.forwardZone, l.FQDN, l.IP, id)
+		}
+		if err != nil && err != ddns.ErrConflict {
+			fmt.Printf("remove A record %s %s failed:%s\n", l.FQDN, l.IP, err.Error())
+		}
+	}
+	if zone := d.reverseZone(l.IP); zone != "" {
+		if err := d.client.RemoveReverse(zone, l.IP); err != nil {
+			fmt.Printf("remove PTR record %s failed:%s\n", ddns.ReverseName(l.IP), err.Error())
+		}
+	}
+}
+
+// updateDNS registers the name of lease, unless previous, the lease it replaces, registered it already.
+func (s *Server) updateDNS(d *ddnsSettings, previous, lease *Lease, forward bool) {
+	if previous != nil && previous.FQDN == lease.FQDN && previous.Client == lease.Client {
+		return
+	}
+	s.queueDNS(func() {
+		if previous != nil && previous.FQDN != "" {
+			d.unregister(previous)
+		}
+		if lease.FQDN != "" {
+			d.register(lease, forward)
+		}
+	})
+}
+
+// removeDNS removes the records of the name of lease l.
+func (s *Server) removeDNS(d *ddnsSettings, l *Lease) {
+	if d != nil && l.FQDN != "" {
+		s.queueDNS(func() { d.unregister(l) })
+	}
+}
+
+// queueDNS runs update in the background, updates are sent one at a time in order.
+func (s *Server) queueDNS(update func()) {
+	select {
+	case s.dnsQueue <- update:
+	default:
+		fmt.Println("dns update queue is full, update dropped")
+	}
+}
+
+func (s *Server) sendDNSUpdates() {
+	for {
+		select {
+		case <-s.stop:
+			return
+		case update := <-s.dnsQueue:
+			update()
+		}
+	}
+}
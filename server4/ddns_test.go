@@ -0,0 +1,34 @@
+package server4
+
+import (
+	"bytes"
+	"github.com/Kseleven/agile-dhcp/ddns"
+	"testing"
+)
+
+func TestDHCID(t *testing.T) {
+	fqdn := "host.example.org"
+	duid := []byte{0, 1, 0, 1, 0x2a, 0x2b, 0x2c, 0x2d, 0, 0x11, 0x22, 0x33, 0x44, 0x55}
+	cases := []struct {
+		lease          *Lease
+		identifierType uint16
+		identifier     []byte
+	}{
+		{&Lease{Client: "mac:00:11:22:33:44:55"}, ddns.IdentifierHardware, []byte{1, 0, 0x11, 0x22, 0x33, 0x44, 0x55}},
+		{&Lease{Client: "mac:00:11:22:33:44:55", HType: 6}, ddns.IdentifierHardware, []byte{6, 0, 0x11, 0x22, 0x33, 0x44, 0x55}},
+		{&Lease{Client: "id:01001122334455"}, ddns.IdentifierClientID, []byte{1, 0, 0x11, 0x22, 0x33, 0x44, 0x55}},
+		//type 255 and IAID 1 before the DUID
+		{&Lease{Client: ClientIDKey(append([]byte{255, 0, 0, 0, 1}, duid...))}, ddns.IdentifierDUID, duid},
+	}
+	for _, tc := range cases {
+		tc.lease.FQDN = fqdn
+		got, err := dhcid(tc.lease)
+		if err != nil {
+			t.Fatalf("%s:%s", tc.lease.Client, err.Error())
+		}
+		want, _ := ddns.DHCID(tc.identifierType, tc.identifier, fqdn)
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: got %x, want %x", tc.lease.Client, got, want)
+		}
+	}
+}
@@ -0,0 +1,618 @@
+package server4
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/Kseleven/agile-dhcp/dhcp4"
+	"net"
+	"sync"
+	"time"
+)
+
+// Failover pairs two servers sharing their leases over TCP, a simplified draft-ietf-dhc-failover-12:
+// the primary connects to the secondary, each server sends the leases it grants to its partner,
+// which acknowledges them. Leases are granted for at most the maximum client lead time(MCLT)
+// beyond the expiry the partner acknowledged, so the partner can take over the clients safely.
+//
+// In the normal state clients are balanced between the servers by the hash of their client
+// identifier or hardware address(RFC 3074): DHCPDISCOVER and DHCPREQUEST in INIT-REBOOT are
+// answered by the server the hash bucket belongs to, renewing and rebinding clients by either.
+// When the partner is unreachable every client is answered. Each server allocates the alternate
+// free addresses of its share, in partner-down it allocates all of them once the MCLT has passed.
+const (
+	FailoverPort            = 647
+	DefaultMCLT             = 3600 //seconds
+	DefaultSplit            = 128
+	DefaultMaxResponseDelay = 60 //seconds
+
+	FailoverRetry = 5 * time.Second //between connection attempts of the primary
+)
+
+// Failover states.
+const (
+	FailoverNormal      = "normal"
+	FailoverInterrupted = "communications-interrupted"
+	FailoverPartnerDown = "partner-down"
+)
+
+// failover messages, one JSON object per line.
+const (
+	failoverConnect   = "connect"   //first message of both servers
+	failoverAuth      = "auth"      //answers the nonce of connect with the shared secret
+	failoverUpdate    = "update"    //a lease granted, acknowledged with ack
+	failoverAck       = "ack"       //the lease of an update was stored
+	failoverDelete    = "delete"    //a lease released or declined
+	failoverDone      = "done"      //all leases were sent after connect
+	failoverHeartbeat = "heartbeat" //sent when idle, the partner is unreachable after maxResponseDelay of silence
+)
+
+type failoverMessage struct {
+	Type  string `json:"type"`
+	Role  string `json:"role,omitempty"`
+	MCLT  uint32 `json:"mclt,omitempty"`
+	Split int    `json:"split,omitempty"`
+	Nonce string `json:"nonce,omitempty"` //of connect, in hex
+	MAC   string `json:"mac,omitempty"`   //of auth, HMAC-SHA256 of the role and the nonce of the partner in hex
+	Lease *Lease `json:"lease,omitempty"`
+}
+
+// foreverExpiry is the expiry the partner acknowledged for an infinite lease.
+var foreverExpiry = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// loadBalanceTable is the mixing table of the RFC 3074 hash.
+var loadBalanceTable = [256]uint8{
+	251, 175, 119, 215, 81, 14, 79, 191, 103, 49, 181, 143, 186, 157, 0,
+	232, 31, 32, 55, 60, 152, 58, 17, 237, 174, 70, 160, 144, 220, 90, 57,
+	223, 59, 3, 18, 140, 111, 166, 203, 196, 134, 243, 124, 95, 222, 179,
+	197, 65, 180, 48, 36, 15, 107, 46, 233, 130, 165, 30, 123, 161, 209, 23,
+	97, 16, 40, 91, 219, 61, 100, 10, 210, 109, 250, 127, 22, 138, 29, 108,
+	244, 67, 207, 9, 178, 204, 74, 98, 126, 249, 167, 116, 34, 77, 193,
+	200, 121, 5, 20, 113, 71, 35, 128, 13, 182, 94, 25, 226, 227, 199, 75,
+	27, 41, 245, 230, 224, 43, 225, 177, 26, 155, 150, 212, 142, 218, 115,
+	241, 73, 88, 105, 39, 114, 62, 255, 192, 201, 145, 214, 168, 158, 221,
+	148, 154, 122, 12, 84, 82, 163, 44, 139, 228, 236, 205, 242, 217, 11,
+	187, 146, 159, 64, 86, 239, 195, 42, 106, 198, 118, 112, 184, 172, 87,
+	2, 173, 117, 176, 229, 247, 253, 137, 185, 99, 164, 102, 147, 45, 66,
+	231, 52, 141, 211, 194, 206, 246, 238, 56, 110, 78, 248, 63, 240, 189,
+	93, 92, 51, 53, 183, 19, 171, 72, 50, 33, 104, 101, 69, 8, 252, 83, 120,
+	76, 135, 85, 54, 202, 125, 188, 213, 96, 235, 136, 208, 162, 129, 190,
+	132, 156, 38, 47, 1, 7, 254, 24, 4, 216, 131, 89, 21, 28, 133, 37, 153,
+	149, 80, 170, 68, 6, 169, 234, 151,
+}
+
+// LoadBalanceHash returns the hash bucket of the client of r(RFC 3074 section 6): the hash of
+// its client identifier(option 61), or of its hardware address without one.
+func LoadBalanceHash(r Request) uint8 {
+	key := []byte(r.ClientID)
+	if len(key) == 0 {
+		key = r.MAC
+	}
+	hash := uint8(len(key))
+	for i := len(key) - 1; i >= 0; i-- {
+		hash = loadBalanceTable[hash^key[i]]
+	}
+	return hash
+}
+
+// failoverSettings is a compiled FailoverConfig.
+type failoverSettings struct {
+	primary          bool
+	address          string //listen address of the secondary
+	peer             string
+	mclt             time.Duration
+	split            int
+	maxResponseDelay time.Duration
+	autoPartnerDown  time.Duration
+	secret           string //empty without authentication
+}
+
+func (fs failoverSettings) role() string {
+	if fs.primary {
+		return "primary"
+	}
+	return "secondary"
+}
+
+// failover runs the failover protocol of a server with its partner.
+type failover struct {
+	server   *Server
+	settings failoverSettings
+
+	mu          sync.Mutex
+	state       string
+	interrupted time.Time //when the partner became unreachable
+	conn        net.Conn
+	queue       *failoverQueue //of conn
+	listener    net.Listener
+	stop        chan bool
+	wg          sync.WaitGroup
+}
+
+func newFailover(s *Server, fs failoverSettings) *failover {
+	return &failover{server: s, settings: fs, state: FailoverInterrupted, interrupted: time.Now(), stop: make(chan bool)}
+}
+
+// start connects to the partner, or listens for it on the secondary.
+func (f *failover) start() error {
+	if f.settings.primary {
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			f.dial()
+		}()
+	} else {
+		listener, err := net.Listen("tcp", f.settings.address)
+		if err != nil {
+			return fmt.Errorf("failover listen on %s failed:%s", f.settings.address, err.Error())
+		}
+		f.listener = listener
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			f.accept()
+		}()
+	}
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		f.watch()
+	}()
+	return nil
+}
+
+func (f *failover) close() {
+	close(f.stop)
+	f.mu.Lock()
+	if f.listener != nil {
+		f.listener.Close()
+	}
+	if f.conn != nil {
+		f.conn.Close()
+	}
+	f.mu.Unlock()
+	f.wg.Wait()
+}
+
+func (f *failover) stopped() bool {
+	select {
+	case <-f.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (f *failover) dial() {
+	for !f.stopped() {
+		conn, err := net.DialTimeout("tcp", f.settings.peer, FailoverRetry)
+		if err != nil {
+			fmt.Printf("connect to failover partner %s failed:%s\n", f.settings.peer, err.Error())
+		} else {
+			f.run(conn)
+		}
+
+		select {
+		case <-f.stop:
+		case <-time.After(FailoverRetry):
+		}
+	}
+}
+
+// accept serves the connections of the partner, a new connection replaces the previous one.
+func (f *failover) accept() {
+	peerHost, _, _ := net.SplitHostPort(f.settings.peer)
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			if f.stopped() {
+				return
+			}
+			fmt.Printf("failover accept failed:%s\n", err.Error())
+			time.Sleep(FailoverRetry)
+			continue
+		}
+
+		if !isPeer(conn.RemoteAddr(), peerHost) {
+			fmt.Printf("failover connection from %s refused, the partner is %s\n", conn.RemoteAddr(), f.settings.peer)
+			conn.Close()
+			continue
+		}
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			f.run(conn)
+		}()
+	}
+}
+
+// isPeer reports whether addr is an address of the partner host, a name is resolved on each connection.
+func isPeer(addr net.Addr, peerHost string) bool {
+	host, _, _ := net.SplitHostPort(addr.String())
+	remote := net.ParseIP(host)
+	peers := []net.IP{net.ParseIP(peerHost)}
+	if peers[0] == nil {
+		ips, err := net.LookupIP(peerHost)
+		if err != nil {
+			fmt.Printf("resolve failover partner %s failed:%s\n", peerHost, err.Error())
+			return false
+		}
+		peers = ips
+	}
+	for _, ip := range peers {
+		if ip.Equal(remote) {
+			return true
+		}
+	}
+	return false
+}
+
+// watch moves to partner-down when the partner is unreachable for autoPartnerDown.
+func (f *failover) watch() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stop:
+			return
+		case now := <-ticker.C:
+			f.mu.Lock()
+			if f.state == FailoverInterrupted && f.settings.autoPartnerDown > 0 &&
+				now.Sub(f.interrupted) >= f.settings.autoPartnerDown {
+				f.setState(FailoverPartnerDown)
+			}
+			f.mu.Unlock()
+		}
+	}
+}
+
+// setState changes the state, f.mu is held.
+func (f *failover) setState(state string) {
+	if f.state == state {
+		return
+	}
+	fmt.Printf("failover state %s -> %s\n", f.state, state)
+	if f.state == FailoverNormal {
+		f.interrupted = time.Now()
+	}
+	f.state = state
+}
+
+// run exchanges messages with the partner on conn until it is closed or silent for maxResponseDelay.
+func (f *failover) run(conn net.Conn) {
+	defer conn.Close()
+	dec := json.NewDecoder(conn)
+	conn.SetDeadline(time.Now().Add(f.settings.maxResponseDelay))
+	hello := &failoverMessage{Type: failoverConnect, Role: f.settings.role(),
+		MCLT: uint32(f.settings.mclt / time.Second), Split: f.settings.split}
+	if f.settings.secret != "" {
+		nonce := make([]byte, 16)
+		if _, err := rand.Read(nonce); err != nil {
+			fmt.Printf("failover nonce failed:%s\n", err.Error())
+			return
+		}
+		hello.Nonce = hex.EncodeToString(nonce)
+	}
+	if err := json.NewEncoder(conn).Encode(hello); err != nil {
+		fmt.Printf("failover connect to %s failed:%s\n", conn.RemoteAddr(), err.Error())
+		return
+	}
+	var partner failoverMessage
+	if err := dec.Decode(&partner); err != nil {
+		fmt.Printf("failover connect from %s failed:%s\n", conn.RemoteAddr(), err.Error())
+		return
+	}
+	if partner.Type != failoverConnect || partner.Role == hello.Role || partner.MCLT != hello.MCLT || partner.Split != hello.Split {
+		fmt.Printf("failover partner %s refused: it is %s with mclt %d and split %d, expected the partner of %s with mclt %d and split %d\n",
+			conn.RemoteAddr(), partner.Role, partner.MCLT, partner.Split, hello.Role, hello.MCLT, hello.Split)
+		return
+	}
+	if f.settings.secret != "" && !f.authenticate(conn, dec, hello, &partner) {
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	queue := newFailoverQueue()
+	done := make(chan bool)
+	f.mu.Lock()
+	if f.stopped() {
+		f.mu.Unlock()
+		return
+	}
+	if f.conn != nil {
+		f.conn.Close()
+	}
+	f.conn, f.queue = conn, queue
+	f.mu.Unlock()
+	fmt.Printf("failover partner %s connected\n", conn.RemoteAddr())
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		f.write(conn, queue, done)
+	}()
+	if leases, err := f.server.Store.Leases(); err != nil {
+		fmt.Printf("failover send leases failed:%s\n", err.Error())
+		conn.Close()
+	} else {
+		for _, l := range leases {
+			queue.push(&failoverMessage{Type: failoverUpdate, Lease: l})
+		}
+		queue.push(&failoverMessage{Type: failoverDone})
+	}
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(f.settings.maxResponseDelay))
+		var m failoverMessage
+		if err := dec.Decode(&m); err != nil {
+			fmt.Printf("failover partner %s lost:%s\n", conn.RemoteAddr(), err.Error())
+			break
+		}
+		f.handle(&m, queue)
+	}
+	close(done)
+	conn.Close()
+	wg.Wait()
+
+	f.mu.Lock()
+	if f.conn == conn {
+		f.conn, f.queue = nil, nil
+		if f.state == FailoverNormal {
+			f.setState(FailoverInterrupted)
+		}
+	}
+	f.mu.Unlock()
+}
+
+// authenticate proves the knowledge of the secret to the partner and checks its proof, both answer the nonce of the other.
+func (f *failover) authenticate(conn net.Conn, dec *json.Decoder, hello, partner *failoverMessage) bool {
+	auth := &failoverMessage{Type: failoverAuth, MAC: f.authMAC(hello.Role, partner.Nonce)}
+	if err := json.NewEncoder(conn).Encode(auth); err != nil {
+		fmt.Printf("failover auth to %s failed:%s\n", conn.RemoteAddr(), err.Error())
+		return false
+	}
+	var m failoverMessage
+	if err := dec.Decode(&m); err != nil {
+		fmt.Printf("failover auth from %s failed:%s\n", conn.RemoteAddr(), err.Error())
+		return false
+	}
+	if m.Type != failoverAuth || !hmac.Equal([]byte(m.MAC), []byte(f.authMAC(partner.Role, hello.Nonce))) {
+		fmt.Printf("failover partner %s refused: invalid secret\n", conn.RemoteAddr())
+		return false
+	}
+	return true
+}
+
+func (f *failover) authMAC(role, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(f.settings.secret))
+	mac.Write([]byte(role + " " + nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// write sends the queued messages, and a heartbeat when there were none for a third of maxResponseDelay.
+func (f *failover) write(conn net.Conn, queue *failoverQueue, done chan bool) {
+	enc := json.NewEncoder(conn)
+	heartbeat := time.NewTicker(f.settings.maxResponseDelay / 3)
+	defer heartbeat.Stop()
+	for {
+		var messages []*failoverMessage
+		select {
+		case <-done:
+			return
+		case <-queue.ready:
+			messages = queue.pop()
+		case <-heartbeat.C:
+			messages = []*failoverMessage{{Type: failoverHeartbeat}}
+		}
+		for _, m := range messages {
+			conn.SetWriteDeadline(time.Now().Add(f.settings.maxResponseDelay))
+			if err := enc.Encode(m); err != nil {
+				fmt.Printf("failover write to %s failed:%s\n", conn.RemoteAddr(), err.Error())
+				conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func (f *failover) handle(m *failoverMessage, queue *failoverQueue) {
+	if m.Type != failoverDone && m.Type != failoverHeartbeat && (m.Lease == nil || m.Lease.IP.To4() == nil) {
+		fmt.Printf("failover %s message without a lease\n", m.Type)
+		return
+	}
+
+	switch m.Type {
+	case failoverUpdate:
+		newer, err := f.server.partnerUpdate(m.Lease)
+		if err != nil {
+			fmt.Printf("failover update of %s failed:%s\n", m.Lease.IP, err.Error())
+		} else if newer != nil {
+			queue.push(&failoverMessage{Type: failoverUpdate, Lease: newer})
+		} else {
+			queue.push(&failoverMessage{Type: failoverAck, Lease: m.Lease})
+		}
+	case failoverAck:
+		if err := f.server.partnerAck(m.Lease); err != nil {
+			fmt.Printf("failover ack of %s failed:%s\n", m.Lease.IP, err.Error())
+		}
+	case failoverDelete:
+		if err := f.server.partnerDelete(m.Lease); err != nil {
+			fmt.Printf("failover delete of %s failed:%s\n", m.Lease.IP, err.Error())
+		}
+	case failoverDone:
+		f.mu.Lock()
+		f.setState(FailoverNormal)
+		f.mu.Unlock()
+	}
+}
+
+// send queues m for the partner, it is dropped when the partner is not connected:
+// all leases are sent again when it reconnects.
+func (f *failover) send(m *failoverMessage) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.queue != nil {
+		f.queue.push(m)
+	}
+}
+
+// update sends a lease granted until potential, the expiry the client may be given after the partner acknowledged it.
+func (f *failover) update(l *Lease, potential time.Time) {
+	update := l.clone()
+	update.Expiry = potential
+	f.send(&failoverMessage{Type: failoverUpdate, Lease: update})
+}
+
+func (f *failover) delete(l *Lease) {
+	f.send(&failoverMessage{Type: failoverDelete, Lease: l.clone()})
+}
+
+// serves reports whether the server answers the client of r when it is not bound yet.
+func (f *failover) serves(r Request) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.state != FailoverNormal {
+		return true
+	}
+	return (int(LoadBalanceHash(r)) < f.settings.split) == f.settings.primary
+}
+
+// share returns the free addresses the server allocates at now.
+func (f *failover) share(now time.Time) Share {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.state == FailoverPartnerDown && now.Sub(f.interrupted) >= f.settings.mclt {
+		return ShareAll
+	}
+	if f.settings.primary {
+		return SharePrimary
+	}
+	return ShareSecondary
+}
+
+// maxLeaseTime returns the longest lease the client with key may be granted on ip at now:
+// the MCLT beyond the expiry the partner acknowledged.
+func (f *failover) maxLeaseTime(ip net.IP, key string, now time.Time) uint32 {
+	known := now
+	if l, ok, err := f.server.Store.Get(ip); err == nil && ok && l.Client == key && l.PartnerExpiry.After(now) {
+		known = l.PartnerExpiry
+	}
+	limit := known.Sub(now)
+	if limit >= time.Duration(dhcp4.InfiniteLeaseTime)*time.Second-f.settings.mclt {
+		return dhcp4.InfiniteLeaseTime
+	}
+	return uint32((limit + f.settings.mclt) / time.Second)
+}
+
+// partnerDown lets the server take over the clients and addresses of an unreachable partner.
+func (f *failover) partnerDown() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.state == FailoverNormal {
+		return fmt.Errorf("failover partner is reachable")
+	}
+	f.setState(FailoverPartnerDown)
+	return nil
+}
+
+func (f *failover) currentState() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.state
+}
+
+// failoverQueue holds the messages waiting to be written to the partner, it never blocks
+// so that reading from the partner never waits for writing to it.
+type failoverQueue struct {
+	mu       sync.Mutex
+	messages []*failoverMessage
+	ready    chan bool
+}
+
+func newFailoverQueue() *failoverQueue {
+	return &failoverQueue{ready: make(chan bool, 1)}
+}
+
+func (q *failoverQueue) push(m *failoverMessage) {
+	q.mu.Lock()
+	q.messages = append(q.messages, m)
+	q.mu.Unlock()
+	select {
+	case q.ready <- true:
+	default:
+	}
+}
+
+func (q *failoverQueue) pop() []*failoverMessage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	messages := q.messages
+	q.messages = nil
+	return messages
+}
+
+// partnerExpiry is the PartnerExpiry of a lease acknowledged until expiry.
+func partnerExpiry(expiry time.Time) time.Time {
+	if expiry.IsZero() {
+		return foreverExpiry
+	}
+	return expiry
+}
+
+// partnerUpdate stores a lease the partner granted and allocates it, unless the server holds a
+// lease on the address granted later, which is returned to be sent back.
+func (s *Server) partnerUpdate(l *Lease) (*Lease, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	local, ok, err := s.Store.Get(l.IP)
+	if err != nil {
+		return nil, err
+	}
+	if ok && local.Start.After(l.Start) {
+		return local, nil
+	}
+
+	if subnet := s.settings.pool.Subnet(l.IP); subnet != nil {
+		if owner, ok := subnet.Owner(l.IP); ok && owner != l.Client {
+			subnet.Release(l.IP)
+		}
+		if err := subnet.Claim(l.IP, l.Client); err != nil {
+			fmt.Printf("lease %s of %s from the failover partner is not allocated:%s\n", l.IP, l.Client, err.Error())
+		}
+	}
+	l.PartnerExpiry = partnerExpiry(l.Expiry)
+	return nil, s.Store.Put(l)
+}
+
+// partnerAck records the expiry the partner acknowledged for a lease.
+func (s *Server) partnerAck(l *Lease) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	local, ok, err := s.Store.Get(l.IP)
+	if err != nil || !ok || local.Client != l.Client || !partnerExpiry(l.Expiry).After(local.PartnerExpiry) {
+		return err
+	}
+	local.PartnerExpiry = partnerExpiry(l.Expiry)
+	return s.Store.Put(local)
+}
+
+// partnerDelete removes a lease the client released or declined on the partner.
+func (s *Server) partnerDelete(l *Lease) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	local, ok, err := s.Store.Get(l.IP)
+	if err != nil || !ok || local.Client != l.Client || local.Start.After(l.Start) {
+		return err
+	}
+	if subnet := s.settings.pool.Subnet(l.IP); subnet != nil {
+		if owner, ok := subnet.Owner(l.IP); ok && owner == l.Client {
+			subnet.Release(l.IP)
+		}
+	}
+	return s.Store.Delete(l.IP)
+}
@@ -0,0 +1,215 @@
+package server4
+
+import (
+	"fmt"
+	"github.com/Kseleven/agile-dhcp/dhcp4"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLoadBalanceHash(t *testing.T) {
+	//the mixing table of RFC 3074 is a permutation of the octets
+	var seen [256]bool
+	for _, v := range loadBalanceTable {
+		seen[v] = true
+	}
+	for v, ok := range seen {
+		if !ok {
+			t.Fatalf("%d missing from the mixing table", v)
+		}
+	}
+
+	//worked out by hand from the table: hash starts at the key length, the key is mixed in from its last octet
+	for _, tc := range []struct {
+		r    Request
+		hash uint8
+	}{
+		{Request{}, 0},
+		{Request{MAC: net.HardwareAddr{0}}, 175},    //table[1^0]
+		{Request{MAC: net.HardwareAddr{1}}, 251},    //table[1^1]
+		{Request{MAC: net.HardwareAddr{0, 1}}, 120}, //table[table[2^1]^0] = table[215]
+		{Request{MAC: net.HardwareAddr{0, 0, 0, 0, 0, 1}}, 153},
+		{Request{MAC: net.HardwareAddr{0, 0x0c, 0x29, 0x12, 0x34, 0x56}}, 201},
+		//the client identifier takes precedence over the hardware address
+		{Request{MAC: net.HardwareAddr{0, 0x0c, 0x29, 0x12, 0x34, 0x56}, ClientID: []byte{1, 0, 0x0c, 0x29, 0x12, 0x34, 0x56}}, 56},
+	} {
+		if hash := LoadBalanceHash(tc.r); hash != tc.hash {
+			t.Errorf("hash of %s %x is %d, want %d", tc.r.MAC, tc.r.ClientID, hash, tc.hash)
+		}
+	}
+}
+
+func TestIsPeer(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+	for _, tc := range []struct {
+		peer string
+		ok   bool
+	}{
+		{"127.0.0.1", true},
+		{"localhost", true},
+		{"127.0.0.2", false},
+		{"failover-partner.invalid", false},
+	} {
+		if ok := isPeer(addr, tc.peer); ok != tc.ok {
+			t.Errorf("connection from %s is peer %s: %t", addr, tc.peer, ok)
+		}
+	}
+}
+
+// newFailoverServer returns a server of 127.0.0.0/24 with the failover settings in JSON.
+func newFailoverServer(t *testing.T, failover string) *Server {
+	src := `{"interfaces": ["lo"], "leaseTime": 3600, "failover": ` + failover + `,
+	"subnets": [{"subnet": "127.0.0.0/24", "pools": [{"range": "127.0.0.100-127.0.0.150"}]}]}`
+	config, err := ParseConfig("test.json", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewServer(config, NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// startFailoverPair starts a primary and a secondary with the secrets given, connecting on a free loopback port.
+func startFailoverPair(t *testing.T, primarySecret, secondarySecret string) (*Server, *Server) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	common := `"mclt": 60, "maxResponseDelay": 1, "autoPartnerDown": 1`
+	secondary := newFailoverServer(t, fmt.Sprintf(`{"role": "secondary", "address": %q, "peer": "127.0.0.1", "secret": %q, %s}`,
+		address, secondarySecret, common))
+	primary := newFailoverServer(t, fmt.Sprintf(`{"role": "primary", "peer": %q, "secret": %q, %s}`, address, primarySecret, common))
+	if err := secondary.failover.start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := primary.failover.start(); err != nil {
+		secondary.failover.close()
+		t.Fatal(err)
+	}
+	return primary, secondary
+}
+
+// waitFor polls cond until it holds, for at most 5 seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timeout waiting for %s", what)
+}
+
+// handle passes m through s as it goes over the wire.
+func handle(t *testing.T, s *Server, m *dhcp4.Message) *dhcp4.Message {
+	d := &dhcp4.Message{}
+	if err := d.Decode(m.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	reply := s.Handle(d, loopback)
+	if reply == nil {
+		t.Fatalf("no reply to %s", m.MessageType)
+	}
+	r := &dhcp4.Message{}
+	if err := r.Decode(reply.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func leaseTimeOf(m *dhcp4.Message) uint32 {
+	option51, _ := m.Option(51).(dhcp4.Option51)
+	if len(option51.LeaseTime) != 4 {
+		return 0
+	}
+	lt := option51.LeaseTime
+	return uint32(lt[0])<<24 | uint32(lt[1])<<16 | uint32(lt[2])<<8 | uint32(lt[3])
+}
+
+func TestFailover(t *testing.T) {
+	primary, secondary := startFailoverPair(t, "secret", "secret")
+	defer primary.failover.close()
+	secondaryClosed := false
+	defer func() {
+		if !secondaryClosed {
+			secondary.failover.close()
+		}
+	}()
+	waitFor(t, "the normal state", func() bool {
+		return primary.FailoverState() == FailoverNormal && secondary.FailoverState() == FailoverNormal
+	})
+
+	//a client of a hash bucket of the primary
+	var mac net.HardwareAddr
+	for i := 1; mac == nil; i++ {
+		if candidate := (net.HardwareAddr{0, 0, 0, 0, 3, byte(i)}); LoadBalanceHash(Request{MAC: candidate}) < DefaultSplit {
+			mac = candidate
+		}
+	}
+	offer := handle(t, primary, dhcp4.GenDiscoverMessage(mac.String()))
+	ack := handle(t, primary, dhcp4.GenRequestMessage(offer))
+	if ack.MessageType != dhcp4.MessageTypeAck {
+		t.Fatalf("got %s to the request", ack.MessageType)
+	}
+	//the secondary has not acknowledged the lease yet, it is limited to the MCLT
+	if lt := leaseTimeOf(ack); lt != 60 {
+		t.Errorf("lease time %d before the partner acknowledged it, want the mclt 60", lt)
+	}
+
+	ip := net.IP(ack.YourIP)
+	key := MACKey(mac)
+	waitFor(t, "the lease on the secondary", func() bool {
+		l, ok, err := secondary.Store.Get(ip)
+		return err == nil && ok && l.Client == key
+	})
+	if owner, ok := secondary.settings.pool.Subnet(ip).Owner(ip); !ok || owner != key {
+		t.Errorf("%s allocated to %q on the secondary", ip, owner)
+	}
+	waitFor(t, "the acknowledgement of the secondary", func() bool {
+		l, ok, err := primary.Store.Get(ip)
+		return err == nil && ok && !l.PartnerExpiry.IsZero()
+	})
+
+	//once acknowledged the renewal gets the lease time of the scope
+	renewal := handle(t, primary, dhcp4.GenRenewMessage(mac.String(), ip.To4()))
+	if lt := leaseTimeOf(renewal); renewal.MessageType != dhcp4.MessageTypeAck || lt != 3600 {
+		t.Errorf("got %s with lease time %d to the renewal, want the lease time 3600", renewal.MessageType, lt)
+	}
+
+	//the secondary stops: interrupted, then partner-down after autoPartnerDown
+	secondary.failover.close()
+	secondaryClosed = true
+	waitFor(t, "the interrupted state", func() bool { return primary.FailoverState() != FailoverNormal })
+	if share := primary.failover.share(time.Now()); share != SharePrimary {
+		t.Errorf("share %d while the partner is unreachable", share)
+	}
+	waitFor(t, "the partner-down state", func() bool { return primary.FailoverState() == FailoverPartnerDown })
+	now := time.Now()
+	if share := primary.failover.share(now); share != SharePrimary {
+		t.Errorf("share %d in partner-down before the mclt passed", share)
+	}
+	if share := primary.failover.share(now.Add(60 * time.Second)); share != ShareAll {
+		t.Errorf("share %d in partner-down after the mclt", share)
+	}
+	//in partner-down every client is answered
+	for i := 0; i < 256; i++ {
+		if r := (Request{MAC: net.HardwareAddr{0, 0, 0, 0, 4, byte(i)}}); !primary.failover.serves(r) {
+			t.Fatalf("client %s not served in partner-down", r.MAC)
+		}
+	}
+}
+
+func TestFailoverSecret(t *testing.T) {
+	primary, secondary := startFailoverPair(t, "secret", "other")
+	defer secondary.failover.close()
+	defer primary.failover.close()
+	time.Sleep(1500 * time.Millisecond)
+	if primary.FailoverState() == FailoverNormal || secondary.FailoverState() == FailoverNormal {
+		t.Errorf("partners with different secrets in states %s and %s", primary.FailoverState(), secondary.FailoverState())
+	}
+}
@@ -0,0 +1,279 @@
+package server4
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// CompactThreshold is the number of superseded records after which a JournalStore
+// compacts itself, once they also outnumber the live leases.
+const CompactThreshold = 1000
+
+const (
+	journalPut    = "put"
+	journalDelete = "delete"
+)
+
+type journalRecord struct {
+	Op    string `json:"op"`
+	Lease *Lease `json:"lease,omitempty"`
+	IP    net.IP `json:"ip,omitempty"`
+}
+
+// JournalStore appends every change to a text journal, one record per line prefixed
+// with its CRC-32, and keeps the leases in memory. On open the journal is replayed,
+// a torn or corrupt record ends the replay and is cut off with everything after it.
+type JournalStore struct {
+	NoSync bool //do not fsync after each record
+
+	path    string
+	mu      sync.RWMutex
+	file    *os.File
+	leases  leaseMap
+	size    int64 //offset the next record is written at
+	garbage int
+}
+
+func OpenJournalStore(path string) (*JournalStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &JournalStore{path: path, file: f, leases: make(leaseMap)}
+	if err := s.replay(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("replay %s failed:%s", path, err.Error())
+	}
+	return s, nil
+}
+
+func (s *JournalStore) replay() error {
+	r := bufio.NewReader(s.file)
+	var offset int64
+	for line := 1; ; line++ {
+		b, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(b) > 0 {
+				fmt.Printf("journal %s: dropping torn record at line %d\n", s.path, line)
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		record, err := decodeJournalRecord(b)
+		if err != nil {
+			fmt.Printf("journal %s: dropping records from line %d:%s\n", s.path, line, err.Error())
+			break
+		}
+		s.apply(record)
+		offset += int64(len(b))
+	}
+
+	s.size = offset
+	if err := s.file.Truncate(offset); err != nil {
+		return err
+	}
+	_, err := s.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+func decodeJournalRecord(b []byte) (*journalRecord, error) {
+	b = bytes.TrimSuffix(b, []byte("\n"))
+	i := bytes.IndexByte(b, ' ')
+	if i < 0 {
+		return nil, fmt.Errorf("missing checksum")
+	}
+	sum, err := strconv.ParseUint(string(b[:i]), 16, 32)
+	if err != nil || uint32(sum) != crc32.ChecksumIEEE(b[i+1:]) {
+		return nil, fmt.Errorf("checksum mismatch")
+	}
+
+	record := &journalRecord{}
+	if err := json.Unmarshal(b[i+1:], record); err != nil {
+		return nil, err
+	}
+	switch {
+	case record.Op == journalPut && record.Lease != nil && record.Lease.IP.To4() != nil:
+	case record.Op == journalDelete && record.IP.To4() != nil:
+	default:
+		return nil, fmt.Errorf("invalid record")
+	}
+	return record, nil
+}
+
+func encodeJournalRecord(record *journalRecord) ([]byte, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	b := strconv.AppendUint(nil, uint64(crc32.ChecksumIEEE(data)), 16)
+	b = append(b, ' ')
+	b = append(b, data...)
+	return append(b, '\n'), nil
+}
+
+// apply updates the leases in memory with record.
+func (s *JournalStore) apply(record *journalRecord) {
+	switch record.Op {
+	case journalPut:
+		key := ipToUint32(record.Lease.IP)
+		if _, ok := s.leases[key]; ok {
+			s.garbage++
+		}
+		s.leases[key] = record.Lease.clone()
+	case journalDelete:
+		key := ipToUint32(record.IP)
+		if _, ok := s.leases[key]; ok {
+			delete(s.leases, key)
+			s.garbage++
+		}
+		s.garbage++
+	}
+}
+
+func (s *JournalStore) append(record *journalRecord) error {
+	if s.file == nil {
+		return os.ErrClosed
+	}
+	b, err := encodeJournalRecord(record)
+	if err != nil {
+		return err
+	}
+	//a record that failed to be written or synced is cut off, a torn line would end the next replay
+	_, err = s.file.WriteAt(b, s.size)
+	if err == nil && !s.NoSync {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		s.file.Truncate(s.size)
+		return err
+	}
+	s.size += int64(len(b))
+
+	s.apply(record)
+	if s.garbage > CompactThreshold && s.garbage > len(s.leases) {
+		if err := s.compact(); err != nil {
+			fmt.Printf("compact %s failed:%s\n", s.path, err.Error())
+		}
+	}
+	return nil
+}
+
+func (s *JournalStore) Put(l *Lease) error {
+	if _, err := storeKey(l.IP); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.append(&journalRecord{Op: journalPut, Lease: l.clone()})
+}
+
+func (s *JournalStore) Get(ip net.IP) (*Lease, bool, error) {
+	key, err := storeKey(ip)
+	if err != nil {
+		return nil, false, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	l, ok := s.leases[key]
+	if !ok {
+		return nil, false, nil
+	}
+	return l.clone(), true, nil
+}
+
+func (s *JournalStore) Delete(ip net.IP) error {
+	key, err := storeKey(ip)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.leases[key]; !ok {
+		return nil
+	}
+	return s.append(&journalRecord{Op: journalDelete, IP: ip.To4()})
+}
+
+func (s *JournalStore) Leases() ([]*Lease, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.leases.sorted(), nil
+}
+
+// Compact rewrites the journal with one record per lease, the new journal
+// replaces the old one with a rename so a crash leaves either of them intact.
+func (s *JournalStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return os.ErrClosed
+	}
+	return s.compact()
+}
+
+func (s *JournalStore) compact() error {
+	tmp := s.path + ".compact"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	var size int64
+	for _, l := range s.leases.sorted() {
+		var b []byte
+		if b, err = encodeJournalRecord(&journalRecord{Op: journalPut, Lease: l}); err != nil {
+			break
+		}
+		if _, err = w.Write(b); err != nil {
+			break
+		}
+		size += int64(len(b))
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	s.file.Close()
+	s.file = f
+	s.size = size
+	s.garbage = 0
+	return nil
+}
+
+func (s *JournalStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
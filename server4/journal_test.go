@@ -0,0 +1,138 @@
+package server4
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestJournal(t *testing.T, path string) *JournalStore {
+	s, err := OpenJournalStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func testLease(ip string, client string) *Lease {
+	now := time.Now().Truncate(time.Second)
+	return &Lease{IP: net.ParseIP(ip).To4(), Client: client, Start: now, Expiry: now.Add(time.Hour)}
+}
+
+// leaseIPs returns the addresses of the leases of s.
+func leaseIPs(t *testing.T, s Store) string {
+	leases, err := s.Leases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ips string
+	for _, l := range leases {
+		ips += l.IP.String() + " "
+	}
+	return ips
+}
+
+func TestJournalTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases")
+	s := openTestJournal(t, path)
+	s.Put(testLease("192.168.1.10", "mac:00:00:00:00:00:01"))
+	s.Put(testLease("192.168.1.11", "mac:00:00:00:00:00:02"))
+	s.Close()
+
+	info, _ := os.Stat(path)
+	complete := info.Size()
+	record, _ := encodeJournalRecord(&journalRecord{Op: journalPut, Lease: testLease("192.168.1.12", "mac:00:00:00:00:00:03")})
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write(record[:len(record)/2])
+	f.Close()
+
+	s = openTestJournal(t, path)
+	if got := leaseIPs(t, s); got != "192.168.1.10 192.168.1.11 " {
+		t.Errorf("got %q after a torn record", got)
+	}
+	if info, _ := os.Stat(path); info.Size() != complete {
+		t.Errorf("journal size %d, want %d without the torn record", info.Size(), complete)
+	}
+	s.Put(testLease("192.168.1.12", "mac:00:00:00:00:00:03"))
+	s.Close()
+	if got := leaseIPs(t, openTestJournal(t, path)); got != "192.168.1.10 192.168.1.11 192.168.1.12 " {
+		t.Errorf("got %q after writing past the torn record", got)
+	}
+}
+
+func TestJournalFailedWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases")
+	s := openTestJournal(t, path)
+	s.Put(testLease("192.168.1.10", "mac:00:00:00:00:00:01"))
+
+	//a short write leaves part of a line and moves the file offset
+	record, _ := encodeJournalRecord(&journalRecord{Op: journalPut, Lease: testLease("192.168.1.11", "mac:00:00:00:00:00:02")})
+	s.file.WriteAt(record[:len(record)/2], s.size)
+	s.file.Seek(0, io.SeekEnd)
+	s.Put(testLease("192.168.1.12", "mac:00:00:00:00:00:03"))
+	s.Close()
+	if got := leaseIPs(t, openTestJournal(t, path)); got != "192.168.1.10 192.168.1.12 " {
+		t.Errorf("got %q after a failed write", got)
+	}
+}
+
+func TestJournalCorruptRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases")
+	s := openTestJournal(t, path)
+	s.Put(testLease("192.168.1.10", "mac:00:00:00:00:00:01"))
+	s.Put(testLease("192.168.1.11", "mac:00:00:00:00:00:02"))
+	s.Put(testLease("192.168.1.12", "mac:00:00:00:00:00:03"))
+	s.Close()
+
+	//change the client of the second record, its checksum no longer matches
+	b, _ := os.ReadFile(path)
+	lines := bytes.SplitAfter(b, []byte("\n"))
+	lines[1] = bytes.Replace(lines[1], []byte("00:02"), []byte("00:09"), 1)
+	os.WriteFile(path, bytes.Join(lines, nil), 0644)
+
+	s = openTestJournal(t, path)
+	if got := leaseIPs(t, s); got != "192.168.1.10 " {
+		t.Errorf("got %q after a corrupt record", got)
+	}
+	if info, _ := os.Stat(path); info.Size() != int64(len(lines[0])) {
+		t.Errorf("journal size %d, want %d", info.Size(), len(lines[0]))
+	}
+}
+
+func TestJournalCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases")
+	s := openTestJournal(t, path)
+	for i := 0; i < 10; i++ {
+		s.Put(testLease("192.168.1.10", "mac:00:00:00:00:00:01"))
+		s.Put(testLease("192.168.1.11", "mac:00:00:00:00:00:02"))
+	}
+	s.Delete(net.ParseIP("192.168.1.11"))
+
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(path)
+	if n := bytes.Count(b, []byte("\n")); n != 1 {
+		t.Errorf("%d records after compaction, want 1", n)
+	}
+	if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left:%v", err)
+	}
+
+	//records go to the compacted journal
+	s.Put(testLease("192.168.1.12", "mac:00:00:00:00:00:03"))
+	s.Close()
+	s = openTestJournal(t, path)
+	if got := leaseIPs(t, s); got != "192.168.1.10 192.168.1.12 " {
+		t.Errorf("got %q after reopening", got)
+	}
+	l, ok, err := s.Get(net.ParseIP("192.168.1.10"))
+	if err != nil || !ok || l.Client != "mac:00:00:00:00:00:01" {
+		t.Errorf("got lease %+v %t %v", l, ok, err)
+	}
+}
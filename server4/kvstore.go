@@ -0,0 +1,83 @@
+package server4
+
+import (
+	"encoding/json"
+	"github.com/Kseleven/agile-dhcp/kv"
+	"net"
+)
+
+// KVStore keeps leases in an embedded key-value database keyed by address, only the
+// index is held in memory. It is compacted on open and when Compact is called.
+type KVStore struct {
+	db *kv.DB
+}
+
+func OpenKVStore(path string) (*KVStore, error) {
+	db, err := kv.Open(path, kv.Options{})
+	if err != nil {
+		return nil, err
+	}
+	if db.Garbage() > 0 {
+		if err := db.Compact(); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &KVStore{db: db}, nil
+}
+
+func (s *KVStore) Put(l *Lease) error {
+	if _, err := storeKey(l.IP); err != nil {
+		return err
+	}
+	value, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return s.db.Put(l.IP.To4(), value)
+}
+
+func (s *KVStore) Get(ip net.IP) (*Lease, bool, error) {
+	if _, err := storeKey(ip); err != nil {
+		return nil, false, err
+	}
+	value, ok, err := s.db.Get(ip.To4())
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	l := &Lease{}
+	if err := json.Unmarshal(value, l); err != nil {
+		return nil, false, err
+	}
+	return l, true, nil
+}
+
+func (s *KVStore) Delete(ip net.IP) error {
+	if _, err := storeKey(ip); err != nil {
+		return err
+	}
+	return s.db.Delete(ip.To4())
+}
+
+// Leases returns all leases, the 4 byte keys sort in address order.
+func (s *KVStore) Leases() ([]*Lease, error) {
+	var leases []*Lease
+	err := s.db.ForEach(func(key, value []byte) error {
+		l := &Lease{}
+		if err := json.Unmarshal(value, l); err != nil {
+			return err
+		}
+		leases = append(leases, l)
+		return nil
+	})
+	return leases, err
+}
+
+func (s *KVStore) Compact() error {
+	return s.db.Compact()
+}
+
+func (s *KVStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,105 @@
+package server4
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// Lease is an address bound to a client by the server.
+type Lease struct {
+	IP       net.IP    `json:"ip"`
+	Client   string    `json:"client"` //key of the client, see Request.Key
+	MAC      string    `json:"mac"`
+	HType    uint8     `json:"htype,omitempty"` //hardware type of MAC, ethernet if zero
+	HostName string    `json:"hostname,omitempty"`
+	FQDN     string    `json:"fqdn,omitempty"` //name registered in DNS, see DDNSConfig
+	Start    time.Time `json:"start"`
+	Expiry   time.Time `json:"expiry"` //zero for an infinite lease
+
+	PartnerExpiry time.Time `json:"partnerExpiry"` //expiry the failover partner acknowledged, zero without one
+}
+
+// Expired reports whether the lease ended before now.
+func (l *Lease) Expired(now time.Time) bool {
+	return !l.Expiry.IsZero() && !now.Before(l.Expiry)
+}
+
+func (l *Lease) clone() *Lease {
+	c := *l
+	c.IP = append(net.IP(nil), l.IP.To4()...)
+	return &c
+}
+
+// Sweep deletes the leases expired at now from s and returns them.
+func Sweep(s Store, now time.Time) ([]*Lease, error) {
+	leases, err := s.Leases()
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []*Lease
+	for _, l := range leases {
+		if !l.Expired(now) {
+			continue
+		}
+		if err := s.Delete(l.IP); err != nil {
+			return expired, err
+		}
+		expired = append(expired, l)
+	}
+	return expired, nil
+}
+
+// SweepEvery runs Sweep every interval until stop is closed, calling expired for each expired lease.
+func SweepEvery(s Store, interval time.Duration, stop <-chan bool, expired func(*Lease)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			leases, err := Sweep(s, now)
+			if err != nil {
+				fmt.Printf("sweep leases failed:%s\n", err.Error())
+			}
+			if expired != nil {
+				for _, l := range leases {
+					expired(l)
+				}
+			}
+		}
+	}
+}
+
+// Restore allocates the active leases of s in p, after a restart or when the configuration
+// of p changed. Expired leases are deleted. Leases p cannot grant any more, because their
+// subnet was removed or their address reserved for another client, are kept in s until they
+// expire but are not allocated in p.
+func Restore(p *Pool, s Store, now time.Time) error {
+	leases, err := s.Leases()
+	if err != nil {
+		return err
+	}
+
+	for _, l := range leases {
+		if l.Expired(now) {
+			if err := s.Delete(l.IP); err != nil {
+				return err
+			}
+			continue
+		}
+
+		subnet := p.Subnet(l.IP)
+		if subnet == nil {
+			err = fmt.Errorf("no subnet")
+		} else {
+			err = subnet.Claim(l.IP, l.Client)
+		}
+		if err != nil {
+			fmt.Printf("lease %s of %s is not allocated:%s\n", l.IP, l.Client, err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package server4
+
+import (
+	"fmt"
+	"github.com/Kseleven/agile-dhcp/dhcp4"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	MaxRateKeys    = 65536       //clients, relays or circuits a rate limit tracks, more share one bucket
+	fullTablePrune = time.Second //least time between two prunes of a full table of keys
+)
+
+// LimitsConfig protects the server from floods of messages, such as DHCPDISCOVER with spoofed
+// hardware addresses holding every free address in offers. Zero values disable a limit.
+type LimitsConfig struct {
+	PerMAC       RateConfig `json:"perMac"`       //messages of a client hardware address
+	PerRelay     RateConfig `json:"perRelay"`     //messages forwarded by a relay agent(giaddr)
+	PerCircuitID RateConfig `json:"perCircuitId"` //messages of a relay agent circuit(option 82 circuit-id)
+	MaxOffers    int        `json:"maxOffers"`    //offers outstanding at once
+	PoolReserve  int        `json:"poolReserve"`  //percent of the dynamic addresses of a subnet only offered to clients holding one
+}
+
+type RateConfig struct {
+	Rate  float64 `json:"rate"`  //messages per second
+	Burst int     `json:"burst"` //messages accepted at once, the rate rounded up by default
+}
+
+// limits is a compiled LimitsConfig.
+type limits struct {
+	perMAC       *rateLimiter
+	perRelay     *rateLimiter
+	perCircuitID *rateLimiter
+	maxOffers    int
+	poolReserve  int
+}
+
+func (lc *LimitsConfig) compile(errs *configErrors) *limits {
+	l := &limits{maxOffers: lc.MaxOffers, poolReserve: lc.PoolReserve}
+	l.perMAC = lc.PerMAC.compile("limits.perMac", "mac", errs)
+	l.perRelay = lc.PerRelay.compile("limits.perRelay", "relay", errs)
+	l.perCircuitID = lc.PerCircuitID.compile("limits.perCircuitId", "circuit-id", errs)
+	if lc.MaxOffers < 0 {
+		errs.addf("limits.maxOffers", "negative offer limit %d", lc.MaxOffers)
+	}
+	if lc.PoolReserve < 0 || lc.PoolReserve >= 100 {
+		errs.addf("limits.poolReserve", "pool reserve %d is not a percentage below 100", lc.PoolReserve)
+	}
+	return l
+}
+
+// compile returns the limiter of the rate, nil without one.
+func (rc RateConfig) compile(path, name string, errs *configErrors) *rateLimiter {
+	if rc.Rate < 0 || math.IsInf(rc.Rate, 0) || math.IsNaN(rc.Rate) {
+		errs.addf(joinPath(path, "rate"), "invalid rate %v", rc.Rate)
+		return nil
+	}
+	if rc.Burst < 0 {
+		errs.addf(joinPath(path, "burst"), "negative burst %d", rc.Burst)
+		return nil
+	}
+	if rc.Rate == 0 {
+		return nil
+	}
+	burst := float64(rc.Burst)
+	if burst == 0 {
+		burst = math.Ceil(rc.Rate)
+	}
+	return &rateLimiter{name: name, rate: rc.Rate, burst: burst, buckets: make(map[string]*bucket),
+		overflow: &bucket{tokens: burst}}
+}
+
+// rateLimiter limits the messages of each key with a token bucket.
+type rateLimiter struct {
+	name  string //in logs
+	rate  float64
+	burst float64
+
+	mu       sync.Mutex
+	buckets  map[string]*bucket
+	overflow *bucket   //shared by the keys the table has no room for
+	pruned   time.Time //last prune of the full table
+}
+
+type bucket struct {
+	tokens  float64
+	last    time.Time
+	limited bool //the last message was dropped
+}
+
+// allow reports whether a message of key is accepted at now, and whether key has a bucket of its own.
+// When the table of keys is full it is pruned at most every fullTablePrune, a flood of new keys
+// shares the overflow bucket in the meantime.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, tracked := l.buckets[key]
+	if !tracked {
+		if len(l.buckets) >= MaxRateKeys && now.Sub(l.pruned) >= fullTablePrune {
+			l.prune(now)
+			l.pruned = now
+		}
+		if len(l.buckets) >= MaxRateKeys {
+			return l.take(l.overflow, "keys beyond the table", now), false
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+		tracked = true
+	}
+	return l.take(b, key, now), tracked
+}
+
+// take takes a token from the bucket of key at now.
+func (l *rateLimiter) take(b *bucket, key string, now time.Time) bool {
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		if !b.limited {
+			fmt.Printf("rate limit of %s %s reached, dropping its messages\n", l.name, key)
+		}
+		b.limited = true
+		return false
+	}
+	b.tokens--
+	b.limited = false
+	return true
+}
+
+// prune forgets the keys whose buckets are full again, they are the same as new ones.
+func (l *rateLimiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (l *rateLimiter) sweep(now time.Time) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.prune(now)
+	l.mu.Unlock()
+}
+
+// Counters count the messages of clients the server handled and dropped.
+type Counters struct {
+	Received       uint64 `json:"received"`
+	LimitedMAC     uint64 `json:"limitedMac"`       //dropped by the rate limit per hardware address
+	LimitedRelay   uint64 `json:"limitedRelay"`     //dropped by the rate limit per relay agent
+	LimitedCircuit uint64 `json:"limitedCircuitId"` //dropped by the rate limit per circuit-id
+	OffersCapped   uint64 `json:"offersCapped"`     //DHCPDISCOVER unanswered as maxOffers offers were outstanding
+	PoolReserved   uint64 `json:"poolReserved"`     //DHCPDISCOVER unanswered to keep the pool reserve
+	RateUntracked  uint64 `json:"rateUntracked"`    //messages of keys beyond MaxRateKeys, limited together
+}
+
+// Counters returns the current counters of the server.
+func (s *Server) Counters() Counters {
+	c := s.counters
+	return Counters{
+		Received:       atomic.LoadUint64(&c.Received),
+		LimitedMAC:     atomic.LoadUint64(&c.LimitedMAC),
+		LimitedRelay:   atomic.LoadUint64(&c.LimitedRelay),
+		LimitedCircuit: atomic.LoadUint64(&c.LimitedCircuit),
+		OffersCapped:   atomic.LoadUint64(&c.OffersCapped),
+		PoolReserved:   atomic.LoadUint64(&c.PoolReserved),
+		RateUntracked:  atomic.LoadUint64(&c.RateUntracked),
+	}
+}
+
+// limited reports whether m exceeds a rate limit and is dropped.
+func (s *Server) limited(l *limits, m *dhcp4.Message, r Request) bool {
+	if l == nil {
+		return false
+	}
+	now := time.Now()
+	if l.perMAC != nil && len(r.MAC) > 0 && !s.allow(l.perMAC, r.MAC.String(), now) {
+		atomic.AddUint64(&s.counters.LimitedMAC, 1)
+		return true
+	}
+	giaddr := net.IP(m.RelayAgentIP)
+	if giaddr.To4() == nil || giaddr.Equal(net.IPv4zero) {
+		return false
+	}
+	if l.perRelay != nil && !s.allow(l.perRelay, giaddr.String(), now) {
+		atomic.AddUint64(&s.counters.LimitedRelay, 1)
+		return true
+	}
+	if circuitID, ok := relayAgentSubOption(m, 1); ok && l.perCircuitID != nil &&
+		!s.allow(l.perCircuitID, fmt.Sprintf("%s/%x", giaddr, circuitID), now) {
+		atomic.AddUint64(&s.counters.LimitedCircuit, 1)
+		return true
+	}
+	return false
+}
+
+// allow reports whether rl accepts a message of key at now, counting the keys it does not track.
+func (s *Server) allow(rl *rateLimiter, key string, now time.Time) bool {
+	allowed, tracked := rl.allow(key, now)
+	if !tracked {
+		atomic.AddUint64(&s.counters.RateUntracked, 1)
+	}
+	return allowed
+}
+
+// offerAllowed reports whether the client of t may get an offer in subnet: new offers stop at
+// maxOffers, and at the pool reserve for clients not holding an address of subnet.
+func (s *Server) offerAllowed(t *transaction, subnet *Subnet) bool {
+	l := t.st.limits
+	if l == nil {
+		return true
+	}
+	if l.maxOffers > 0 {
+		s.offersMu.Lock()
+		_, pending := s.offers[t.key]
+		full := !pending && len(s.offers) >= l.maxOffers
+		s.offersMu.Unlock()
+		if full {
+			atomic.AddUint64(&s.counters.OffersCapped, 1)
+			return false
+		}
+	}
+	if l.poolReserve > 0 && !subnet.Holds(t.r) {
+		if free, size := subnet.free(); free*100 <= size*l.poolReserve {
+			atomic.AddUint64(&s.counters.PoolReserved, 1)
+			return false
+		}
+	}
+	return true
+}
+
+func (l *limits) sweep(now time.Time) {
+	if l == nil {
+		return
+	}
+	l.perMAC.sweep(now)
+	l.perRelay.sweep(now)
+	l.perCircuitID.sweep(now)
+}
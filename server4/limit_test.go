@@ -0,0 +1,40 @@
+package server4
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestRateLimitFullTable checks that keys beyond the table share one bucket and that the full table
+// is not pruned on every message.
+func TestRateLimitFullTable(t *testing.T) {
+	l := RateConfig{Rate: 1, Burst: 2}.compile("limits.perMac", "mac", &configErrors{config: &Config{}})
+	now := time.Now()
+	for i := 0; i < MaxRateKeys; i++ {
+		l.allow(fmt.Sprint(i), now)
+	}
+
+	s := newTestServer(t, false)
+	var allowed int
+	for i := 0; i < 10; i++ {
+		if s.allow(l, fmt.Sprintf("new%d", i), now.Add(time.Duration(i)*time.Millisecond)) {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Errorf("%d messages of new keys allowed, want the burst 2", allowed)
+	}
+	if c := s.Counters(); c.RateUntracked != 10 {
+		t.Errorf("%d untracked messages counted, want 10", c.RateUntracked)
+	}
+	if !l.pruned.Equal(now) {
+		t.Errorf("full table pruned at %s, want once at %s", l.pruned, now)
+	}
+
+	//once the buckets are full again a prune makes room
+	later := now.Add(time.Minute)
+	if allowed, tracked := l.allow("new", later); !allowed || !tracked || len(l.buckets) != 1 {
+		t.Errorf("new key allowed %t tracked %t with %d keys after a prune", allowed, tracked, len(l.buckets))
+	}
+}
@@ -0,0 +1,97 @@
+package server4
+
+import (
+	"github.com/Kseleven/agile-dhcp/metrics"
+	"time"
+)
+
+// Metrics of the messages of the server, registered in metrics.Default, see also Server.RegisterMetrics.
+var (
+	messagesReceived = metrics.Default.NewCounterVec("dhcp4_server_messages_received_total", "DHCP messages received by type.", "type")
+	messagesSent     = metrics.Default.NewCounterVec("dhcp4_server_messages_sent_total", "DHCP messages sent by type.", "type")
+	decodeErrors     = metrics.Default.NewCounterVec("dhcp4_server_decode_errors_total", "Messages received that could not be decoded.", "interface")
+	handleSeconds    = metrics.Default.NewHistogramVec("dhcp4_server_handle_seconds", "Time to answer a message by type.", nil, "type")
+)
+
+// RegisterMetrics registers the state of the server in r: active leases, pool addresses and
+// utilization by subnet, outstanding offers, messages dropped by the limits and the failover state.
+// Registering another server replaces them.
+func (s *Server) RegisterMetrics(r *metrics.Registry) {
+	r.NewCollector("dhcp4_server_leases_active", "Leases not expired in the lease store.", "gauge", nil,
+		func(emit func(v float64, values ...string)) {
+			leases, err := s.Store.Leases()
+			if err != nil {
+				return
+			}
+			now := time.Now()
+			var active int
+			for _, l := range leases {
+				if !l.Expired(now) {
+					active++
+				}
+			}
+			emit(float64(active))
+		})
+	r.NewCollector("dhcp4_server_pool_addresses", "Dynamic addresses of a subnet by state.", "gauge", []string{"subnet", "state"},
+		func(emit func(v float64, values ...string)) {
+			for _, st := range s.poolStats() {
+				emit(float64(st.Size), st.Network, "size")
+				emit(float64(st.Free), st.Network, "free")
+				emit(float64(st.Allocated), st.Network, "allocated")
+				emit(float64(st.Reserved), st.Network, "reserved")
+				emit(float64(st.Excluded), st.Network, "excluded")
+				emit(float64(st.Abandoned), st.Network, "abandoned")
+			}
+		})
+	r.NewCollector("dhcp4_server_pool_utilization", "Share of the dynamic addresses of a subnet not available, 0 to 1.", "gauge", []string{"subnet"},
+		func(emit func(v float64, values ...string)) {
+			for _, st := range s.poolStats() {
+				emit(st.Utilization(), st.Network)
+			}
+		})
+	r.NewCollector("dhcp4_server_offers_outstanding", "Offers waiting for a DHCPREQUEST.", "gauge", nil,
+		func(emit func(v float64, values ...string)) {
+			s.offersMu.Lock()
+			n := len(s.offers)
+			s.offersMu.Unlock()
+			emit(float64(n))
+		})
+	r.NewCollector("dhcp4_server_dropped_total", "Messages dropped or left unanswered by the limits, by reason.", "counter", []string{"reason"},
+		func(emit func(v float64, values ...string)) {
+			c := s.Counters()
+			emit(float64(c.LimitedMAC), "rate_mac")
+			emit(float64(c.LimitedRelay), "rate_relay")
+			emit(float64(c.LimitedCircuit), "rate_circuit_id")
+			emit(float64(c.OffersCapped), "max_offers")
+			emit(float64(c.PoolReserved), "pool_reserve")
+		})
+	r.NewCollector("dhcp4_server_rate_untracked_total", "Messages of keys beyond the table of a rate limit, limited together.", "counter", nil,
+		func(emit func(v float64, values ...string)) {
+			emit(float64(s.Counters().RateUntracked))
+		})
+	if s.failover != nil {
+		r.NewCollector("dhcp4_server_failover_state", "Failover state, 1 for the current one.", "gauge", []string{"state"},
+			func(emit func(v float64, values ...string)) {
+				current := s.FailoverState()
+				for _, state := range []string{FailoverNormal, FailoverInterrupted, FailoverPartnerDown} {
+					v := 0.0
+					if state == current {
+						v = 1
+					}
+					emit(v, state)
+				}
+			})
+	}
+}
+
+// poolStats returns the statistics of the subnets of the current configuration.
+func (s *Server) poolStats() []Stats {
+	s.mu.RLock()
+	subnets := s.settings.pool.Subnets()
+	s.mu.RUnlock()
+	stats := make([]Stats, len(subnets))
+	for i, subnet := range subnets {
+		stats[i] = subnet.Stats()
+	}
+	return stats
+}
@@ -0,0 +1,266 @@
+package server4
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/Kseleven/agile-dhcp/dhcp4"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type optionKind int
+
+const (
+	kindIP      optionKind = iota //one address
+	kindIPs                       //list of addresses
+	kindUint8                     //unsigned 8 bit integer
+	kindUint16                    //unsigned 16 bit integer
+	kindUint32                    //unsigned 32 bit integer
+	kindInt32                     //signed 32 bit integer
+	kindBool                      //true or false, one octet
+	kindString                    //text
+	kindHex                       //octets in hex, colons are ignored
+	kindDomains                   //domain search list(RFC 3397)
+	kindRoutes                    //classless static routes "destination/prefix router"(RFC 3442)
+)
+
+// optionDef describes how the value of an option is written in the configuration.
+type optionDef struct {
+	code uint8
+	name string
+	kind optionKind
+}
+
+// optionDefs are the options settable by name, other options are set by code with a hex value.
+var optionDefs = []optionDef{
+	{1, "subnet-mask", kindIP},
+	{2, "time-offset", kindInt32},
+	{3, "routers", kindIPs},
+	{4, "time-servers", kindIPs},
+	{6, "domain-name-servers", kindIPs},
+	{7, "log-servers", kindIPs},
+	{12, "host-name", kindString},
+	{15, "domain-name", kindString},
+	{19, "ip-forwarding", kindBool},
+	{26, "interface-mtu", kindUint16},
+	{28, "broadcast-address", kindIP},
+	{33, "static-routes", kindIPs},
+	{42, "ntp-servers", kindIPs},
+	{43, "vendor-encapsulated-options", kindHex},
+	{44, "netbios-name-servers", kindIPs},
+	{46, "netbios-node-type", kindUint8},
+	{47, "netbios-scope", kindString},
+	{60, "vendor-class-identifier", kindString},
+	{66, "tftp-server-name", kindString},
+	{67, "bootfile-name", kindString},
+	{69, "smtp-servers", kindIPs},
+	{108, "v6-only-preferred", kindUint32},
+	{114, "captive-portal", kindString},
+	{119, "domain-search", kindDomains},
+	{121, "classless-static-routes", kindRoutes},
+	{138, "capwap-ac", kindIPs},
+	{150, "tftp-server-address", kindIPs},
+	{252, "wpad", kindString},
+}
+
+// serverOptions are set by the server from the lease and the message, they cannot be configured.
+var serverOptions = map[uint8]string{
+	0:   "pad",
+	50:  "requested address",
+	51:  "lease time, use leaseTime",
+	52:  "option overload",
+	53:  "message type",
+	54:  "server identifier",
+	55:  "parameter request list",
+	57:  "maximum message size",
+	58:  "renewal time, use renewalTime",
+	59:  "rebinding time, use rebindingTime",
+	61:  "client identifier",
+	82:  "relay agent information",
+	255: "end",
+}
+
+// lookupOption finds the option named by name or by its decimal code.
+func lookupOption(name string) (optionDef, error) {
+	code, err := strconv.ParseUint(name, 10, 8)
+	for _, def := range optionDefs {
+		if def.name == name || (err == nil && uint64(def.code) == code) {
+			return def, nil
+		}
+	}
+	if err != nil {
+		return optionDef{}, fmt.Errorf("unknown option %q", name)
+	}
+	return optionDef{code: uint8(code), name: name, kind: kindHex}, nil
+}
+
+// OptionValue is the value of an option in the configuration, a string, number, boolean
+// or an array of them. Lists may also be written as one comma separated string.
+type OptionValue []string
+
+func (v *OptionValue) UnmarshalJSON(b []byte) error {
+	var values []interface{}
+	if len(b) > 0 && b[0] == '[' {
+		if err := json.Unmarshal(b, &values); err != nil {
+			return err
+		}
+	} else {
+		var value interface{}
+		if err := json.Unmarshal(b, &value); err != nil {
+			return err
+		}
+		values = []interface{}{value}
+	}
+
+	*v = nil
+	for _, value := range values {
+		switch value := value.(type) {
+		case string:
+			*v = append(*v, value)
+		case float64:
+			*v = append(*v, strconv.FormatFloat(value, 'f', -1, 64))
+		case bool:
+			*v = append(*v, strconv.FormatBool(value))
+		default:
+			return fmt.Errorf("option value must be a string, number, boolean or an array of them")
+		}
+	}
+	return nil
+}
+
+// items splits the values on commas.
+func (v OptionValue) items() []string {
+	var items []string
+	for _, value := range v {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// encode encodes value as the option of def.
+func (def optionDef) encode(value OptionValue) (dhcp4.OptionInter, error) {
+	if len(value) == 0 {
+		return nil, fmt.Errorf("empty value")
+	}
+
+	var data []byte
+	items := value.items()
+	switch def.kind {
+	case kindIP, kindIPs:
+		if def.kind == kindIP && len(items) != 1 {
+			return nil, fmt.Errorf("expected one address")
+		}
+		for _, item := range items {
+			ip := net.ParseIP(item).To4()
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", item)
+			}
+			data = append(data, ip...)
+		}
+	case kindUint8, kindUint16, kindUint32, kindInt32:
+		if len(items) != 1 {
+			return nil, fmt.Errorf("expected one number")
+		}
+		bits := 32
+		switch def.kind {
+		case kindUint8:
+			bits = 8
+		case kindUint16:
+			bits = 16
+		}
+		var n uint64
+		var err error
+		if def.kind == kindInt32 {
+			var i int64
+			i, err = strconv.ParseInt(items[0], 10, bits)
+			n = uint64(uint32(i))
+		} else {
+			n, err = strconv.ParseUint(items[0], 10, bits)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %d bit number %q", bits, items[0])
+		}
+		data = make([]byte, 8)
+		binary.BigEndian.PutUint64(data, n)
+		data = data[8-bits/8:]
+	case kindBool:
+		b, err := strconv.ParseBool(strings.Join(items, ""))
+		if err != nil {
+			return nil, fmt.Errorf("invalid boolean %q", strings.Join(value, ","))
+		}
+		data = []byte{0}
+		if b {
+			data[0] = 1
+		}
+	case kindString:
+		data = []byte(strings.Join(value, ","))
+	case kindHex:
+		s := strings.NewReplacer(":", "", " ", "", "0x", "").Replace(strings.Join(value, ""))
+		var err error
+		if data, err = hex.DecodeString(s); err != nil {
+			return nil, fmt.Errorf("invalid hex value %q", strings.Join(value, ""))
+		}
+	case kindDomains:
+		option, err := dhcp4.GenOption119(items...)
+		if err != nil {
+			return nil, err
+		}
+		return option, nil
+	case kindRoutes:
+		var routes []dhcp4.ClasslessRoute
+		for _, item := range items {
+			fields := strings.Fields(item)
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("route %q is not \"destination/prefix router\"", item)
+			}
+			_, destination, err := net.ParseCIDR(fields[0])
+			router := net.ParseIP(fields[1]).To4()
+			if err != nil || destination.IP.To4() == nil || router == nil {
+				return nil, fmt.Errorf("invalid route %q", item)
+			}
+			routes = append(routes, dhcp4.ClasslessRoute{Destination: destination, Router: router})
+		}
+		return dhcp4.GenOption121(routes...), nil
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty value")
+	}
+	return dhcp4.GenOptionRaw(def.code, data), nil
+}
+
+// optionSet holds the options of a scope by code.
+type optionSet map[uint8]dhcp4.OptionInter
+
+// merge returns the options of s overridden by those of child.
+func (s optionSet) merge(child optionSet) optionSet {
+	merged := make(optionSet, len(s)+len(child))
+	for code, option := range s {
+		merged[code] = option
+	}
+	for code, option := range child {
+		merged[code] = option
+	}
+	return merged
+}
+
+// sorted returns the options in order of their codes.
+func (s optionSet) sorted() []dhcp4.OptionInter {
+	codes := make([]int, 0, len(s))
+	for code := range s {
+		codes = append(codes, int(code))
+	}
+	sort.Ints(codes)
+	options := make([]dhcp4.OptionInter, 0, len(codes))
+	for _, code := range codes {
+		options = append(options, s[uint8(code)])
+	}
+	return options
+}
@@ -0,0 +1,70 @@
+package server4
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// Pinger checks whether an address is in use before it is offered.
+type Pinger interface {
+	InUse(ip net.IP) bool
+}
+
+// ICMPPinger sends an ICMP echo request and waits Timeout for the reply.
+// It needs a raw socket, which requires root or CAP_NET_RAW.
+type ICMPPinger struct {
+	Timeout time.Duration
+}
+
+func NewICMPPinger(timeout time.Duration) *ICMPPinger {
+	return &ICMPPinger{Timeout: timeout}
+}
+
+// InUse reports whether ip answers an echo request, errors are logged and reported as not in use.
+func (p *ICMPPinger) InUse(ip net.IP) bool {
+	conn, err := net.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		fmt.Printf("ping %s failed:%s\n", ip, err.Error())
+		return false
+	}
+	defer conn.Close()
+
+	id := uint16(rand.Intn(0x10000))
+	if _, err := conn.WriteTo(icmpEchoRequest(id, 1), &net.IPAddr{IP: ip}); err != nil {
+		fmt.Printf("ping %s failed:%s\n", ip, err.Error())
+		return false
+	}
+
+	conn.SetReadDeadline(time.Now().Add(p.Timeout))
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return false
+		}
+		addr, ok := from.(*net.IPAddr)
+		if !ok || !addr.IP.Equal(ip) || n < 8 {
+			continue
+		}
+		if buf[0] == 0 && uint16(buf[4])<<8|uint16(buf[5]) == id {
+			return true
+		}
+	}
+}
+
+// icmpEchoRequest builds an ICMP echo request without payload.
+func icmpEchoRequest(id, seq uint16) []byte {
+	b := []byte{8, 0, 0, 0, byte(id >> 8), byte(id), byte(seq >> 8), byte(seq)}
+	var sum uint32
+	for i := 0; i < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	checksum := ^uint16(sum)
+	b[2], b[3] = byte(checksum>>8), byte(checksum)
+	return b
+}
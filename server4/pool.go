@@ -0,0 +1,756 @@
+// Package server4 implements the parts of a DHCPv4 server built on the messages of package dhcp4.
+package server4
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/Kseleven/agile-dhcp/dhcp4"
+	"math/bits"
+	"net"
+	"sort"
+	"sync"
+)
+
+// MaxPingAttempts is the number of addresses found in use by ping-before-offer before Allocate gives up.
+const MaxPingAttempts = 3
+
+var ErrNoFreeAddress = errors.New("no free address")
+
+// abandonedOwner owns addresses found in use by another host, they are not allocated until released.
+const abandonedOwner = "!abandoned"
+
+// Request holds the fields of a client message addresses are allocated by.
+type Request struct {
+	MAC         net.HardwareAddr
+	ClientID    []byte   //option 61 with the type octet
+	RequestedIP net.IP   //option 50
+	Classes     []string //client classes, ranges restricted to classes allocate to their members only
+	Share       Share    //free addresses the client may be allocated
+}
+
+// Share is the part of the free addresses of the ranges a server allocates. Failover partners
+// allocate alternate addresses of each range so they never allocate the same one.
+type Share uint8
+
+const (
+	ShareAll       Share = iota
+	SharePrimary         //addresses at even offsets of their range
+	ShareSecondary       //addresses at odd offsets of their range
+)
+
+// mask marks the addresses of a 64 address word outside the share.
+func (sh Share) mask() uint64 {
+	switch sh {
+	case SharePrimary:
+		return 0xaaaaaaaaaaaaaaaa
+	case ShareSecondary:
+		return 0x5555555555555555
+	}
+	return 0
+}
+
+func NewRequest(m *dhcp4.Message) Request {
+	r := Request{MAC: net.HardwareAddr(m.ClientMAC.HardwareAddress)}
+	if option61, ok := m.Option(61).(dhcp4.Option61); ok {
+		r.ClientID = append([]byte{option61.HardwareType}, option61.ClientIdentifier...)
+	}
+	if option50, ok := m.Option(50).(dhcp4.Option50); ok {
+		r.RequestedIP = net.IP(option50.Address)
+	}
+	return r
+}
+
+// Key identifies the client by its client identifier, or by its hardware address without one (RFC 2131 section 4.2).
+func (r Request) Key() string {
+	if len(r.ClientID) > 0 {
+		return ClientIDKey(r.ClientID)
+	}
+	return MACKey(r.MAC)
+}
+
+func ClientIDKey(id []byte) string {
+	return "id:" + hex.EncodeToString(id)
+}
+
+func MACKey(mac net.HardwareAddr) string {
+	return "mac:" + mac.String()
+}
+
+// Pool is the set of subnets a server allocates addresses from.
+type Pool struct {
+	//Pinger checks addresses before they are allocated to a client for the first time, nil disables the check
+	Pinger Pinger
+
+	mu      sync.RWMutex
+	subnets []*Subnet
+}
+
+func NewPool() *Pool {
+	return &Pool{}
+}
+
+// AddSubnet adds the network in CIDR notation.
+func (p *Pool) AddSubnet(network string) (*Subnet, error) {
+	_, ipNet, err := net.ParseCIDR(network)
+	if err != nil || ipNet.IP.To4() == nil {
+		return nil, fmt.Errorf("invalid subnet %q", network)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, s := range p.subnets {
+		if s.Network.Contains(ipNet.IP) || ipNet.Contains(s.Network.IP) {
+			return nil, fmt.Errorf("subnet %s overlaps %s", ipNet, s.Network)
+		}
+	}
+
+	s := newSubnet(ipNet)
+	p.subnets = append(p.subnets, s)
+	return s, nil
+}
+
+// Subnet returns the subnet containing ip, nil if there is none.
+func (p *Pool) Subnet(ip net.IP) *Subnet {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, s := range p.subnets {
+		if s.Network.Contains(ip) {
+			return s
+		}
+	}
+	return nil
+}
+
+func (p *Pool) Subnets() []*Subnet {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]*Subnet(nil), p.subnets...)
+}
+
+// Allocate allocates an address to the client of r in the subnet of link, the relay agent address
+// or the address of the interface the request was received on.
+func (p *Pool) Allocate(link net.IP, r Request) (net.IP, *Subnet, error) {
+	s := p.Subnet(link)
+	if s == nil {
+		return nil, nil, fmt.Errorf("no subnet for %s", link)
+	}
+
+	ip, err := s.Allocate(r, p.Pinger)
+	return ip, s, err
+}
+
+type ipRange struct {
+	start uint32
+	end   uint32 //inclusive
+}
+
+func (r ipRange) contains(ip uint32) bool {
+	return ip >= r.start && ip <= r.end
+}
+
+// addressRange is a range of dynamically allocated addresses, one bit per address marks it unavailable:
+// allocated, reserved or excluded.
+type addressRange struct {
+	ipRange
+	used    []uint64
+	free    int
+	next    uint32   //next-fit cursor, offset of the address after the last one allocated
+	classes []string //classes the range is restricted to, nil for all clients
+}
+
+func newAddressRange(start, end uint32) *addressRange {
+	size := uint64(end-start) + 1
+	r := &addressRange{ipRange: ipRange{start: start, end: end}, used: make([]uint64, (size+63)/64), free: int(size)}
+	if rem := size % 64; rem != 0 {
+		r.used[len(r.used)-1] = ^uint64(0) << rem
+	}
+	return r
+}
+
+// permits reports whether a client of classes may be allocated an address of the range.
+func (r *addressRange) permits(classes []string) bool {
+	if len(r.classes) == 0 {
+		return true
+	}
+	for _, c := range classes {
+		for _, allowed := range r.classes {
+			if c == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *addressRange) size() int {
+	return int(r.end-r.start) + 1
+}
+
+func (r *addressRange) isUsed(ip uint32) bool {
+	off := ip - r.start
+	return r.used[off/64]&(1<<(off%64)) != 0
+}
+
+func (r *addressRange) mark(ip uint32) {
+	off := ip - r.start
+	if r.used[off/64]&(1<<(off%64)) == 0 {
+		r.used[off/64] |= 1 << (off % 64)
+		r.free--
+	}
+}
+
+func (r *addressRange) clear(ip uint32) {
+	off := ip - r.start
+	if r.used[off/64]&(1<<(off%64)) != 0 {
+		r.used[off/64] &^= 1 << (off % 64)
+		r.free++
+	}
+}
+
+// inShare reports whether ip is one of the addresses of share.
+func (r *addressRange) inShare(ip uint32, share Share) bool {
+	return share.mask()&(1<<((ip-r.start)%64)) == 0
+}
+
+// take marks the first free address of share from the cursor on, wrapping around, and returns it.
+func (r *addressRange) take(share Share) (uint32, bool) {
+	if r.free == 0 {
+		return 0, false
+	}
+
+	first := int(r.next / 64)
+	for i := 0; i <= len(r.used); i++ {
+		w := (first + i) % len(r.used)
+		word := r.used[w] | share.mask()
+		if i == 0 {
+			word |= 1<<(r.next%64) - 1
+		}
+		if word == ^uint64(0) {
+			continue
+		}
+
+		bit := bits.TrailingZeros64(^word)
+		off := uint32(w*64 + bit)
+		r.used[w] |= 1 << bit
+		r.free--
+		r.next = off + 1
+		if int(r.next) >= r.size() {
+			r.next = 0
+		}
+		return r.start + off, true
+	}
+	return 0, false
+}
+
+// Subnet is a network with ranges of dynamic addresses, exclusions and reservations.
+// Memory grows with the number of clients and with the size of the ranges, one bit per dynamic address.
+type Subnet struct {
+	Network *net.IPNet
+
+	mu           sync.Mutex
+	ranges       []*addressRange
+	exclusions   []ipRange
+	reservations map[string]uint32 //client key -> reserved address
+	reserved     map[uint32]string //reserved address -> client key
+	owners       map[uint32]string //allocated address -> client key
+	clients      map[string]uint32 //client key -> address it holds or held last
+	rangeIndex   int               //range next-fit allocation continues in
+}
+
+func newSubnet(network *net.IPNet) *Subnet {
+	return &Subnet{
+		Network:      network,
+		reservations: make(map[string]uint32),
+		reserved:     make(map[uint32]string),
+		owners:       make(map[uint32]string),
+		clients:      make(map[string]uint32),
+	}
+}
+
+func ipToUint32(ip net.IP) uint32 {
+	ip = ip.To4()
+	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
+}
+
+func uint32ToIP(v uint32) net.IP {
+	return net.IPv4(byte(v>>24), byte(v>>16), byte(v>>8), byte(v)).To4()
+}
+
+func (s *Subnet) parseRange(start, end string) (ipRange, error) {
+	startIP, endIP := net.ParseIP(start), net.ParseIP(end)
+	if startIP == nil || startIP.To4() == nil || !s.Network.Contains(startIP) {
+		return ipRange{}, fmt.Errorf("%q is not an address of subnet %s", start, s.Network)
+	}
+	if endIP == nil || endIP.To4() == nil || !s.Network.Contains(endIP) {
+		return ipRange{}, fmt.Errorf("%q is not an address of subnet %s", end, s.Network)
+	}
+
+	r := ipRange{start: ipToUint32(startIP), end: ipToUint32(endIP)}
+	if r.start > r.end {
+		return ipRange{}, fmt.Errorf("range %s-%s is reversed", start, end)
+	}
+	return r, nil
+}
+
+// AddRange adds the addresses from start to end to the dynamic addresses of the subnet,
+// restricted to the clients of classes if any are given.
+// The network and broadcast addresses of the subnet are never allocated.
+func (s *Subnet) AddRange(start, end string, classes ...string) error {
+	r, err := s.parseRange(start, end)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, other := range s.ranges {
+		if r.start <= other.end && other.start <= r.end {
+			return fmt.Errorf("range %s-%s overlaps %s-%s", start, end, uint32ToIP(other.start), uint32ToIP(other.end))
+		}
+	}
+
+	ar := newAddressRange(r.start, r.end)
+	ar.classes = classes
+	for _, e := range s.exclusions {
+		for ip := max32(e.start, r.start); ip <= min32(e.end, r.end) && ip >= e.start; ip++ {
+			ar.mark(ip)
+		}
+	}
+	for ip := range s.reserved {
+		if ar.contains(ip) {
+			ar.mark(ip)
+		}
+	}
+	if network, broadcast, ok := s.boundaries(); ok {
+		for _, ip := range []uint32{network, broadcast} {
+			if ar.contains(ip) {
+				ar.mark(ip)
+			}
+		}
+	}
+	for ip := range s.owners {
+		if ar.contains(ip) {
+			ar.mark(ip)
+		}
+	}
+	s.ranges = append(s.ranges, ar)
+	return nil
+}
+
+// AddExclusion keeps the addresses from start to end out of dynamic allocation.
+// Addresses already allocated stay with their clients until released.
+func (s *Subnet) AddExclusion(start, end string) error {
+	e, err := s.parseRange(start, end)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exclusions = append(s.exclusions, e)
+	for _, r := range s.ranges {
+		for ip := max32(e.start, r.start); ip <= min32(e.end, r.end) && ip >= e.start; ip++ {
+			r.mark(ip)
+		}
+	}
+	return nil
+}
+
+// AddReservation reserves ip for the client with key, see MACKey and ClientIDKey.
+func (s *Subnet) AddReservation(key string, ip net.IP) error {
+	if ip.To4() == nil || !s.Network.Contains(ip) {
+		return fmt.Errorf("%s is not an address of subnet %s", ip, s.Network)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v := ipToUint32(ip)
+	if other, ok := s.reserved[v]; ok && other != key {
+		return fmt.Errorf("%s is reserved for %s", ip, other)
+	}
+	if owner, ok := s.owners[v]; ok && owner != key {
+		return fmt.Errorf("%s is allocated to %s", ip, owner)
+	}
+
+	if old, ok := s.reservations[key]; ok {
+		s.removeReservation(key, old)
+	}
+	s.reservations[key] = v
+	s.reserved[v] = key
+	if r := s.rangeOf(v); r != nil {
+		r.mark(v)
+	}
+	return nil
+}
+
+// RemoveReservation removes the reservation of the client with key.
+func (s *Subnet) RemoveReservation(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.reservations[key]
+	if ok {
+		s.removeReservation(key, v)
+	}
+	return ok
+}
+
+func (s *Subnet) removeReservation(key string, v uint32) {
+	delete(s.reservations, key)
+	delete(s.reserved, v)
+	if _, allocated := s.owners[v]; !allocated {
+		s.release(v)
+	}
+}
+
+// Reservation returns the address reserved for the client of r, by client identifier or hardware address.
+func (s *Subnet) Reservation(r Request) (net.IP, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.reservationOf(r)
+	if !ok {
+		return nil, false
+	}
+	return uint32ToIP(v), true
+}
+
+func (s *Subnet) reservationOf(r Request) (uint32, bool) {
+	if len(r.ClientID) > 0 {
+		if v, ok := s.reservations[ClientIDKey(r.ClientID)]; ok {
+			return v, true
+		}
+	}
+	v, ok := s.reservations[MACKey(r.MAC)]
+	return v, ok
+}
+
+func (s *Subnet) rangeOf(v uint32) *addressRange {
+	for _, r := range s.ranges {
+		if r.contains(v) {
+			return r
+		}
+	}
+	return nil
+}
+
+// boundaries returns the network and broadcast addresses of the subnet,
+// /31 and /32 subnets have neither (RFC 3021).
+func (s *Subnet) boundaries() (uint32, uint32, bool) {
+	ones, bits := s.Network.Mask.Size()
+	if bits != 32 || ones > 30 {
+		return 0, 0, false
+	}
+	network := ipToUint32(s.Network.IP)
+	return network, network | (1<<(32-ones) - 1), true
+}
+
+func (s *Subnet) excluded(v uint32) bool {
+	if network, broadcast, ok := s.boundaries(); ok && (v == network || v == broadcast) {
+		return true
+	}
+	for _, e := range s.exclusions {
+		if e.contains(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// available reports whether v is a free dynamic address.
+func (s *Subnet) available(v uint32) bool {
+	r := s.rangeOf(v)
+	return r != nil && !r.isUsed(v)
+}
+
+// Permitted reports whether ip may be allocated to a client of classes: it is reserved,
+// outside the ranges or in a range open to one of classes.
+func (s *Subnet) Permitted(ip net.IP, classes []string) bool {
+	if ip.To4() == nil {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.permitted(ipToUint32(ip), classes)
+}
+
+func (s *Subnet) permitted(v uint32, classes []string) bool {
+	if _, ok := s.reserved[v]; ok {
+		return true
+	}
+	r := s.rangeOf(v)
+	return r == nil || r.permits(classes)
+}
+
+// allocatable reports whether v is free for the client of r.
+func (s *Subnet) allocatable(v uint32, r Request) bool {
+	return s.available(v) && s.permitted(v, r.Classes) && s.rangeOf(v).inShare(v, r.Share)
+}
+
+func (s *Subnet) own(v uint32, key string) {
+	if r := s.rangeOf(v); r != nil {
+		r.mark(v)
+	}
+	s.owners[v] = key
+	s.clients[key] = v
+}
+
+// release frees v unless it is excluded or reserved.
+func (s *Subnet) release(v uint32) {
+	delete(s.owners, v)
+	if _, ok := s.reserved[v]; ok || s.excluded(v) {
+		return
+	}
+	if r := s.rangeOf(v); r != nil {
+		r.clear(v)
+	}
+}
+
+// Allocate picks an address for the client of r, in order of preference: its reservation,
+// the address it holds or held last, the address it requested and the next free address.
+// Addresses new to the client are checked with pinger first, those in use are abandoned.
+func (s *Subnet) Allocate(r Request, pinger Pinger) (net.IP, error) {
+	for attempt := 0; attempt < MaxPingAttempts; attempt++ {
+		v, fresh, err := s.pick(r)
+		if err != nil {
+			return nil, err
+		}
+
+		ip := uint32ToIP(v)
+		if !fresh || pinger == nil || !pinger.InUse(ip) {
+			return ip, nil
+		}
+		s.abandon(v)
+	}
+	return nil, ErrNoFreeAddress
+}
+
+// pick allocates an address to the client of r, fresh reports whether the client did not hold it already.
+func (s *Subnet) pick(r Request) (uint32, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := r.Key()
+	if v, ok := s.reservationOf(r); ok {
+		fresh := s.owners[v] != key
+		s.own(v, key)
+		return v, fresh, nil
+	}
+
+	if v, ok := s.clients[key]; ok {
+		if s.owners[v] == key && !s.permitted(v, r.Classes) {
+			//the client left the classes of the range
+			s.release(v)
+			delete(s.clients, key)
+		} else if s.owners[v] == key {
+			return v, false, nil
+		} else if s.allocatable(v, r) {
+			s.own(v, key)
+			return v, true, nil
+		}
+	}
+
+	if r.RequestedIP.To4() != nil && s.Network.Contains(r.RequestedIP) {
+		if v := ipToUint32(r.RequestedIP); s.allocatable(v, r) {
+			s.own(v, key)
+			return v, true, nil
+		}
+	}
+
+	for i := range s.ranges {
+		index := (s.rangeIndex + i) % len(s.ranges)
+		if !s.ranges[index].permits(r.Classes) {
+			continue
+		}
+		if v, ok := s.ranges[index].take(r.Share); ok {
+			s.rangeIndex = index
+			s.owners[v] = key
+			s.clients[key] = v
+			return v, true, nil
+		}
+	}
+	return 0, false, ErrNoFreeAddress
+}
+
+func (s *Subnet) abandon(v uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Printf("address %s is in use, abandoned\n", uint32ToIP(v))
+	if key, ok := s.owners[v]; ok && s.clients[key] == v {
+		delete(s.clients, key)
+	}
+	s.owners[v] = abandonedOwner
+}
+
+// Claim allocates ip to the client with key, as when a lease is loaded or a client asks for
+// an address it was given before. It fails if ip is not free for the client.
+func (s *Subnet) Claim(ip net.IP, key string) error {
+	if ip.To4() == nil || !s.Network.Contains(ip) {
+		return fmt.Errorf("%s is not an address of subnet %s", ip, s.Network)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v := ipToUint32(ip)
+	if owner, ok := s.owners[v]; ok {
+		if owner == key {
+			return nil
+		}
+		return fmt.Errorf("%s is allocated to %s", ip, owner)
+	}
+	if reservedFor, ok := s.reserved[v]; ok {
+		if reservedFor != key {
+			return fmt.Errorf("%s is reserved for %s", ip, reservedFor)
+		}
+	} else if !s.available(v) {
+		return fmt.Errorf("%s is not a free dynamic address", ip)
+	}
+
+	s.own(v, key)
+	return nil
+}
+
+// Release frees ip, the client keeps it as its preferred address for the next allocation.
+func (s *Subnet) Release(ip net.IP) {
+	if ip.To4() == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.release(ipToUint32(ip))
+}
+
+// Abandon keeps ip from being allocated, as when a client declines it (DHCPDECLINE).
+func (s *Subnet) Abandon(ip net.IP) {
+	if ip.To4() == nil || !s.Network.Contains(ip) {
+		return
+	}
+	s.abandon(ipToUint32(ip))
+}
+
+// Holds reports whether the client of r holds an address of the subnet or has a reservation in it.
+func (s *Subnet) Holds(r Request) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.reservationOf(r); ok {
+		return true
+	}
+	key := r.Key()
+	v, ok := s.clients[key]
+	return ok && s.owners[v] == key
+}
+
+// free returns the free and total dynamic addresses.
+func (s *Subnet) free() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var free, size int
+	for _, r := range s.ranges {
+		free += r.free
+		size += r.size()
+	}
+	return free, size
+}
+
+// Owner returns the key of the client ip is allocated to.
+func (s *Subnet) Owner(ip net.IP) (string, bool) {
+	if ip.To4() == nil {
+		return "", false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.owners[ipToUint32(ip)]
+	return key, ok
+}
+
+// Stats counts the addresses of a subnet.
+type Stats struct {
+	Network   string `json:"network"`
+	Size      int    `json:"size"`      //dynamic addresses
+	Excluded  int    `json:"excluded"`  //dynamic addresses excluded
+	Reserved  int    `json:"reserved"`  //reservations
+	Allocated int    `json:"allocated"` //addresses allocated to clients, including reserved ones
+	Abandoned int    `json:"abandoned"` //addresses found in use by ping-before-offer
+	Free      int    `json:"free"`      //dynamic addresses available
+}
+
+// Utilization is the share of dynamic addresses not available, from 0 to 1.
+func (st Stats) Utilization() float64 {
+	if st.Size == 0 {
+		return 0
+	}
+	return float64(st.Size-st.Free) / float64(st.Size)
+}
+
+func (s *Subnet) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := Stats{Network: s.Network.String(), Reserved: len(s.reservations)}
+	for _, r := range s.ranges {
+		st.Size += r.size()
+		st.Free += r.free
+		st.Excluded += s.excludedIn(r.ipRange)
+	}
+	for _, owner := range s.owners {
+		if owner == abandonedOwner {
+			st.Abandoned++
+		} else {
+			st.Allocated++
+		}
+	}
+	return st
+}
+
+// excludedIn counts the addresses of r excluded or on the subnet boundaries, each once
+// however many exclusions cover it.
+func (s *Subnet) excludedIn(r ipRange) int {
+	var covered []ipRange
+	for _, e := range s.exclusions {
+		if e.start <= r.end && r.start <= e.end {
+			covered = append(covered, ipRange{start: max32(e.start, r.start), end: min32(e.end, r.end)})
+		}
+	}
+	if network, broadcast, ok := s.boundaries(); ok {
+		for _, ip := range []uint32{network, broadcast} {
+			if r.contains(ip) {
+				covered = append(covered, ipRange{start: ip, end: ip})
+			}
+		}
+	}
+	sort.Slice(covered, func(i, j int) bool { return covered[i].start < covered[j].start })
+
+	count := 0
+	var next uint64 //first address not counted yet
+	for _, c := range covered {
+		start := max64(uint64(c.start), next)
+		if uint64(c.end) >= start {
+			count += int(uint64(c.end)-start) + 1
+			next = uint64(c.end) + 1
+		}
+	}
+	return count
+}
+
+func max64(a, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func min32(a, b uint32) uint32 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max32(a, b uint32) uint32 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,70 @@
+package server4
+
+import (
+	"net"
+	"testing"
+)
+
+// TestRangeBoundaries checks that the network and broadcast addresses of a subnet are never allocated.
+func TestRangeBoundaries(t *testing.T) {
+	p := NewPool()
+	s, err := p.AddSubnet("192.168.1.0/30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddRange("192.168.1.0", "192.168.1.3"); err != nil {
+		t.Fatal(err)
+	}
+	if st := s.Stats(); st.Size != 4 || st.Excluded != 2 || st.Free != 2 {
+		t.Errorf("stats %+v", st)
+	}
+
+	var got []string
+	for i := 0; i < 3; i++ {
+		mac := net.HardwareAddr{0, 0, 0, 0, 0, byte(i + 1)}
+		ip, err := s.Allocate(Request{MAC: mac}, nil)
+		if err != nil {
+			break
+		}
+		got = append(got, ip.String())
+	}
+	if len(got) != 2 || got[0] != "192.168.1.1" || got[1] != "192.168.1.2" {
+		t.Errorf("allocated %v", got)
+	}
+	for _, ip := range []string{"192.168.1.0", "192.168.1.3"} {
+		if err := s.Claim(net.ParseIP(ip), MACKey(net.HardwareAddr{0, 0, 0, 0, 0, 9})); err == nil {
+			t.Errorf("%s claimed", ip)
+		}
+	}
+
+	//a /31 has no network or broadcast address (RFC 3021)
+	s, _ = p.AddSubnet("10.0.0.0/31")
+	s.AddRange("10.0.0.0", "10.0.0.1")
+	if st := s.Stats(); st.Excluded != 0 || st.Free != 2 {
+		t.Errorf("stats %+v of a /31", st)
+	}
+}
+
+// TestStatsExcluded checks that an address covered by several exclusions, or by an exclusion and a subnet boundary, counts once.
+func TestStatsExcluded(t *testing.T) {
+	s, err := NewPool().AddSubnet("192.168.1.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddRange("192.168.1.0", "192.168.1.255"); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range [][2]string{
+		{"192.168.1.0", "192.168.1.9"},     //the network address and 1-9
+		{"192.168.1.5", "192.168.1.19"},    //overlaps the first, adds 10-19
+		{"192.168.1.10", "192.168.1.12"},   //inside the second
+		{"192.168.1.250", "192.168.1.255"}, //250-254 and the broadcast address
+	} {
+		if err := s.AddExclusion(e[0], e[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if st := s.Stats(); st.Size != 256 || st.Excluded != 26 || st.Free != 230 {
+		t.Errorf("stats %+v", st)
+	}
+}
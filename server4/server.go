@@ -0,0 +1,748 @@
+package server4
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Kseleven/agile-dhcp/dhcp4"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ServerPort = 67
+	ClientPort = 68
+
+	OfferTimeout  = time.Minute      //how long an offered address is kept for the client to request it
+	SweepInterval = 10 * time.Second //how often expired offers and leases are released
+	PingTimeout   = 500 * time.Millisecond
+)
+
+type offer struct {
+	ip     net.IP
+	subnet *Subnet
+	expiry time.Time
+}
+
+// Server answers DHCPv4 clients with the addresses and options of its configuration.
+// Leases are kept in Store, Reload replaces the configuration without dropping them.
+type Server struct {
+	Store Store
+
+	mu       sync.RWMutex //held for reading while a message is handled
+	settings *settings
+	failover *failover //nil without a partner
+
+	offersMu sync.Mutex
+	offers   map[string]offer //by client key
+
+	connsMu sync.Mutex
+	conns   map[string]*net.UDPConn //by interface name
+	serving bool
+	stop    chan bool
+	wg      sync.WaitGroup
+
+	dnsQueue chan func() //dynamic DNS updates, see DDNSConfig
+	counters *Counters   //updated atomically
+
+	reservations []apiReservation //added through the API, guarded by mu
+}
+
+// NewServer creates a server with config, allocating the active leases of store.
+func NewServer(config *Config, store Store) (*Server, error) {
+	st, err := config.compile()
+	if err != nil {
+		return nil, err
+	}
+	if err := Restore(st.pool, store, time.Now()); err != nil {
+		return nil, fmt.Errorf("restore leases failed:%s", err.Error())
+	}
+	s := &Server{Store: store, settings: st, offers: make(map[string]offer),
+		conns: make(map[string]*net.UDPConn), stop: make(chan bool),
+		dnsQueue: make(chan func(), DNSQueueSize), counters: &Counters{}}
+	if st.failover != nil {
+		s.failover = newFailover(s, *st.failover)
+	}
+	return s, nil
+}
+
+// Reload replaces the configuration. Leases stay in the store and are allocated in the new pools,
+// pending offers are dropped. Interfaces are opened and closed to match, the lease store
+// is not changed until a restart.
+func (s *Server) Reload(config *Config) error {
+	st, err := config.compile()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if config.LeaseStore != s.settings.config.LeaseStore {
+		fmt.Println("lease store changed, restart to apply")
+	}
+	if (st.failover == nil) != (s.failover == nil) || (st.failover != nil && *st.failover != s.failover.settings) {
+		fmt.Println("failover changed, restart to apply")
+	}
+	if (config.API == nil) != (s.settings.config.API == nil) || (config.API != nil && *config.API != *s.settings.config.API) {
+		fmt.Println("api changed, restart to apply")
+	}
+	for _, ar := range s.reservations {
+		if _, err := st.addReservation(ar); err != nil {
+			fmt.Printf("reservation %s of the api dropped:%s\n", ar.Name, err.Error())
+		}
+	}
+	if err := Restore(st.pool, s.Store, time.Now()); err != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("restore leases failed:%s", err.Error())
+	}
+	s.settings = st
+	s.mu.Unlock()
+
+	s.offersMu.Lock()
+	s.offers = make(map[string]offer)
+	s.offersMu.Unlock()
+	return s.updateInterfaces()
+}
+
+// Serve listens on the configured interfaces and answers clients until Close.
+func (s *Server) Serve() error {
+	s.connsMu.Lock()
+	s.serving = true
+	s.connsMu.Unlock()
+	if err := s.updateInterfaces(); err != nil {
+		s.Close()
+		return err
+	}
+	if s.failover != nil {
+		if err := s.failover.start(); err != nil {
+			s.Close()
+			return err
+		}
+		defer s.failover.close()
+	}
+	if ac := s.settings.config.API; ac != nil {
+		api, err := s.startAPI(ac)
+		if err != nil {
+			s.Close()
+			return err
+		}
+		defer api.Close()
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.sweep()
+	}()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.sendDNSUpdates()
+	}()
+	<-s.stop
+	s.wg.Wait()
+	return nil
+}
+
+// Close stops Serve, the store is left open.
+func (s *Server) Close() error {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	if !s.serving {
+		return nil
+	}
+	s.serving = false
+	for name, conn := range s.conns {
+		conn.Close()
+		delete(s.conns, name)
+	}
+	close(s.stop)
+	return nil
+}
+
+// FailoverState returns the failover state of the server, empty without a partner.
+func (s *Server) FailoverState() string {
+	if s.failover == nil {
+		return ""
+	}
+	return s.failover.currentState()
+}
+
+// PartnerDown tells the server its unreachable failover partner is down: it serves all the clients
+// and, once the MCLT has passed since the partner became unreachable, all the free addresses.
+func (s *Server) PartnerDown() error {
+	if s.failover == nil {
+		return fmt.Errorf("no failover partner")
+	}
+	return s.failover.partnerDown()
+}
+
+// updateInterfaces listens on the configured interfaces that are not open yet and closes the others.
+func (s *Server) updateInterfaces() error {
+	s.mu.RLock()
+	interfaces := s.settings.config.Interfaces
+	s.mu.RUnlock()
+
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+	if !s.serving {
+		return nil
+	}
+
+	configured := make(map[string]bool)
+	for _, name := range interfaces {
+		configured[name] = true
+		if _, ok := s.conns[name]; ok {
+			continue
+		}
+		ifi, err := net.InterfaceByName(name)
+		if err != nil {
+			return err
+		}
+		conn, err := listen(ifi)
+		if err != nil {
+			return fmt.Errorf("listen on %s failed:%s", name, err.Error())
+		}
+		s.conns[name] = conn
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serve(ifi, conn)
+		}()
+	}
+	for name, conn := range s.conns {
+		if !configured[name] {
+			conn.Close()
+			delete(s.conns, name)
+		}
+	}
+	return nil
+}
+
+func listen(ifi *net.Interface) (*net.UDPConn, error) {
+	lc := net.ListenConfig{Control: socketControl(ifi.Name)}
+	conn, err := lc.ListenPacket(context.Background(), "udp4", fmt.Sprintf(":%d", ServerPort))
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
+
+// interfaceAddress returns the first IPv4 address of ifi.
+func interfaceAddress(ifi *net.Interface) net.IP {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			return ipNet.IP.To4()
+		}
+	}
+	return nil
+}
+
+func (s *Server) serve(ifi *net.Interface, conn *net.UDPConn) {
+	data := make([]byte, dhcp4.MaxMessageSize)
+	for {
+		length, addr, err := conn.ReadFromUDP(data)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			fmt.Printf("read message on %s failed:%s\n", ifi.Name, err.Error())
+			continue
+		}
+
+		m := &dhcp4.Message{}
+		if err := m.Decode(data[:length]); err != nil {
+			fmt.Printf("decode message from %s failed:%s\n", addr, err.Error())
+			decodeErrors.With(ifi.Name).Inc()
+			continue
+		}
+		if m.OpCode != 1 {
+			continue
+		}
+		messagesReceived.With(m.MessageType.Label()).Inc()
+		fmt.Println("receive DHCP Message<----:", addr, length)
+		fmt.Println(m.String())
+
+		ifaddr := interfaceAddress(ifi)
+		if ifaddr == nil {
+			fmt.Printf("interface %s has no IPv4 address\n", ifi.Name)
+			continue
+		}
+		start := time.Now()
+		reply := s.Handle(m, ifaddr)
+		handleSeconds.With(m.MessageType.Label()).ObserveSince(start)
+		if reply == nil {
+			continue
+		}
+		to := ReplyAddress(m, reply)
+		fmt.Printf("send message----> %s:\n%s\n", to, reply.String())
+		if _, err := conn.WriteToUDP(reply.Encode(), to); err != nil {
+			fmt.Printf("write reply to %s failed:%s\n", to, err.Error())
+			continue
+		}
+		messagesSent.With(reply.MessageType.Label()).Inc()
+	}
+}
+
+// ReplyAddress returns where reply to request is sent (RFC 2131 section 4.1): the relay agent,
+// the client address of a bound client, or broadcast. Replies are never unicast to yiaddr,
+// that needs an ARP entry the server cannot add without a raw socket.
+func ReplyAddress(request, reply *dhcp4.Message) *net.UDPAddr {
+	if giaddr := net.IP(request.RelayAgentIP); !giaddr.Equal(net.IPv4zero) && giaddr.To4() != nil {
+		return &net.UDPAddr{IP: giaddr, Port: ServerPort}
+	}
+	if ciaddr := net.IP(request.ClientIP); reply.MessageType != dhcp4.MessageTypeNak &&
+		!ciaddr.Equal(net.IPv4zero) && ciaddr.To4() != nil {
+		return &net.UDPAddr{IP: ciaddr, Port: ClientPort}
+	}
+	return &net.UDPAddr{IP: net.IPv4bcast, Port: ClientPort}
+}
+
+// transaction is the context a client message is handled in.
+type transaction struct {
+	st       *settings
+	m        *dhcp4.Message
+	r        Request
+	key      string
+	link     net.IP //relay agent address or address of the receiving interface
+	serverID net.IP
+	classes  []*class
+	host     *host
+
+	maxLeaseTime uint32 //limit of failover, 0 for none
+	leaseTime    uint32 //lease time of the scope, before the limit of failover
+
+	extra []dhcp4.OptionInter //options of the reply not set in scopes
+}
+
+// Handle answers client message m received on the interface with address ifaddr,
+// returning nil when no reply is due.
+func (s *Server) Handle(m *dhcp4.Message, ifaddr net.IP) *dhcp4.Message {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	atomic.AddUint64(&s.counters.Received, 1)
+	t := &transaction{st: s.settings, m: m, r: NewRequest(m), link: ifaddr, serverID: ifaddr}
+	if s.limited(t.st.limits, m, t.r) {
+		return nil
+	}
+	t.key = t.r.Key()
+	if giaddr := net.IP(m.RelayAgentIP); giaddr.To4() != nil && !giaddr.Equal(net.IPv4zero) {
+		t.link = giaddr
+	}
+	if id := net.ParseIP(t.st.config.ServerIdentifier).To4(); id != nil {
+		t.serverID = id
+	}
+	t.classes = t.st.classesOf(m)
+	for _, c := range t.classes {
+		t.r.Classes = append(t.r.Classes, c.name)
+	}
+	t.host = t.st.host(t.r)
+	if s.failover != nil {
+		t.r.Share = s.failover.share(time.Now())
+	}
+
+	switch m.MessageType {
+	case dhcp4.MessageTypeDiscover:
+		return s.discover(t)
+	case dhcp4.MessageTypeRequest:
+		return s.request(t)
+	case dhcp4.MessageTypeDecline:
+		s.decline(t)
+	case dhcp4.MessageTypeRelease:
+		s.release(t)
+	case dhcp4.MessageTypeInform:
+		return s.inform(t)
+	}
+	return nil
+}
+
+func (s *Server) discover(t *transaction) *dhcp4.Message {
+	if s.failover != nil && !s.failover.serves(t.r) {
+		return nil
+	}
+	subnet := t.st.pool.Subnet(t.link)
+	if subnet == nil {
+		fmt.Printf("no offer for %s:no subnet for %s\n", t.key, t.link)
+		return nil
+	}
+	if !s.offerAllowed(t, subnet) {
+		return nil
+	}
+	ip, err := subnet.Allocate(t.r, t.st.pool.Pinger)
+	if err != nil {
+		fmt.Printf("no offer for %s:%s\n", t.key, err.Error())
+		return nil
+	}
+
+	s.offersMu.Lock()
+	s.offers[t.key] = offer{ip: ip, subnet: subnet, expiry: time.Now().Add(OfferTimeout)}
+	s.offersMu.Unlock()
+
+	s.limitLeaseTime(t, ip)
+	reply, _ := t.reply(dhcp4.MessageTypeOffer, subnet, ip)
+	return reply
+}
+
+// States of a client sending DHCPREQUEST(RFC 2131 section 4.3.2).
+const (
+	stateSelecting  = "SELECTING"
+	stateInitReboot = "INIT-REBOOT"
+	stateRenewing   = "RENEWING"
+	stateRebinding  = "REBINDING"
+)
+
+// requestState returns the state of the client sending DHCPREQUEST m and the address it requests:
+// the server identifier(option 54) is only sent in SELECTING, the requested address(option 50)
+// without ciaddr in INIT-REBOOT, ciaddr in RENEWING and REBINDING. A renewal is unicast to the
+// server, a relayed request is REBINDING; on the server's own network the two cannot be told
+// apart without the destination address and are both RENEWING. The state is empty when the
+// fields do not match any state.
+func requestState(m *dhcp4.Message, r Request) (string, net.IP) {
+	ciaddr := net.IP(m.ClientIP)
+	hasCiaddr := ciaddr.To4() != nil && !ciaddr.Equal(net.IPv4zero)
+	switch {
+	case m.Option(54) != nil:
+		if hasCiaddr || r.RequestedIP.To4() == nil {
+			return "", nil
+		}
+		return stateSelecting, r.RequestedIP
+	case hasCiaddr:
+		if giaddr := net.IP(m.RelayAgentIP); giaddr.To4() != nil && !giaddr.Equal(net.IPv4zero) {
+			return stateRebinding, ciaddr
+		}
+		return stateRenewing, ciaddr
+	case r.RequestedIP.To4() != nil:
+		return stateInitReboot, r.RequestedIP
+	}
+	return "", nil
+}
+
+// request answers DHCPREQUEST. A request for an address on another network is refused with DHCPNAK
+// (RFC 2131 section 4.3.2). One the server has no record of for the client is refused by an
+// authoritative server and ignored otherwise, another server may know the client.
+// Addresses known to be wrong are always refused.
+func (s *Server) request(t *transaction) *dhcp4.Message {
+	state, ip := requestState(t.m, t.r)
+	switch state {
+	case "":
+		fmt.Printf("ignore malformed DHCPREQUEST of %s\n", t.key)
+		return nil
+	case stateSelecting:
+		option54, ok := t.m.Option(54).(dhcp4.Option54)
+		if !ok {
+			fmt.Printf("ignore DHCPREQUEST of %s with a malformed server identifier\n", t.key)
+			return nil
+		}
+		if !net.IP(option54.ServerIdentifier).Equal(t.serverID) {
+			//the client accepted the offer of another server
+			s.withdrawOffer(t.key)
+			return nil
+		}
+	case stateInitReboot:
+		if s.failover != nil && !s.failover.serves(t.r) {
+			//INIT-REBOOT is answered by the server of the client's hash bucket
+			return nil
+		}
+	}
+
+	network := t.link
+	if state == stateRenewing {
+		//a renewal may be unicast from any network, ciaddr is trusted
+		network = ip
+	}
+	subnet := t.st.pool.Subnet(network)
+	if subnet == nil {
+		return t.refuse(state, "no subnet of %s", network)
+	}
+	if !subnet.Network.Contains(ip) {
+		return t.nak("requested address %s is not on the network of %s", ip, t.link)
+	}
+	if state != stateSelecting && !s.known(t, subnet, ip) {
+		if owner, ok := subnet.Owner(ip); ok && owner != t.key {
+			return t.nak("requested address %s is allocated to another client", ip)
+		}
+		return t.refuse(state, "no lease of %s for the client", ip)
+	}
+	if !subnet.Permitted(ip, t.r.Classes) {
+		return t.nak("requested address %s is not permitted to the client", ip)
+	}
+	if err := subnet.Claim(ip, t.key); err != nil {
+		return t.nak("%s", err.Error())
+	}
+
+	s.limitLeaseTime(t, ip)
+	var fqdn string
+	var forward bool
+	if t.st.ddns != nil {
+		var option81 dhcp4.OptionInter
+		if fqdn, forward, option81 = t.clientName(t.st.ddns); option81 != nil {
+			t.extra = append(t.extra, option81)
+		}
+	}
+	reply, leaseTime := t.reply(dhcp4.MessageTypeAck, subnet, ip)
+	now := time.Now()
+	lease := &Lease{IP: ip.To4(), Client: t.key, MAC: t.r.MAC.String(), HType: t.m.HardwareType, FQDN: fqdn, Start: now}
+	if leaseTime != dhcp4.InfiniteLeaseTime {
+		lease.Expiry = now.Add(time.Duration(leaseTime) * time.Second)
+	}
+	previous, ok, err := s.Store.Get(ip)
+	if err != nil || !ok {
+		previous = nil
+	}
+	if previous != nil && previous.Client == t.key {
+		lease.PartnerExpiry = previous.PartnerExpiry
+	}
+	if option12, ok := t.m.Option(12).(dhcp4.Option12); ok {
+		lease.HostName = string(option12.HostName)
+	}
+	if err := s.Store.Put(lease); err != nil {
+		//a lease must be stored before it is acknowledged
+		fmt.Printf("store lease %s of %s failed:%s\n", ip, t.key, err.Error())
+		return nil
+	}
+	if s.failover != nil {
+		var potential time.Time
+		if t.leaseTime != dhcp4.InfiniteLeaseTime {
+			potential = now.Add(time.Duration(t.leaseTime) * time.Second)
+		}
+		s.failover.update(lease, potential)
+	}
+	if t.st.ddns != nil {
+		s.updateDNS(t.st.ddns, previous, lease, forward)
+	}
+
+	s.offersMu.Lock()
+	delete(s.offers, t.key)
+	s.offersMu.Unlock()
+	copy(reply.ClientIP, t.m.ClientIP)
+	return reply
+}
+
+// known reports whether the server has a record of ip for the client: a lease, an offer or a reservation.
+func (s *Server) known(t *transaction, subnet *Subnet, ip net.IP) bool {
+	if l, ok, err := s.Store.Get(ip); err == nil && ok && l.Client == t.key {
+		return true
+	}
+	if owner, ok := subnet.Owner(ip); ok && owner == t.key {
+		return true
+	}
+	reserved, ok := subnet.Reservation(t.r)
+	return ok && reserved.Equal(ip)
+}
+
+// withdrawOffer releases the address offered to the client with key unless it holds a lease on it.
+func (s *Server) withdrawOffer(key string) {
+	s.offersMu.Lock()
+	o, ok := s.offers[key]
+	delete(s.offers, key)
+	s.offersMu.Unlock()
+	if ok {
+		s.releaseOffer(key, o)
+	}
+}
+
+func (s *Server) releaseOffer(key string, o offer) {
+	if l, ok, err := s.Store.Get(o.ip); err == nil && ok && l.Client == key {
+		return
+	}
+	if owner, ok := o.subnet.Owner(o.ip); ok && owner == key {
+		o.subnet.Release(o.ip)
+	}
+}
+
+// decline abandons the address the client found in use.
+func (s *Server) decline(t *transaction) {
+	ip := t.r.RequestedIP
+	subnet := t.st.pool.Subnet(ip)
+	if subnet == nil {
+		return
+	}
+	if owner, ok := subnet.Owner(ip); !ok || owner != t.key {
+		return
+	}
+
+	fmt.Printf("%s declined %s\n", t.key, ip)
+	subnet.Abandon(ip)
+	s.deleteLease(t.st, ip, t.key)
+}
+
+func (s *Server) release(t *transaction) {
+	ip := net.IP(t.m.ClientIP)
+	subnet := t.st.pool.Subnet(ip)
+	if subnet == nil {
+		return
+	}
+	if owner, ok := subnet.Owner(ip); !ok || owner != t.key {
+		return
+	}
+
+	subnet.Release(ip)
+	s.deleteLease(t.st, ip, t.key)
+}
+
+// deleteLease deletes the lease of ip the client with key gave up, on the failover partner too.
+func (s *Server) deleteLease(st *settings, ip net.IP, key string) {
+	if l, ok, err := s.Store.Get(ip); err == nil && ok && l.Client == key {
+		s.removeDNS(st.ddns, l)
+	}
+	if err := s.Store.Delete(ip); err != nil {
+		fmt.Printf("delete lease %s failed:%s\n", ip, err.Error())
+	}
+	if s.failover != nil {
+		s.failover.delete(&Lease{IP: ip, Client: key, Start: time.Now()})
+	}
+}
+
+// limitLeaseTime limits the lease time of ip to what failover allows.
+func (s *Server) limitLeaseTime(t *transaction, ip net.IP) {
+	if s.failover != nil {
+		t.maxLeaseTime = s.failover.maxLeaseTime(ip, t.key, time.Now())
+	}
+}
+
+// inform answers DHCPINFORM with the options for the client address, without a lease.
+func (s *Server) inform(t *transaction) *dhcp4.Message {
+	ip := net.IP(t.m.ClientIP)
+	subnet := t.st.pool.Subnet(ip)
+	if subnet == nil {
+		return nil
+	}
+	reply, _ := t.reply(dhcp4.MessageTypeAck, subnet, nil)
+	copy(reply.ClientIP, t.m.ClientIP)
+	return reply
+}
+
+// nak refuses the request, the reason is sent in option 56.
+func (t *transaction) nak(format string, a ...interface{}) *dhcp4.Message {
+	reason := fmt.Sprintf(format, a...)
+	fmt.Printf("NAK %s:%s\n", t.key, reason)
+	return dhcp4.GenReplyMessage(t.m, dhcp4.MessageTypeNak,
+		dhcp4.GenOption54(t.serverID), dhcp4.GenOptionRaw(56, []byte(reason)))
+}
+
+// refuse answers a DHCPREQUEST the server cannot acknowledge: with DHCPNAK when it is authoritative,
+// or in SELECTING where the client asked this server, otherwise the request is ignored.
+func (t *transaction) refuse(state, format string, a ...interface{}) *dhcp4.Message {
+	if state == stateSelecting || t.st.config.Authoritative {
+		return t.nak(format, a...)
+	}
+	fmt.Printf("ignore %s DHCPREQUEST of %s, not authoritative:%s\n", state, t.key, fmt.Sprintf(format, a...))
+	return nil
+}
+
+// reply builds an OFFER or ACK of ip in subnet with the options of the client's scope,
+// returning the lease time granted. A nil ip answers DHCPINFORM, without lease times.
+func (t *transaction) reply(mt dhcp4.MessageType, subnet *Subnet, ip net.IP) (*dhcp4.Message, uint32) {
+	sc := t.st.scope(subnet, ip, t.classes, t.host)
+	options := []dhcp4.OptionInter{dhcp4.GenOption54(t.serverID)}
+
+	var leaseTime uint32
+	if ip != nil {
+		var requested uint32
+		if option51, ok := t.m.Option(51).(dhcp4.Option51); ok {
+			requested = dhcp4.BytesToUint32(option51.LeaseTime)
+		}
+		var t1, t2 uint32
+		leaseTime, t1, t2 = sc.times(requested)
+		t.leaseTime = leaseTime
+		if t.maxLeaseTime != 0 && leaseTime > t.maxLeaseTime {
+			leaseTime, t1, t2 = t.maxLeaseTime, t.maxLeaseTime/2, uint32(uint64(t.maxLeaseTime)*7/8)
+		}
+		options = append(options, dhcp4.GenOption51(leaseTime))
+		if leaseTime != dhcp4.InfiniteLeaseTime {
+			options = append(options, dhcp4.GenOptionRaw(58, dhcp4.Uint32ToBytes(t1)),
+				dhcp4.GenOptionRaw(59, dhcp4.Uint32ToBytes(t2)))
+		}
+	}
+
+	configured := sc.options
+	if _, ok := configured[1]; !ok {
+		configured = configured.merge(optionSet{1: dhcp4.GenOptionRaw(1, subnet.Network.Mask)})
+	}
+	if t.host != nil && t.host.hostName != "" {
+		configured = configured.merge(optionSet{12: dhcp4.GenOption12(t.host.hostName)})
+	}
+	options = append(options, t.extra...)
+	options = append(options, requestedOptions(t.m, configured)...)
+
+	reply := dhcp4.GenReplyMessage(t.m, mt, options...)
+	if ip != nil {
+		copy(reply.YourIP, ip.To4())
+	}
+	return reply, leaseTime
+}
+
+// requestedOptions returns the options of set in the order of the parameter request list of m,
+// the subnet mask always first. Without a list all options are returned.
+func requestedOptions(m *dhcp4.Message, set optionSet) []dhcp4.OptionInter {
+	option55, ok := m.Option(55).(dhcp4.Option55)
+	if !ok {
+		return set.sorted()
+	}
+
+	options := []dhcp4.OptionInter{set[1]}
+	for _, code := range option55.Parameters {
+		if option, ok := set[code]; ok && code != 1 {
+			options = append(options, option)
+		}
+	}
+	return options
+}
+
+// sweep releases expired offers and leases until the server is closed.
+func (s *Server) sweep() {
+	ticker := time.NewTicker(SweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.sweepOffers(now)
+			s.sweepLeases(now)
+			s.mu.RLock()
+			s.settings.limits.sweep(now)
+			s.mu.RUnlock()
+		}
+	}
+}
+
+func (s *Server) sweepOffers(now time.Time) {
+	s.offersMu.Lock()
+	expired := make(map[string]offer)
+	for key, o := range s.offers {
+		if now.After(o.expiry) {
+			expired[key] = o
+			delete(s.offers, key)
+		}
+	}
+	s.offersMu.Unlock()
+
+	for key, o := range expired {
+		s.releaseOffer(key, o)
+	}
+}
+
+func (s *Server) sweepLeases(now time.Time) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	leases, err := Sweep(s.Store, now)
+	if err != nil {
+		fmt.Printf("sweep leases failed:%s\n", err.Error())
+	}
+	for _, l := range leases {
+		fmt.Printf("lease %s of %s expired\n", l.IP, l.Client)
+		s.removeDNS(s.settings.ddns, l)
+		if subnet := s.settings.pool.Subnet(l.IP); subnet != nil {
+			if owner, ok := subnet.Owner(l.IP); ok && owner == l.Client {
+				subnet.Release(l.IP)
+			}
+		}
+	}
+}
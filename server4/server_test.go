@@ -0,0 +1,224 @@
+package server4
+
+import (
+	"context"
+	"fmt"
+	"github.com/Kseleven/agile-dhcp/dhcp4"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+var loopback = net.IPv4(127, 0, 0, 1).To4()
+
+// newTestServer returns a server of 127.0.0.0/24, the network of the loopback interface.
+func newTestServer(t *testing.T, authoritative bool) *Server {
+	src := fmt.Sprintf(`{"interfaces": ["lo"], "authoritative": %t, "leaseTime": 3600,
+	"subnets": [{"subnet": "127.0.0.0/24", "pools": [{"range": "127.0.0.100-127.0.0.150"}]}]}`, authoritative)
+	config, err := ParseConfig("test.json", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewServer(config, NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// serveLoopback answers the messages sent to 127.0.0.1:67 with s.Handle until the test ends.
+// Broadcast replies are sent to 127.0.0.1, the loopback interface does not broadcast.
+func serveLoopback(t *testing.T, s *Server) {
+	lc := net.ListenConfig{Control: socketControl("")}
+	//the clients listen on the wildcard address of the client port, or of the server port as a relay
+	for _, address := range []string{"0.0.0.0:67", "0.0.0.0:68"} {
+		pc, err := lc.ListenPacket(context.Background(), "udp4", address)
+		if err != nil {
+			t.Skipf("listen on %s failed:%s", address, err.Error())
+		}
+		pc.Close()
+	}
+	pc, err := lc.ListenPacket(context.Background(), "udp4", "127.0.0.1:67")
+	if err != nil {
+		t.Skipf("listen on 127.0.0.1:67 failed:%s", err.Error())
+	}
+	conn := pc.(*net.UDPConn)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		data := make([]byte, dhcp4.MaxMessageSize)
+		for {
+			length, _, err := conn.ReadFromUDP(data)
+			if err != nil {
+				return
+			}
+			m := &dhcp4.Message{}
+			if err := m.Decode(data[:length]); err != nil || m.OpCode != 1 {
+				continue
+			}
+			reply := s.Handle(m, loopback)
+			if reply == nil {
+				continue
+			}
+			to := ReplyAddress(m, reply)
+			if to.IP.Equal(net.IPv4bcast) {
+				to.IP = loopback
+			}
+			conn.WriteToUDP(reply.Encode(), to)
+		}
+	}()
+}
+
+// runClient runs one exchange of a client of mac holding lease, relaying through relay if set,
+// and returns the events of its hooks: none when the server did not answer.
+func runClient(t *testing.T, mac, relay string, lease *dhcp4.Lease, send func(c *dhcp4.Conn) error) (*dhcp4.Conn, []dhcp4.LeaseEvent) {
+	c, err := dhcp4.NewDHCPRequest("127.0.0.1", relay, "", mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var events []dhcp4.LeaseEvent
+	c.Hooks = []dhcp4.LeaseHook{dhcp4.LeaseHookFunc(func(event dhcp4.LeaseEvent, ifname string, old, new *dhcp4.Lease) error {
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+		return nil
+	})}
+	c.Lease = lease
+	//the client listens in the background
+	time.Sleep(100 * time.Millisecond)
+	if err := send(c); err != nil {
+		t.Fatal(err)
+	}
+	c.WaitDone()
+	mu.Lock()
+	defer mu.Unlock()
+	return c, events
+}
+
+// bound returns the lease a client of mac acquires, relaying through relay if set.
+func bound(t *testing.T, mac, relay string) *dhcp4.Lease {
+	c, events := runClient(t, mac, relay, nil, (*dhcp4.Conn).Discovery)
+	if len(events) != 1 || events[0] != dhcp4.EventBound || c.Lease == nil {
+		t.Fatalf("discover of %s got events %v", mac, events)
+	}
+	return c.Lease
+}
+
+func TestRequestStates(t *testing.T) {
+	if testing.Short() {
+		t.Skip("the silent requests wait for the client timeout")
+	}
+	wrongNetwork := net.IPv4(10, 0, 0, 5).To4()
+	unknown := net.IPv4(127, 0, 0, 140).To4()
+	for _, authoritative := range []bool{true, false} {
+		t.Run(fmt.Sprintf("authoritative=%t", authoritative), func(t *testing.T) {
+			serveLoopback(t, newTestServer(t, authoritative))
+			//a request the server has no record of is refused by an authoritative server only
+			var refused []dhcp4.LeaseEvent
+			if authoritative {
+				refused = []dhcp4.LeaseEvent{dhcp4.EventNak}
+			}
+			cases := []struct {
+				name  string
+				mac   string
+				relay string
+				lease func(mac, relay string) *dhcp4.Lease
+				send  func(c *dhcp4.Conn) error
+				want  []dhcp4.LeaseEvent
+			}{
+				{
+					name: "selecting",
+					mac:  "00:00:00:00:01:01",
+					send: (*dhcp4.Conn).Discovery,
+					want: []dhcp4.LeaseEvent{dhcp4.EventBound},
+				},
+				{
+					name:  "init-reboot",
+					mac:   "00:00:00:00:01:02",
+					lease: func(mac, relay string) *dhcp4.Lease { return bound(t, mac, relay) },
+					send:  func(c *dhcp4.Conn) error { return c.Reboot(c.Lease.ClientIP) },
+					want:  []dhcp4.LeaseEvent{dhcp4.EventReboot},
+				},
+				{
+					name: "init-reboot wrong network",
+					mac:  "00:00:00:00:01:03",
+					send: func(c *dhcp4.Conn) error { return c.Reboot(wrongNetwork) },
+					want: []dhcp4.LeaseEvent{dhcp4.EventNak},
+				},
+				{
+					name: "init-reboot unknown",
+					mac:  "00:00:00:00:01:04",
+					send: func(c *dhcp4.Conn) error { return c.Reboot(unknown) },
+					want: refused,
+				},
+				{
+					name:  "renewing",
+					mac:   "00:00:00:00:01:05",
+					lease: func(mac, relay string) *dhcp4.Lease { return bound(t, mac, relay) },
+					send:  (*dhcp4.Conn).Renew,
+					want:  []dhcp4.LeaseEvent{dhcp4.EventRenew},
+				},
+				{
+					name: "renewing unknown",
+					mac:  "00:00:00:00:01:06",
+					lease: func(mac, relay string) *dhcp4.Lease {
+						return &dhcp4.Lease{ClientIP: unknown, ServerIdentifier: loopback}
+					},
+					send: (*dhcp4.Conn).Renew,
+					want: refused,
+				},
+				{
+					name:  "rebinding",
+					mac:   "00:00:00:00:01:07",
+					relay: "127.0.0.2",
+					lease: func(mac, relay string) *dhcp4.Lease { return bound(t, mac, relay) },
+					send:  (*dhcp4.Conn).Rebind,
+					want:  []dhcp4.LeaseEvent{dhcp4.EventRebind},
+				},
+				{
+					name:  "rebinding wrong network",
+					mac:   "00:00:00:00:01:08",
+					relay: "127.0.0.2",
+					lease: func(mac, relay string) *dhcp4.Lease { return &dhcp4.Lease{ClientIP: wrongNetwork} },
+					send:  (*dhcp4.Conn).Rebind,
+					want:  []dhcp4.LeaseEvent{dhcp4.EventNak},
+				},
+				{
+					name:  "rebinding unknown",
+					mac:   "00:00:00:00:01:09",
+					relay: "127.0.0.2",
+					lease: func(mac, relay string) *dhcp4.Lease { return &dhcp4.Lease{ClientIP: unknown} },
+					send:  (*dhcp4.Conn).Rebind,
+					want:  refused,
+				},
+			}
+			for _, tc := range cases {
+				var lease *dhcp4.Lease
+				if tc.lease != nil {
+					lease = tc.lease(tc.mac, tc.relay)
+				}
+				if _, events := runClient(t, tc.mac, tc.relay, lease, tc.send); fmt.Sprint(events) != fmt.Sprint(tc.want) {
+					t.Errorf("%s: got events %v, want %v", tc.name, events, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRequestMalformedServerIdentifier(t *testing.T) {
+	s := newTestServer(t, true)
+	m := dhcp4.GenRebootMessage("00:00:00:00:02:01", net.IPv4(127, 0, 0, 100).To4(), dhcp4.GenOption61([]byte{0, 0, 0, 0, 2, 1}))
+	m.Options = append(m.Options[:len(m.Options)-1], dhcp4.GenOptionRaw(54, []byte{127, 0, 0}), dhcp4.GenOption255())
+	d := &dhcp4.Message{}
+	if err := d.Decode(m.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.Option(54).(dhcp4.OptionRaw); !ok {
+		t.Fatalf("option 54 of 3 bytes decoded as %T", d.Option(54))
+	}
+	if reply := s.Handle(d, loopback); reply != nil {
+		t.Errorf("got %s to a request with a malformed server identifier", reply.MessageType)
+	}
+}
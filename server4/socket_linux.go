@@ -0,0 +1,26 @@
+//go:build linux
+
+package server4
+
+import (
+	"syscall"
+)
+
+// socketControl binds the server socket to the interface it serves, with one socket per interface on the server port.
+func socketControl(ifname string) func(network, address string, conn syscall.RawConn) error {
+	return func(network, address string, conn syscall.RawConn) error {
+		var err error
+		controlErr := conn.Control(func(fd uintptr) {
+			if err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+				return
+			}
+			if ifname != "" {
+				err = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, ifname)
+			}
+		})
+		if controlErr != nil {
+			return controlErr
+		}
+		return err
+	}
+}
@@ -0,0 +1,123 @@
+package server4
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+)
+
+// Store keeps the leases of the server across restarts, one lease per address.
+// Leases passed to and returned by a Store are copies.
+type Store interface {
+	Put(l *Lease) error
+	Get(ip net.IP) (*Lease, bool, error)
+	Delete(ip net.IP) error
+	Leases() ([]*Lease, error) //ordered by address
+	Compact() error            //drops the records superseded by later writes
+	Close() error
+}
+
+const (
+	StoreMemory  = "memory"
+	StoreJournal = "journal"
+	StoreKV      = "kv"
+)
+
+// OpenStore opens a store of kind, which is memory, journal or kv, in path.
+func OpenStore(kind, path string) (Store, error) {
+	switch kind {
+	case StoreMemory, "":
+		return NewMemoryStore(), nil
+	case StoreJournal:
+		return OpenJournalStore(path)
+	case StoreKV:
+		return OpenKVStore(path)
+	default:
+		return nil, fmt.Errorf("unknown lease store %s", kind)
+	}
+}
+
+func storeKey(ip net.IP) (uint32, error) {
+	if ip.To4() == nil {
+		return 0, fmt.Errorf("invalid lease address %s", ip)
+	}
+	return ipToUint32(ip), nil
+}
+
+// leaseMap is the set of leases kept in memory by MemoryStore and JournalStore.
+type leaseMap map[uint32]*Lease
+
+func (m leaseMap) sorted() []*Lease {
+	leases := make([]*Lease, 0, len(m))
+	for _, l := range m {
+		leases = append(leases, l.clone())
+	}
+	sort.Slice(leases, func(i, j int) bool {
+		return ipToUint32(leases[i].IP) < ipToUint32(leases[j].IP)
+	})
+	return leases
+}
+
+// MemoryStore keeps leases in memory only, they are lost on restart.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	leases leaseMap
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{leases: make(leaseMap)}
+}
+
+func (s *MemoryStore) Put(l *Lease) error {
+	key, err := storeKey(l.IP)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.leases[key] = l.clone()
+	return nil
+}
+
+func (s *MemoryStore) Get(ip net.IP) (*Lease, bool, error) {
+	key, err := storeKey(ip)
+	if err != nil {
+		return nil, false, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	l, ok := s.leases[key]
+	if !ok {
+		return nil, false, nil
+	}
+	return l.clone(), true, nil
+}
+
+func (s *MemoryStore) Delete(ip net.IP) error {
+	key, err := storeKey(ip)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.leases, key)
+	return nil
+}
+
+func (s *MemoryStore) Leases() ([]*Lease, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.leases.sorted(), nil
+}
+
+func (s *MemoryStore) Compact() error {
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,280 @@
+// Package tftp implements a TFTP read client (RFC 1350) with the option extension (RFC 2347),
+// the blocksize option (RFC 2348) and the transfer size option (RFC 2349).
+package tftp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	ServerPort       = 69
+	DefaultBlockSize = 512
+	MaxBlockSize     = 65464
+)
+
+const (
+	opRRQ   uint16 = 1
+	opDATA  uint16 = 3
+	opACK   uint16 = 4
+	opERROR uint16 = 5
+	opOACK  uint16 = 6
+)
+
+type Options struct {
+	BlockSize int           //blksize option, DefaultBlockSize disables the option
+	Timeout   time.Duration //time to wait for a packet before retransmitting
+	Retries   int           //retransmissions before giving up
+}
+
+var DefaultOptions = Options{BlockSize: 1468, Timeout: 3 * time.Second, Retries: 5}
+
+type Result struct {
+	Size         int64 //octets received
+	TransferSize int64 //size announced by the server with tsize, -1 if unknown
+	BlockSize    int   //block size in use
+	SHA256       []byte
+	Duration     time.Duration
+}
+
+// Error is an ERROR packet sent by the server.
+type Error struct {
+	Code    uint16
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("tftp error %d:%s", e.Code, e.Message)
+}
+
+// Get downloads filename from server (host or host:port) in octet mode and writes it to w.
+// The size announced with tsize is verified against the octets received.
+func Get(server, filename string, w io.Writer, opts Options) (*Result, error) {
+	if opts.BlockSize == 0 {
+		opts.BlockSize = DefaultOptions.BlockSize
+	}
+	if opts.BlockSize < 8 || opts.BlockSize > MaxBlockSize {
+		return nil, fmt.Errorf("invalid block size %d", opts.BlockSize)
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultOptions.Timeout
+	}
+	if opts.Retries == 0 {
+		opts.Retries = DefaultOptions.Retries
+	}
+
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, strconv.Itoa(ServerPort))
+	}
+	raddr, err := net.ResolveUDPAddr("udp4", server)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	t := &transfer{
+		conn:         conn,
+		server:       raddr,
+		opts:         opts,
+		blockSize:    DefaultBlockSize,
+		transferSize: -1,
+		hash:         sha256.New(),
+		w:            w,
+		buf:          make([]byte, MaxBlockSize+4),
+	}
+	start := time.Now()
+	if err := t.run(filename); err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		Size:         t.size,
+		TransferSize: t.transferSize,
+		BlockSize:    t.blockSize,
+		SHA256:       t.hash.Sum(nil),
+		Duration:     time.Since(start),
+	}, nil
+}
+
+type transfer struct {
+	conn         *net.UDPConn
+	server       *net.UDPAddr
+	tid          *net.UDPAddr //address the server answers from
+	opts         Options
+	blockSize    int
+	transferSize int64
+	size         int64
+	hash         hash.Hash
+	w            io.Writer
+	buf          []byte
+}
+
+func (t *transfer) run(filename string) error {
+	last := readRequest(filename, t.opts.BlockSize)
+	to := t.server
+	var block uint16
+	for {
+		op, payload, err := t.exchange(last, to)
+		if err != nil {
+			return err
+		}
+		to = t.tid
+
+		switch op {
+		case opOACK:
+			if block != 0 || t.size != 0 {
+				continue
+			}
+			if err := t.acceptOptions(payload); err != nil {
+				t.sendError(8, err.Error())
+				return err
+			}
+			last = ack(0)
+		case opDATA:
+			if len(payload) < 2 {
+				return errors.New("tftp: short DATA packet")
+			}
+			n := binaryUint16(payload)
+			if n != block+1 {
+				//duplicate of a block already written, acknowledge it again
+				continue
+			}
+			data := payload[2:]
+			if _, err := t.w.Write(data); err != nil {
+				t.sendError(3, "write failed")
+				return err
+			}
+			t.hash.Write(data)
+			t.size += int64(len(data))
+			block = n
+			last = ack(block)
+			if len(data) < t.blockSize {
+				_, err := t.conn.WriteToUDP(last, t.tid)
+				if err == nil && t.transferSize >= 0 && t.transferSize != t.size {
+					err = fmt.Errorf("tftp: received %d octets, server announced %d", t.size, t.transferSize)
+				}
+				return err
+			}
+		case opERROR:
+			e := &Error{}
+			if len(payload) >= 2 {
+				e.Code = binaryUint16(payload)
+				e.Message = string(bytes.TrimRight(payload[2:], "\x00"))
+			}
+			return e
+		default:
+			return fmt.Errorf("tftp: unexpected opcode %d", op)
+		}
+	}
+}
+
+// exchange sends packet to addr and waits for an answer from the transfer's TID, retransmitting on timeout.
+func (t *transfer) exchange(packet []byte, addr *net.UDPAddr) (uint16, []byte, error) {
+	for retry := 0; retry <= t.opts.Retries; retry++ {
+		if _, err := t.conn.WriteToUDP(packet, addr); err != nil {
+			return 0, nil, err
+		}
+
+		deadline := time.Now().Add(t.opts.Timeout)
+		for {
+			t.conn.SetReadDeadline(deadline)
+			n, from, err := t.conn.ReadFromUDP(t.buf)
+			if err != nil {
+				if op, ok := err.(net.Error); ok && op.Timeout() {
+					break
+				}
+				return 0, nil, err
+			}
+			if n < 2 || !from.IP.Equal(t.server.IP) {
+				continue
+			}
+			if t.tid == nil {
+				t.tid = from
+			} else if from.Port != t.tid.Port {
+				errPacket := errorPacket(5, "unknown transfer ID")
+				t.conn.WriteToUDP(errPacket, from)
+				continue
+			}
+			return binaryUint16(t.buf), t.buf[2:n], nil
+		}
+	}
+
+	return 0, nil, fmt.Errorf("tftp: no answer from %s after %d retries", addr, t.opts.Retries)
+}
+
+// acceptOptions applies the options acknowledged by the server in an OACK.
+func (t *transfer) acceptOptions(payload []byte) error {
+	fields := bytes.Split(bytes.TrimSuffix(payload, []byte{0}), []byte{0})
+	for i := 0; i+1 < len(fields); i += 2 {
+		value, err := strconv.ParseInt(string(fields[i+1]), 10, 64)
+		if err != nil {
+			return fmt.Errorf("tftp: invalid value of option %s", fields[i])
+		}
+		switch string(bytes.ToLower(fields[i])) {
+		case "blksize":
+			if value < 8 || value > int64(t.opts.BlockSize) {
+				return fmt.Errorf("tftp: server block size %d not acceptable", value)
+			}
+			t.blockSize = int(value)
+		case "tsize":
+			t.transferSize = value
+		}
+	}
+
+	return nil
+}
+
+func (t *transfer) sendError(code uint16, message string) {
+	if t.tid != nil {
+		t.conn.WriteToUDP(errorPacket(code, message), t.tid)
+	}
+}
+
+func readRequest(filename string, blockSize int) []byte {
+	var buf bytes.Buffer
+	buf.Write(uint16Bytes(opRRQ))
+	buf.WriteString(filename)
+	buf.WriteByte(0)
+	buf.WriteString("octet")
+	buf.WriteByte(0)
+	if blockSize != DefaultBlockSize {
+		buf.WriteString("blksize")
+		buf.WriteByte(0)
+		buf.WriteString(strconv.Itoa(blockSize))
+		buf.WriteByte(0)
+	}
+	buf.WriteString("tsize")
+	buf.WriteByte(0)
+	buf.WriteString("0")
+	buf.WriteByte(0)
+	return buf.Bytes()
+}
+
+func ack(block uint16) []byte {
+	return append(uint16Bytes(opACK), uint16Bytes(block)...)
+}
+
+func errorPacket(code uint16, message string) []byte {
+	b := append(uint16Bytes(opERROR), uint16Bytes(code)...)
+	b = append(b, message...)
+	return append(b, 0)
+}
+
+func uint16Bytes(v uint16) []byte {
+	return []byte{byte(v >> 8), byte(v)}
+}
+
+func binaryUint16(b []byte) uint16 {
+	return uint16(b[0])<<8 | uint16(b[1])
+}
@@ -0,0 +1,265 @@
+package tftp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var testOptions = Options{BlockSize: 1468, Timeout: time.Second, Retries: 1}
+
+// stubSession is a transfer of the stub server, it answers from its own port(TID).
+type stubSession struct {
+	conn    *net.UDPConn
+	client  *net.UDPAddr
+	options map[string]string //options of the read request
+	buf     []byte
+}
+
+// startStub answers one read request with serve, run on a new session, and returns the address of the server.
+func startStub(t *testing.T, serve func(s *stubSession) error) string {
+	listener, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	errs := make(chan error, 1)
+	t.Cleanup(func() {
+		if err := <-errs; err != nil {
+			t.Errorf("stub server:%s", err.Error())
+		}
+	})
+
+	go func() {
+		buf := make([]byte, 1500)
+		n, client, err := listener.ReadFromUDP(buf)
+		if err != nil {
+			errs <- err
+			return
+		}
+		if n < 2 || binaryUint16(buf) != opRRQ {
+			errs <- fmt.Errorf("got %x, want a read request", buf[:n])
+			return
+		}
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer conn.Close()
+
+		//filename, mode and option name/value pairs
+		fields := bytes.Split(bytes.TrimSuffix(buf[2:n], []byte{0}), []byte{0})
+		s := &stubSession{conn: conn, client: client, options: make(map[string]string), buf: make([]byte, MaxBlockSize+4)}
+		for i := 2; i+1 < len(fields); i += 2 {
+			s.options[string(fields[i])] = string(fields[i+1])
+		}
+		errs <- serve(s)
+	}()
+	return listener.LocalAddr().String()
+}
+
+func (s *stubSession) send(packet []byte) error {
+	_, err := s.conn.WriteToUDP(packet, s.client)
+	return err
+}
+
+func (s *stubSession) receive() (uint16, []byte, error) {
+	s.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := s.conn.ReadFromUDP(s.buf)
+	if err != nil {
+		return 0, nil, err
+	}
+	if n < 4 {
+		return 0, nil, fmt.Errorf("short packet %x", s.buf[:n])
+	}
+	return binaryUint16(s.buf), s.buf[2:n], nil
+}
+
+func (s *stubSession) expectACK(block uint16) error {
+	op, payload, err := s.receive()
+	if err != nil {
+		return err
+	}
+	if op != opACK || binaryUint16(payload) != block {
+		return fmt.Errorf("got opcode %d %x, want ACK %d", op, payload, block)
+	}
+	return nil
+}
+
+func (s *stubSession) oack(options ...string) error {
+	packet := uint16Bytes(opOACK)
+	for _, o := range options {
+		packet = append(append(packet, o...), 0)
+	}
+	return s.send(packet)
+}
+
+// sendFile sends content in blocks of blockSize, each acknowledged before the next.
+func (s *stubSession) sendFile(content []byte, blockSize int) error {
+	for block := 1; ; block++ {
+		start := (block - 1) * blockSize
+		end := start + blockSize
+		if end > len(content) {
+			end = len(content)
+		}
+		n := uint16(block) //wraps around after 65535
+		if err := s.send(append(append(uint16Bytes(opDATA), uint16Bytes(n)...), content[start:end]...)); err != nil {
+			return err
+		}
+		if err := s.expectACK(n); err != nil {
+			return err
+		}
+		if end-start < blockSize {
+			return nil
+		}
+	}
+}
+
+func testContent(size int) []byte {
+	content := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(content)
+	return content
+}
+
+func checkResult(t *testing.T, result *Result, got, content []byte) {
+	if !bytes.Equal(got, content) {
+		t.Errorf("received %d octets, want %d", len(got), len(content))
+	}
+	sum := sha256.Sum256(content)
+	if result.Size != int64(len(content)) || !bytes.Equal(result.SHA256, sum[:]) {
+		t.Errorf("result size %d sha256 %x", result.Size, result.SHA256)
+	}
+}
+
+func TestGetOptions(t *testing.T) {
+	content := testContent(3000)
+	server := startStub(t, func(s *stubSession) error {
+		if s.options["blksize"] != "1468" || s.options["tsize"] != "0" {
+			return fmt.Errorf("request options %v", s.options)
+		}
+		//a smaller block size than requested is accepted
+		if err := s.oack("blksize", "1024", "tsize", strconv.Itoa(len(content))); err != nil {
+			return err
+		}
+		if err := s.expectACK(0); err != nil {
+			return err
+		}
+		return s.sendFile(content, 1024)
+	})
+
+	var got bytes.Buffer
+	result, err := Get(server, "pxelinux.0", &got, testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResult(t, result, got.Bytes(), content)
+	if result.BlockSize != 1024 || result.TransferSize != int64(len(content)) {
+		t.Errorf("block size %d transfer size %d", result.BlockSize, result.TransferSize)
+	}
+}
+
+func TestGetOptionsIgnored(t *testing.T) {
+	content := testContent(1000)
+	//a server without the option extension sends the first block at once
+	server := startStub(t, func(s *stubSession) error { return s.sendFile(content, DefaultBlockSize) })
+
+	var got bytes.Buffer
+	result, err := Get(server, "pxelinux.0", &got, testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResult(t, result, got.Bytes(), content)
+	if result.BlockSize != DefaultBlockSize || result.TransferSize != -1 {
+		t.Errorf("block size %d transfer size %d", result.BlockSize, result.TransferSize)
+	}
+}
+
+func TestGetOptionsRejected(t *testing.T) {
+	server := startStub(t, func(s *stubSession) error {
+		//larger than requested
+		if err := s.oack("blksize", "2048"); err != nil {
+			return err
+		}
+		op, payload, err := s.receive()
+		if err != nil {
+			return err
+		}
+		if op != opERROR || binaryUint16(payload) != 8 {
+			return fmt.Errorf("got opcode %d %x, want ERROR 8", op, payload)
+		}
+		return nil
+	})
+
+	if _, err := Get(server, "pxelinux.0", &bytes.Buffer{}, testOptions); err == nil {
+		t.Error("block size larger than requested accepted")
+	}
+}
+
+func TestGetError(t *testing.T) {
+	server := startStub(t, func(s *stubSession) error { return s.send(errorPacket(1, "File not found")) })
+
+	_, err := Get(server, "missing", &bytes.Buffer{}, testOptions)
+	var e *Error
+	if !errors.As(err, &e) || e.Code != 1 || e.Message != "File not found" {
+		t.Errorf("got %v, want error 1 File not found", err)
+	}
+}
+
+func TestGetDuplicateBlock(t *testing.T) {
+	content := testContent(700)
+	server := startStub(t, func(s *stubSession) error {
+		first := append(append(uint16Bytes(opDATA), uint16Bytes(1)...), content[:512]...)
+		if err := s.send(first); err != nil {
+			return err
+		}
+		if err := s.expectACK(1); err != nil {
+			return err
+		}
+		//retransmitted as if the ACK was lost, it is acknowledged again and not written twice
+		if err := s.send(first); err != nil {
+			return err
+		}
+		if err := s.expectACK(1); err != nil {
+			return err
+		}
+		if err := s.send(append(append(uint16Bytes(opDATA), uint16Bytes(2)...), content[512:]...)); err != nil {
+			return err
+		}
+		return s.expectACK(2)
+	})
+
+	var got bytes.Buffer
+	result, err := Get(server, "pxelinux.0", &got, testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResult(t, result, got.Bytes(), content)
+}
+
+func TestGetBlockRollover(t *testing.T) {
+	//blocks 1 to 65535, then 0, 1 and a short last one
+	content := testContent(8*65537 + 3)
+	server := startStub(t, func(s *stubSession) error {
+		if err := s.oack("blksize", "8"); err != nil {
+			return err
+		}
+		if err := s.expectACK(0); err != nil {
+			return err
+		}
+		return s.sendFile(content, 8)
+	})
+
+	var got bytes.Buffer
+	result, err := Get(server, "pxelinux.0", &got, Options{BlockSize: 8, Timeout: time.Second, Retries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResult(t, result, got.Bytes(), content)
+}